@@ -5,26 +5,44 @@ import (
 	"cloudsyncer/toolkit"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 // Client is used by other components to perform network calls to server.
 // struct Client holds current work dir path, username and authorization token, and pointer to http.Client.
 type Client struct {
-	path      string
-	client    *http.Client
-	authToken string
-	hostname  string
-	cursor    string
-	username  string
+	path       string
+	client     *http.Client
+	authToken  string
+	hostname   string
+	cursor     string
+	username   string
+	loginMutex sync.Mutex
 }
 
+// Errors returned by Client.
+var (
+	ErrUnauthorized         = errors.New("session expired or revoked")
+	ErrOtpRequired          = errors.New("one-time password required")
+	ErrAuthorizationPending = errors.New("single sign-on not completed yet")
+	ErrSlowDown             = errors.New("single sign-on polled too often")
+	ErrRateLimited          = errors.New("too many requests")
+	ErrForbidden            = errors.New("access denied")
+)
+
+// Maximum time in seconds the client waits when server is rate limiting requests.
+const maxRetryAfter = 5 * 60
+
 // Creates and returns new instance of Client.
 func NewClient(path string) *Client {
 	c := Client{path: path}
@@ -49,13 +67,46 @@ func (c *Client) SetCredentials(token string, username string) {
 	c.username = username
 }
 
-// Performs call to remote register endpoint, and registers user. Might return error if something went wrong.
-func (c *Client) Register(username string, password string, computername string) (authToken string, err error) {
+// Violation of server registration policy, for example too weak password. Code is stable identifier of the violation,
+// Message might be displayed to the user.
+type PolicyError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// List of registration policy violations returned by server.
+type PolicyErrors []PolicyError
+
+func (e PolicyErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Returns true if one of the violations has given code.
+func (e PolicyErrors) Has(code string) bool {
+	for _, err := range e {
+		if err.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// Performs call to remote register endpoint, and registers user. Invite code is required only if server is in
+// invite-only mode. Returns PolicyErrors if server rejected registration because of its policy.
+func (c *Client) Register(username string, password string, computername string, invite string) (authToken string, err error) {
 	serverUrl := c.hostname + "/register"
 	data := url.Values{}
 	data.Set("username", username)
 	data.Add("password", password)
 	data.Add("computername", computername)
+	if invite != "" {
+		data.Add("invite", invite)
+	}
 	response, err := c.client.PostForm(serverUrl, data)
 	if err != nil {
 		log.Print("error on register: ", err)
@@ -65,6 +116,14 @@ func (c *Client) Register(username string, password string, computername string)
 	log.Print("received: ", response.Status, " ", err)
 	if response.StatusCode != http.StatusOK {
 		log.Print("register failed: ", response.Status)
+		var policy struct {
+			Errors PolicyErrors `json:"errors"`
+		}
+		if json.NewDecoder(response.Body).Decode(&policy) == nil && len(policy.Errors) > 0 {
+			err = policy.Errors
+		} else {
+			err = errors.New("received wrong status code: " + response.Status)
+		}
 		return
 	}
 	decoder := json.NewDecoder(response.Body)
@@ -81,12 +140,17 @@ func (c *Client) Register(username string, password string, computername string)
 }
 
 // Logs in  user with given password and computer name. Might return error if something went wrong.
-func (c *Client) Login(username string, password string, computername string) (authToken string, err error) {
+// code is one-time password, required only if user enabled two-factor authentication. If it is required but not given,
+// ErrOtpRequired is returned.
+func (c *Client) Login(username string, password string, computername string, code string) (authToken string, err error) {
 	serverUrl := c.hostname + "/login"
 	data := url.Values{}
 	data.Set("username", username)
 	data.Add("password", password)
 	data.Add("computername", computername)
+	if code != "" {
+		data.Add("code", code)
+	}
 	response, err := c.client.PostForm(serverUrl, data)
 	if err != nil {
 		log.Print("error on login: ", err)
@@ -94,6 +158,10 @@ func (c *Client) Login(username string, password string, computername string) (a
 	}
 	defer response.Body.Close()
 	log.Print("received: ", response.Status, " ", err)
+	if response.StatusCode == http.StatusUnauthorized && response.Header.Get("X-Cloudsyncer-Otp") == "required" {
+		err = ErrOtpRequired
+		return
+	}
 	if response.StatusCode != http.StatusOK {
 		log.Print("login failed: ", response.Status)
 		err = errors.New("received wrong status code: " + response.Status)
@@ -112,6 +180,66 @@ func (c *Client) Login(username string, password string, computername string) (a
 	return
 }
 
+// Performs authenticated request. If server rejects the session, user is asked to log in again
+// and ErrUnauthorized is returned, so the caller might retry the operation. If session is valid, but server
+// does not allow the request (read-only share, team viewer, API token scope, disabled account), ErrForbidden
+// is returned. If server rejects the request because of rate limit, waits as long as server requested
+// and returns ErrRateLimited.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	c.setAuth(req.Header)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusUnauthorized {
+		resp.Body.Close()
+		log.Print("server rejected session: ", resp.Status)
+		c.relogin(req.Header.Get("X-Cloudsyncer-Authtoken"))
+		return nil, ErrUnauthorized
+	}
+	if resp.StatusCode == http.StatusForbidden {
+		message, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		log.Printf("server denied %s %s: %s", req.Method, req.URL.Path, strings.TrimSpace(string(message)))
+		return nil, ErrForbidden
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		resp.Body.Close()
+		wait, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+		if err != nil || wait < 1 {
+			wait = 1
+		}
+		if wait > maxRetryAfter {
+			wait = maxRetryAfter
+		}
+		log.Printf("server is rate limiting requests, waiting %d seconds", wait)
+		time.Sleep(time.Duration(wait) * time.Second)
+		return nil, ErrRateLimited
+	}
+	return resp, nil
+}
+
+// Asks user to log in again and stores new credentials. Does nothing if credentials
+// have been already renewed since rejected token was used.
+func (c *Client) relogin(rejectedToken string) {
+	c.loginMutex.Lock()
+	defer c.loginMutex.Unlock()
+	if c.authToken != rejectedToken {
+		return
+	}
+	fmt.Println("Your session has expired or has been revoked. Please log in again.")
+	for {
+		username, token, computername, err := loginOrRegister(c)
+		if err == nil {
+			appConfig["username"] = username
+			appConfig["authencity_token"] = token
+			appConfig["computer_name"] = computername
+			c.SetCredentials(token, username)
+			return
+		}
+	}
+}
+
 func (c *Client) setAuth(header http.Header) {
 	//log.Printf("setting username: '%s' and token '%s'", c.username, c.authToken)
 	header.Set("X-Cloudsyncer-Authtoken", c.authToken)
@@ -150,9 +278,8 @@ func (c *Client) Upload(path string) (db.Metadata, error) {
 	if err != nil {
 		return db.Metadata{}, err
 	}
-	c.setAuth(req.Header)
-	req.Header.Set("Content-Length", string(fi.Size()))
-	resp, err := c.client.Do(req)
+	req.ContentLength = fi.Size()
+	resp, err := c.do(req)
 	if err != nil {
 		return db.Metadata{}, err
 	}
@@ -189,9 +316,8 @@ func (c *Client) Mkdir(path string) (db.Metadata, error) {
 	if err != nil {
 		return db.Metadata{}, err
 	}
-	c.setAuth(req.Header)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return db.Metadata{}, err
 	}
@@ -227,9 +353,8 @@ func (c *Client) Remove(path string) error {
 	if err != nil {
 		return err
 	}
-	c.setAuth(req.Header)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -249,8 +374,7 @@ func (c *Client) Poll(cursor string) (changes bool, err error) {
 	if err != nil {
 		return false, err
 	}
-	c.setAuth(req.Header)
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return false, err
 	}
@@ -277,8 +401,7 @@ func (c *Client) GetFile(path string, rev string) (io.ReadCloser, error) {
 	if err != nil {
 		return nil, err
 	}
-	c.setAuth(req.Header)
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -299,9 +422,8 @@ func (c *Client) GetDelta(cursor string) (Delta, error) {
 	if err != nil {
 		return Delta{}, err
 	}
-	c.setAuth(req.Header)
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
-	resp, err := c.client.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return Delta{}, err
 	}
@@ -316,3 +438,148 @@ func (c *Client) GetDelta(cursor string) (Delta, error) {
 	}
 	return Delta{}, errors.New("received wrong status: " + resp.Status)
 }
+
+// Session describes single device logged in to user account, as returned by server.
+type Session struct {
+	Id           int64  `json:"id"`
+	ComputerName string `json:"computername"`
+	Created      int64  `json:"created"`
+	LastSeen     int64  `json:"last_seen"`
+	Expires      int64  `json:"expires"`
+	Ip           string `json:"ip"`
+	Current      bool   `json:"current"`
+}
+
+// Retrieves list of devices logged in to user account.
+func (c *Client) GetSessions() ([]Session, error) {
+	req, err := http.NewRequest("GET", c.hostname+"/sessions", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		sessions := make([]Session, 0)
+		rawJson, _ := ioutil.ReadAll(resp.Body)
+		err = json.Unmarshal(rawJson, &sessions)
+		if err != nil {
+			return nil, err
+		}
+		return sessions, nil
+	}
+	return nil, errors.New("received wrong status: " + resp.Status)
+}
+
+// Searches remote files matching given parameters, as accepted by search endpoint of the server.
+// Returns found files and cursor of the next page, which is empty if there are no more files.
+func (c *Client) Search(params url.Values) ([]db.Metadata, string, error) {
+	req, err := http.NewRequest("GET", c.hostname+"/search?"+params.Encode(), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		var page struct {
+			Entries []db.Metadata `json:"entries"`
+			Cursor  string        `json:"cursor"`
+		}
+		rawJson, _ := ioutil.ReadAll(resp.Body)
+		err = json.Unmarshal(rawJson, &page)
+		if err != nil {
+			return nil, "", err
+		}
+		return page.Entries, page.Cursor, nil
+	}
+	return nil, "", errors.New("received wrong status: " + resp.Status)
+}
+
+// Logs out device with given session id. If id is 0, all other devices are logged out.
+func (c *Client) RevokeSession(id int64) error {
+	serverUrl := c.hostname + "/sessions/revoke_others"
+	data := url.Values{}
+	if id != 0 {
+		serverUrl = c.hostname + "/sessions/revoke"
+		data.Set("id", strconv.FormatInt(id, 10))
+	}
+	req, err := http.NewRequest("POST", serverUrl, strings.NewReader(data.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == 200 {
+		return nil
+	}
+	return errors.New("received wrong status: " + resp.Status)
+}
+
+// Device authorization started by StartDeviceLogin. User should open VerificationURI and enter UserCode.
+type DeviceAuthorization struct {
+	DeviceId                string `json:"device_id"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// Starts single sign-on login for device without browser.
+func (c *Client) StartDeviceLogin(computername string) (*DeviceAuthorization, error) {
+	data := url.Values{}
+	data.Set("computername", computername)
+	response, err := c.client.PostForm(c.hostname+"/oidc/device/start", data)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, errors.New("received wrong status code: " + response.Status)
+	}
+	authorization := new(DeviceAuthorization)
+	if err = json.NewDecoder(response.Body).Decode(authorization); err != nil {
+		return nil, err
+	}
+	return authorization, nil
+}
+
+// Checks whether user completed single sign-on started by StartDeviceLogin. Returns ErrAuthorizationPending
+// if user has not finished yet, ErrSlowDown if polling interval should be increased.
+func (c *Client) PollDeviceLogin(deviceId string) (username string, authToken string, err error) {
+	data := url.Values{}
+	data.Set("device_id", deviceId)
+	response, err := c.client.PostForm(c.hostname+"/oidc/device/poll", data)
+	if err != nil {
+		return "", "", err
+	}
+	defer response.Body.Close()
+	switch response.StatusCode {
+	case http.StatusOK:
+	case http.StatusAccepted:
+		return "", "", ErrAuthorizationPending
+	case http.StatusTooManyRequests:
+		return "", "", ErrSlowDown
+	default:
+		return "", "", errors.New("received wrong status code: " + response.Status)
+	}
+	var credentials struct {
+		Username        string `json:"username"`
+		AuthencityToken string `json:"authencity_token"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&credentials); err != nil {
+		return "", "", err
+	}
+	c.authToken = credentials.AuthencityToken
+	c.username = credentials.Username
+	return c.username, c.authToken, nil
+}
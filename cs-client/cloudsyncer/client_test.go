@@ -0,0 +1,70 @@
+package cloudsyncer
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestClientDoStatus(t *testing.T) {
+	tests := []struct {
+		status int
+		err    error
+	}{
+		{http.StatusOK, nil},
+		{http.StatusNotFound, nil},
+		{http.StatusForbidden, ErrForbidden},
+	}
+	for _, test := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(test.status)
+		}))
+		c := NewClient(t.TempDir())
+		c.hostname = server.URL
+		c.SetCredentials("token", "user")
+		req, err := http.NewRequest("GET", c.hostname+"/metadata/a.txt", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := c.do(req)
+		if err != test.err {
+			t.Errorf("status %d: do returned error %v, expected %v", test.status, err, test.err)
+		}
+		if err == nil {
+			if resp.StatusCode != test.status {
+				t.Errorf("status %d: do returned response with status %d", test.status, resp.StatusCode)
+			}
+			resp.Body.Close()
+		}
+		server.Close()
+	}
+}
+
+func TestRemoveRetriedAfterRateLimit(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		if r.FormValue("path") != "/a.txt" {
+			t.Errorf("request %d removes %q", requests, r.FormValue("path"))
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	w := &Worker{path: dir, client: NewClient(dir)}
+	w.client.hostname = server.URL
+	w.client.SetCredentials("token", "user")
+	start := time.Now()
+	w.removeRemoteFile(dir + "/a.txt")
+	if requests != 2 {
+		t.Errorf("server received %d requests, expected 2", requests)
+	}
+	if waited := time.Since(start); waited < time.Second {
+		t.Errorf("request was retried after %s, before Retry-After", waited)
+	}
+}
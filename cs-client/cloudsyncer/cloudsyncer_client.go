@@ -2,11 +2,13 @@ package cloudsyncer
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"strings"
 
@@ -34,10 +36,28 @@ var discard = make(map[string]bool)
 func register(client *Client) (username string, password string, computername string, err error) {
 	fmt.Println("Please provide your user data")
 	username, password, computername = getLoginAndPassword()
-	_, err = client.Register(username, password, computername)
+	_, err = client.Register(username, password, computername, "")
+	if policy, ok := err.(PolicyErrors); ok && policy.Has("invite_required") {
+		_, err = client.Register(username, password, computername, getInviteCode())
+	}
+	if policy, ok := err.(PolicyErrors); ok {
+		for _, violation := range policy {
+			fmt.Println(violation.Message)
+		}
+	}
 	return username, password, computername, err
 }
 
+func getInviteCode() (code string) {
+	reader := bufio.NewReader(os.Stdin)
+	for code == "" {
+		fmt.Print("\nRegistration requires an invite. Enter invite code: ")
+		code, _ = reader.ReadString('\n')
+		code = strings.TrimSpace(code)
+	}
+	return
+}
+
 func getLoginAndPassword() (username string, password string, computername string) {
 	reader := bufio.NewReader(os.Stdin)
 	for username == "" {
@@ -59,6 +79,16 @@ func getLoginAndPassword() (username string, password string, computername strin
 	return
 }
 
+func getOtpCode() (code string) {
+	reader := bufio.NewReader(os.Stdin)
+	for code == "" {
+		fmt.Print("\nEnter two-factor authentication code (or recovery code): ")
+		code, _ = reader.ReadString('\n')
+		code = strings.TrimSpace(code)
+	}
+	return
+}
+
 func prepareDatabase() (err error) {
 	if !toolkit.IsDirectory(getConfigFileDir()) {
 		err = os.Mkdir(getConfigFileDir(), 0770)
@@ -111,14 +141,18 @@ func loginOrRegister(client *Client) (username string, token string, computernam
 	choice := ""
 	password := ""
 	reader := bufio.NewReader(os.Stdin)
-	for choice != "1" && choice != "2" {
+	for choice != "1" && choice != "2" && choice != "3" {
 		fmt.Println("Do you want to register or login?")
 		fmt.Println("1) Register")
 		fmt.Println("2) Login")
-		fmt.Print("Enter your choice (1 or 2): ")
+		fmt.Println("3) Login with single sign-on")
+		fmt.Print("Enter your choice (1, 2 or 3): ")
 		choice, _ = reader.ReadString('\n')
 		choice = strings.TrimSpace(choice)
 	}
+	if choice == "3" {
+		return loginWithSingleSignOn(client)
+	}
 	if choice == "1" {
 	registration:
 		username, password, computername, err = register(client)
@@ -141,7 +175,10 @@ func loginOrRegister(client *Client) (username string, token string, computernam
 		username, password, computername = getLoginAndPassword()
 	}
 	log.Println("Trying to login...")
-	token, err = client.Login(username, password, computername)
+	token, err = client.Login(username, password, computername, "")
+	if err == ErrOtpRequired {
+		token, err = client.Login(username, password, computername, getOtpCode())
+	}
 	if err != nil {
 		log.Print("Unable to login")
 		return
@@ -153,6 +190,52 @@ func loginOrRegister(client *Client) (username string, token string, computernam
 	return
 }
 
+// Logs in using single sign-on device flow. User completes login in browser on any device,
+// while client waits for the server to confirm it.
+func loginWithSingleSignOn(client *Client) (username string, token string, computername string, err error) {
+	reader := bufio.NewReader(os.Stdin)
+	for computername == "" {
+		fmt.Print("\nEnter computer name: ")
+		computername, _ = reader.ReadString('\n')
+		computername = strings.TrimSpace(computername)
+	}
+	authorization, err := client.StartDeviceLogin(computername)
+	if err != nil {
+		log.Print("Unable to start single sign-on: ", err)
+		return
+	}
+	if authorization.VerificationURIComplete != "" {
+		fmt.Printf("\nTo log in, open %s\n", authorization.VerificationURIComplete)
+	} else {
+		fmt.Printf("\nTo log in, open %s and enter code %s\n", authorization.VerificationURI, authorization.UserCode)
+	}
+	interval := time.Duration(authorization.Interval) * time.Second
+	deadline := time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(interval)
+		username, token, err = client.PollDeviceLogin(authorization.DeviceId)
+		if err == ErrSlowDown {
+			interval += 5 * time.Second
+			continue
+		}
+		if err == ErrAuthorizationPending {
+			continue
+		}
+		if err != nil {
+			log.Print("Unable to login: ", err)
+			return
+		}
+		db.SetCfgValue("username", username)
+		db.SetCfgValue("authencity_token", token)
+		db.SetCfgValue("computer_name", computername)
+		log.Print("Login successful!")
+		return
+	}
+	err = errors.New("single sign-on has expired")
+	log.Print("Unable to login: ", err)
+	return
+}
+
 func warningClearDataFolder(dataFolder string) {
 	fmt.Printf("There was an unrecovarable error during application startup. Please manually remove the application folder %s", dataFolder)
 }
@@ -161,7 +244,10 @@ var confPath string = ""
 
 func Start() {
 	log.Println("Starting cloudsyncer client.")
-	confPath = *flag.String("cfgdir", "", "a string")
+	cfgdir := flag.String("cfgdir", "", "a string")
+	ws := flag.Bool("ws", false, "Sets client transmition to WebSocket")
+	flag.Parse()
+	confPath = *cfgdir
 	if !toolkit.IsDirectory(confPath) {
 		confPath = ""
 	}
@@ -174,7 +260,7 @@ func Start() {
 		}
 	}
 	// _ := *flag.Bool("reset", false, "removes all data from files table, sets cursor to 0")
-	appConfig["websocket"] = *flag.Bool("ws", false, "Sets client transmition to WebSocket")
+	appConfig["websocket"] = *ws
 	if toolkit.IsDirectory(getDbFilePath()) {
 		log.Println("Error - database path should be a file, is a directory")
 		warningClearDataFolder(getConfigFileDir())
@@ -213,6 +299,13 @@ func Start() {
 		log.Printf("worker error initializing database: %s", err)
 		os.Exit(1)
 	}
+	if flag.NArg() > 0 {
+		if err = runCommand(worker, flag.Args()); err != nil {
+			log.Printf("Error running command %s: %s", flag.Arg(0), err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
 	wg := new(sync.WaitGroup)
 	worker.Work()
 	watcher.AddExcludedFolder(getTmpDir())
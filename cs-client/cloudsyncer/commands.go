@@ -0,0 +1,275 @@
+package cloudsyncer
+
+import (
+	"cloudsyncer/cs-client/db"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command is an action invoked from command line, for example:
+//
+//	cloudsyncer exclude /photos
+//
+// It receives worker and remaining command line arguments.
+type command struct {
+	usage string
+	run   func(w *Worker, args []string) error
+}
+
+var commands = map[string]command{
+	"exclude":       {"exclude <path>    - stop syncing remote folder on this device", excludeCommand},
+	"include":       {"include <path>    - start syncing previously excluded remote folder", includeCommand},
+	"excluded":      {"excluded          - list remote folders excluded on this device", excludedCommand},
+	"hydrate":       {"hydrate <path>    - download online-only files at path", hydrateCommand},
+	"free":          {"free <path>       - free up space by making synced files at path online-only", freeCommand},
+	"online-only":   {"online-only on|off - create new remote files as online-only placeholders", onlineOnlyCommand},
+	"keep-offline":  {"keep-offline [rule] - always download files matching rule, list rules if none given", keepOfflineCommand},
+	"conflicts":     {"conflicts [strategy [path]] - resolve conflicts at path or everywhere with copy, newest, server, local or merge strategy, list strategies if none given", conflictsCommand},
+	"sessions":      {"sessions          - list devices logged in to your account", sessionsCommand},
+	"revoke":        {"revoke <id>       - log out device with given session id", revokeCommand},
+	"revoke-others": {"revoke-others     - log out all other devices", revokeOthersCommand},
+	"search":        {"search [options] [name] - find remote files by name or glob, options: -in <path> -ext <ext> -min-size <bytes> -max-size <bytes> -after <date> -before <date>", searchCommand},
+}
+
+// Runs command given as command line arguments. Returns error if command does not exist or has failed.
+func runCommand(w *Worker, args []string) error {
+	cmd, ok := commands[args[0]]
+	if !ok {
+		printUsage()
+		return errors.New("unknown command " + args[0])
+	}
+	return cmd.run(w, args[1:])
+}
+
+func printUsage() {
+	fmt.Println("Usage: cloudsyncer [flags] [command]")
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		fmt.Println("  " + cmd.usage)
+	}
+}
+
+// Checks whether exactly one path argument was given.
+func pathArgument(args []string) (string, error) {
+	if len(args) != 1 {
+		return "", errors.New("exactly one path is required")
+	}
+	return args[0], nil
+}
+
+func excludeCommand(w *Worker, args []string) error {
+	path, err := pathArgument(args)
+	if err != nil {
+		return err
+	}
+	if err = w.ExcludeFolder(path); err != nil {
+		return err
+	}
+	fmt.Printf("Folder %s is not synced anymore on this device\n", path)
+	return nil
+}
+
+func includeCommand(w *Worker, args []string) error {
+	path, err := pathArgument(args)
+	if err != nil {
+		return err
+	}
+	if err = w.IncludeFolder(path); err != nil {
+		return err
+	}
+	fmt.Printf("Folder %s is synced again on this device\n", path)
+	return nil
+}
+
+func excludedCommand(w *Worker, args []string) error {
+	folders, err := db.GetExcludedFolders()
+	if err != nil {
+		return err
+	}
+	for _, folder := range folders {
+		fmt.Println(folder)
+	}
+	return nil
+}
+
+func hydrateCommand(w *Worker, args []string) error {
+	path, err := pathArgument(args)
+	if err != nil {
+		return err
+	}
+	return w.Hydrate(path, false)
+}
+
+func freeCommand(w *Worker, args []string) error {
+	path, err := pathArgument(args)
+	if err != nil {
+		return err
+	}
+	freed, err := w.Dehydrate(path)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Freed %d bytes\n", freed)
+	return nil
+}
+
+func onlineOnlyCommand(w *Worker, args []string) error {
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return errors.New("on or off is required")
+	}
+	if !db.SetCfgValue(onlineOnlyKey, strconv.FormatBool(args[0] == "on")) {
+		return errors.New("unable to save configuration")
+	}
+	return nil
+}
+
+func keepOfflineCommand(w *Worker, args []string) error {
+	if len(args) == 0 {
+		fmt.Println(strings.TrimSpace(db.GetCfgValue(keepOfflineKey)))
+		return nil
+	}
+	return w.AddKeepOfflineRule(strings.Join(args, " "))
+}
+
+func conflictsCommand(w *Worker, args []string) error {
+	if len(args) == 0 {
+		strategy := db.GetCfgValue(conflictStrategyKey)
+		if strategy == "" {
+			strategy = DefaultConflicts
+		}
+		fmt.Printf("/\t%s\n", strategy)
+		for folder, strategy := range db.GetCfgValuesByPrefix(conflictStrategyKey + ":") {
+			fmt.Printf("%s\t%s\n", folder, strategy)
+		}
+		return nil
+	}
+	if len(args) > 2 {
+		return errors.New("strategy and at most one path are allowed")
+	}
+	if _, ok := conflictResolvers[args[0]]; !ok {
+		return errors.New("unknown conflict strategy " + args[0])
+	}
+	key := conflictStrategyKey
+	if len(args) == 2 && strings.Trim(args[1], "/") != "" {
+		key += ":/" + strings.Trim(strings.ToLower(args[1]), "/")
+	}
+	if !db.SetCfgValue(key, args[0]) {
+		return errors.New("unable to save configuration")
+	}
+	return nil
+}
+
+func sessionsCommand(w *Worker, args []string) error {
+	sessions, err := w.client.GetSessions()
+	if err != nil {
+		return err
+	}
+	for _, session := range sessions {
+		current := ""
+		if session.Current {
+			current = " (this device)"
+		}
+		fmt.Printf("%d\t%s%s\tcreated: %s\tlast seen: %s from %s\n", session.Id, session.ComputerName, current,
+			time.Unix(session.Created, 0).Format(time.RFC1123), time.Unix(session.LastSeen, 0).Format(time.RFC1123), session.Ip)
+	}
+	return nil
+}
+
+func revokeCommand(w *Worker, args []string) error {
+	if len(args) != 1 {
+		return errors.New("exactly one session id is required")
+	}
+	id, err := strconv.ParseInt(args[0], 10, 0)
+	if err != nil || id == 0 {
+		return errors.New("invalid session id " + args[0])
+	}
+	return w.client.RevokeSession(id)
+}
+
+func revokeOthersCommand(w *Worker, args []string) error {
+	return w.client.RevokeSession(0)
+}
+
+// Parses date given to search command, as YYYY-MM-DD or YYYY-MM-DD HH:MM in local time.
+func parseSearchDate(value string) (time.Time, error) {
+	for _, layout := range []string{"2006-01-02", "2006-01-02 15:04"} {
+		if date, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return date, nil
+		}
+	}
+	return time.Time{}, errors.New("invalid date " + value + ", expected YYYY-MM-DD or YYYY-MM-DD HH:MM")
+}
+
+// Parses arguments of search command to parameters of search request. Options might be given before
+// and after the name.
+func searchParams(args []string) (url.Values, error) {
+	flags := flag.NewFlagSet("search", flag.ContinueOnError)
+	folder := flags.String("in", "/", "remote folder to search in")
+	ext := flags.String("ext", "", "file extension")
+	minSize := flags.Int64("min-size", 0, "minimal size in bytes")
+	maxSize := flags.Int64("max-size", 0, "maximal size in bytes")
+	after := flags.String("after", "", "modified after date")
+	before := flags.String("before", "", "modified before date")
+	var names []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return nil, err
+		}
+		if flags.NArg() == 0 {
+			break
+		}
+		// parsing stops at first name, continue after it
+		names = append(names, flags.Arg(0))
+		args = flags.Args()[1:]
+	}
+	if len(names) > 1 {
+		return nil, errors.New("at most one name is allowed, quote glob patterns")
+	}
+	params := url.Values{}
+	params.Set("path", *folder)
+	params.Set("name", strings.Join(names, ""))
+	params.Set("ext", *ext)
+	if *minSize > 0 {
+		params.Set("min_size", strconv.FormatInt(*minSize, 10))
+	}
+	if *maxSize > 0 {
+		params.Set("max_size", strconv.FormatInt(*maxSize, 10))
+	}
+	dates := map[string]string{"modified_after": *after, "modified_before": *before}
+	for name, value := range dates {
+		if value == "" {
+			continue
+		}
+		date, err := parseSearchDate(value)
+		if err != nil {
+			return nil, err
+		}
+		params.Set(name, strconv.FormatInt(date.Unix(), 10))
+	}
+	return params, nil
+}
+
+func searchCommand(w *Worker, args []string) error {
+	params, err := searchParams(args)
+	if err != nil {
+		return err
+	}
+	for {
+		files, cursor, err := w.client.Search(params)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			fmt.Printf("%s\t%d\t%s\n", file.Path, file.Size, file.Modified.Local().Format(time.RFC1123))
+		}
+		if cursor == "" {
+			return nil
+		}
+		params.Set("cursor", cursor)
+	}
+}
@@ -0,0 +1,39 @@
+package cloudsyncer
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSearchParams(t *testing.T) {
+	after, _ := time.ParseInLocation("2006-01-02", "2026-01-02", time.Local)
+	tests := []struct {
+		args     []string
+		expected map[string]string
+		ok       bool
+	}{
+		{[]string{}, map[string]string{"path": "/", "name": "", "ext": ""}, true},
+		{[]string{"report"}, map[string]string{"path": "/", "name": "report"}, true},
+		{[]string{"-in", "/docs", "-ext", "pdf", "report"}, map[string]string{"path": "/docs", "name": "report", "ext": "pdf"}, true},
+		{[]string{"report", "-in", "/docs", "-ext", "pdf"}, map[string]string{"path": "/docs", "name": "report", "ext": "pdf"}, true},
+		{[]string{"-min-size", "10", "*.jpg", "-max-size", "20"}, map[string]string{"name": "*.jpg", "min_size": "10", "max_size": "20"}, true},
+		{[]string{"report", "-after", "2026-01-02"}, map[string]string{"name": "report", "modified_after": strconv.FormatInt(after.Unix(), 10)}, true},
+		{[]string{"report", "-after", "yesterday"}, nil, false},
+		{[]string{"report", "-unknown"}, nil, false},
+		{[]string{"annual", "report"}, nil, false},
+		{[]string{"annual", "-in", "/docs", "report"}, nil, false},
+	}
+	for _, test := range tests {
+		params, err := searchParams(test.args)
+		if (err == nil) != test.ok {
+			t.Errorf("searchParams(%q) returned error %v", test.args, err)
+			continue
+		}
+		for name, value := range test.expected {
+			if params.Get(name) != value {
+				t.Errorf("searchParams(%q): %s = %q, expected %q", test.args, name, params.Get(name), value)
+			}
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package cloudsyncer
+
+import (
+	"cloudsyncer/cs-client/db"
+	"cloudsyncer/toolkit"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Format of the date put into conflicted copy names.
+const conflictDateFormat = "2006-01-02 15-04-05"
+
+// Returns absolute local path for given relative key and file name.
+func (w *Worker) localPath(key string, name string) string {
+	return w.path + toolkit.OnlyCleanPath(strings.Replace(path.Dir(key), "/", string(os.PathSeparator), -1)) + string(os.PathSeparator) + name
+}
+
+// Checks whether incoming metadata conflicts with local file stored under given key.
+// File is conflicted when its local content differs both from the state known to database and from the incoming revision.
+// Returns metadata of local file if there is a conflict, nil otherwise.
+func (w *Worker) getConflict(key string, metadata *db.Metadata) *db.Metadata {
+	if metadata == nil || metadata.IsDir {
+		return nil
+	}
+	file, err := db.GetFileByPath(key)
+	if err != nil {
+		log.Printf("getConflict: Error retrieving file %s: %s", key, err)
+		return nil
+	}
+	name := metadata.Name
+	storedHash := ""
+	if file != nil {
+		if file.IsPlaceholder {
+			cleared, err := w.clearChangedPlaceholder(file)
+			if err != nil {
+				log.Printf("getConflict: Error clearing placeholder %s: %s", key, err)
+			}
+			if !cleared && err == nil {
+				return nil
+			}
+		}
+		name = file.Name
+		storedHash = file.Hash
+	}
+	localPath := w.localPath(key, name)
+	if !toolkit.Exists(localPath) || toolkit.IsDirectory(localPath) {
+		return nil
+	}
+	local, err := getMetaForLocalFile(localPath)
+	if err != nil {
+		log.Printf("getConflict: Error reading local file %s: %s", localPath, err)
+		return nil
+	}
+	if local.Hash == storedHash || local.Hash == metadata.Hash {
+		return nil
+	}
+	log.Printf("Conflict detected for %s, local hash: %s, stored hash: %s, remote hash: %s", key, local.Hash, storedHash, metadata.Hash)
+	return &local
+}
+
+// Returns name of conflicted copy for given file name, for example:
+//	report (conflicted copy from laptop 2014-06-01 10-12-54).txt
+func conflictedCopyName(name string, computername string, t time.Time) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	if base == "" {
+		base, ext = name, ""
+	}
+	return fmt.Sprintf("%s (conflicted copy from %s %s)%s", base, computername, t.Format(conflictDateFormat), ext)
+}
+
+// Keeps local version of conflicted file by renaming it to conflicted copy and uploading it to the server.
+// Original path is then free to receive incoming revision.
+func (w *Worker) createConflictedCopy(key string, local *db.Metadata) error {
+	localPath := w.localPath(key, local.Name)
+	copyPath := w.localPath(key, conflictedCopyName(local.Name, appConfig["computer_name"], time.Now()))
+	log.Printf("Moving conflicted file %s to %s", localPath, copyPath)
+	discard[localPath] = true // we need to say watcher to do not care about this rename operation
+	if err := os.Rename(localPath, copyPath); err != nil {
+		delete(discard, localPath)
+		log.Printf("Error creating conflicted copy of %s: %s", localPath, err)
+		return err
+	}
+	metadata, err := getMetaForLocalFile(copyPath)
+	if err != nil {
+		return err
+	}
+	metadata.Path = toolkit.NormalizePath(metadata.Path)
+	return w.createRemoteFile(copyPath, metadata)
+}
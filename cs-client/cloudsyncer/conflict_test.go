@@ -0,0 +1,26 @@
+package cloudsyncer
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConflictedCopyName(t *testing.T) {
+	date := time.Date(2014, 6, 1, 10, 12, 54, 0, time.UTC)
+	tests := []struct {
+		name     string
+		computer string
+		expected string
+	}{
+		{"report.txt", "laptop", "report (conflicted copy from laptop 2014-06-01 10-12-54).txt"},
+		{"archive.tar.gz", "laptop", "archive.tar (conflicted copy from laptop 2014-06-01 10-12-54).gz"},
+		{"Makefile", "desktop", "Makefile (conflicted copy from desktop 2014-06-01 10-12-54)"},
+		{".bashrc", "laptop", ".bashrc (conflicted copy from laptop 2014-06-01 10-12-54)"},
+		{"notes.", "laptop", "notes (conflicted copy from laptop 2014-06-01 10-12-54)."},
+	}
+	for _, test := range tests {
+		if name := conflictedCopyName(test.name, test.computer, date); name != test.expected {
+			t.Errorf("conflictedCopyName(%q, %q) = %q, expected %q", test.name, test.computer, name, test.expected)
+		}
+	}
+}
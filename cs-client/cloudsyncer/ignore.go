@@ -0,0 +1,219 @@
+package cloudsyncer
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// Name of per directory file holding ignore rules.
+const ignoreFileName = ".cloudsyncignore"
+
+// Patterns ignored by default: editor swap and backup files, system metadata files and partial downloads.
+var defaultIgnorePatterns = []string{
+	".DS_Store",
+	"._*",
+	"Thumbs.db",
+	"desktop.ini",
+	"*.swp",
+	"*.swo",
+	"*.swx",
+	"*~",
+	".#*",
+	`\#*#`,
+	"*.part",
+	"*.partial",
+	"*.crdownload",
+	"*.download",
+	".cloudsyncer_cache/",
+}
+
+// Single pattern of ignore file. Patterns follow gitignore syntax: "!" negates the pattern, trailing slash
+// matches only directories, pattern containing slash is anchored to the directory of ignore file,
+// otherwise it matches file name at any depth. "*", "?", "[...]" and "**" wildcards are supported.
+// Leading backslash escapes "#" or "!" starting the name.
+type ignorePattern struct {
+	segments []string
+	negate   bool
+	dirOnly  bool
+	anchored bool
+}
+
+// IgnoreRules decides which files are not synced. Rules are read from defaults, global ignore file
+// and .cloudsyncignore files found in work dir. Rules from deeper directories take precedence.
+// All paths given to IgnoreRules are relative to work dir, with slash as separator.
+type IgnoreRules struct {
+	root   string
+	global []ignorePattern
+	dirs   map[string][]ignorePattern
+	mutex  sync.Mutex
+}
+
+// Creates and returns new IgnoreRules for given work dir, reading global rules from given file if it exists.
+func NewIgnoreRules(root string, globalFile string) *IgnoreRules {
+	r := IgnoreRules{root: root, dirs: make(map[string][]ignorePattern)}
+	for _, line := range defaultIgnorePatterns {
+		if pattern, ok := parseIgnorePattern(line); ok {
+			r.global = append(r.global, pattern)
+		}
+	}
+	r.global = append(r.global, readIgnoreFile(globalFile)...)
+	return &r
+}
+
+// Returns path relative to work dir for given absolute local path.
+func (r *IgnoreRules) relative(localPath string) string {
+	rel := strings.Replace(strings.Replace(localPath, r.root, "", 1), string(os.PathSeparator), "/", -1)
+	if !strings.HasPrefix(rel, "/") {
+		rel = "/" + rel
+	}
+	return rel
+}
+
+// Returns true if given absolute local path should not be synced.
+func (r *IgnoreRules) IsIgnoredLocal(localPath string, isDir bool) bool {
+	return r.IsIgnored(r.relative(localPath), isDir)
+}
+
+// Returns true if given relative path should not be synced. Path is ignored when it or any of its parents matches.
+func (r *IgnoreRules) IsIgnored(relPath string, isDir bool) bool {
+	parts := strings.Split(strings.Trim(strings.ToLower(relPath), "/"), "/")
+	if len(parts) == 1 && parts[0] == "" {
+		return false
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for i := 1; i <= len(parts); i++ {
+		if r.matches(parts[:i], i < len(parts) || isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reads rules from .cloudsyncignore in given absolute local directory, replacing rules read before.
+func (r *IgnoreRules) LoadLocalDir(localDir string) {
+	relDir := strings.TrimSuffix(strings.ToLower(r.relative(localDir)), "/")
+	patterns := readIgnoreFile(localDir + string(os.PathSeparator) + ignoreFileName)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.dirs[relDir] = patterns
+}
+
+// Checks patterns applying to path given as its segments. Last matching pattern wins.
+func (r *IgnoreRules) matches(parts []string, isDir bool) bool {
+	ignored := false
+	for _, pattern := range r.global {
+		if pattern.match(parts, isDir) {
+			ignored = !pattern.negate
+		}
+	}
+	for depth := 0; depth < len(parts); depth++ {
+		dir := ""
+		if depth > 0 {
+			dir = "/" + strings.Join(parts[:depth], "/")
+		}
+		for _, pattern := range r.loadDir(dir) {
+			if pattern.match(parts[depth:], isDir) {
+				ignored = !pattern.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// Returns patterns of given directory, reading its ignore file if it was not read before. Requires mutex to be held.
+// Directories seen by watcher are loaded with LoadLocalDir, so this is a fallback for directories not existing locally yet.
+func (r *IgnoreRules) loadDir(relDir string) []ignorePattern {
+	if patterns, ok := r.dirs[relDir]; ok {
+		return patterns
+	}
+	localDir := r.root + strings.Replace(relDir, "/", string(os.PathSeparator), -1)
+	patterns := readIgnoreFile(localDir + string(os.PathSeparator) + ignoreFileName)
+	r.dirs[relDir] = patterns
+	return patterns
+}
+
+// Reads patterns from given ignore file. Returns nil if file does not exist.
+func readIgnoreFile(filepath string) []ignorePattern {
+	file, err := os.Open(filepath)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	patterns := make([]ignorePattern, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if pattern, ok := parseIgnorePattern(scanner.Text()); ok {
+			patterns = append(patterns, pattern)
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		log.Printf("Error reading ignore file %s: %s", filepath, err)
+	}
+	log.Printf("Read %d ignore rules from %s", len(patterns), filepath)
+	return patterns
+}
+
+// Parses single line of ignore file. Returns false if line is empty or a comment.
+func parseIgnorePattern(line string) (pattern ignorePattern, ok bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return pattern, false
+	}
+	if strings.HasPrefix(line, "!") {
+		pattern.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		pattern.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		pattern.anchored = true
+		line = strings.TrimLeft(line, "/")
+	}
+	if line == "" {
+		return pattern, false
+	}
+	pattern.segments = strings.Split(strings.ToLower(line), "/")
+	return pattern, true
+}
+
+// Returns true if pattern matches path given as segments relative to the directory of its ignore file.
+func (p ignorePattern) match(parts []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		matched, _ := path.Match(p.segments[0], parts[len(parts)-1])
+		return matched
+	}
+	return matchSegments(p.segments, parts)
+}
+
+func matchSegments(segments []string, parts []string) bool {
+	if len(segments) == 0 {
+		return len(parts) == 0
+	}
+	if segments[0] == "**" {
+		for i := 0; i <= len(parts); i++ {
+			if matchSegments(segments[1:], parts[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(parts) == 0 {
+		return false
+	}
+	if matched, _ := path.Match(segments[0], parts[0]); !matched {
+		return false
+	}
+	return matchSegments(segments[1:], parts[1:])
+}
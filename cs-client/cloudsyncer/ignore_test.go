@@ -0,0 +1,114 @@
+package cloudsyncer
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseIgnorePattern(t *testing.T) {
+	tests := []struct {
+		line     string
+		ok       bool
+		expected ignorePattern
+	}{
+		{"", false, ignorePattern{}},
+		{"   ", false, ignorePattern{}},
+		{"# comment", false, ignorePattern{}},
+		{"*.log", true, ignorePattern{segments: []string{"*.log"}}},
+		{"*.LOG  ", true, ignorePattern{segments: []string{"*.log"}}},
+		{"!keep.log", true, ignorePattern{segments: []string{"keep.log"}, negate: true}},
+		{`\#*#`, true, ignorePattern{segments: []string{"#*#"}}},
+		{`\!important`, true, ignorePattern{segments: []string{"!important"}}},
+		{"build/", true, ignorePattern{segments: []string{"build"}, dirOnly: true}},
+		{"/todo.txt", true, ignorePattern{segments: []string{"todo.txt"}, anchored: true}},
+		{"docs/**/*.tmp", true, ignorePattern{segments: []string{"docs", "**", "*.tmp"}, anchored: true}},
+		{"/", false, ignorePattern{}},
+	}
+	for _, test := range tests {
+		pattern, ok := parseIgnorePattern(test.line)
+		if ok != test.ok {
+			t.Errorf("parseIgnorePattern(%q) ok = %v, expected %v", test.line, ok, test.ok)
+			continue
+		}
+		if ok && !reflect.DeepEqual(pattern, test.expected) {
+			t.Errorf("parseIgnorePattern(%q) = %+v, expected %+v", test.line, pattern, test.expected)
+		}
+	}
+}
+
+func TestDefaultIgnorePatterns(t *testing.T) {
+	rules := NewIgnoreRules(t.TempDir(), "")
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/.DS_Store", false, true},
+		{"/docs/Thumbs.db", false, true},
+		{"/notes.txt.swp", false, true},
+		{"/notes.txt~", false, true},
+		{"/.#notes.txt", false, true},
+		{"/#notes.txt#", false, true},
+		{"/docs/#draft#", false, true},
+		{"/movie.mkv.part", false, true},
+		{"/.cloudsyncer_cache", true, true},
+		{"/.cloudsyncer_cache/tmp", false, true},
+		{"/notes.txt", false, false},
+		{"/#notes", false, false},
+		{"/docs", true, false},
+		{"/", true, false},
+	}
+	for _, test := range tests {
+		if ignored := rules.IsIgnored(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("IsIgnored(%q, %v) = %v, expected %v", test.path, test.isDir, ignored, test.ignored)
+		}
+	}
+}
+
+func TestIgnoreFileRules(t *testing.T) {
+	root := t.TempDir()
+	global := filepath.Join(t.TempDir(), "cloudsyncignore")
+	files := map[string]string{
+		global:                              "*.log\nbuild/\n",
+		filepath.Join(root, ignoreFileName): "/todo.txt\ndocs/**/*.tmp\n",
+		filepath.Join(root, "app", ignoreFileName):           "!keep.log\n",
+		filepath.Join(root, "app", "vendor", ignoreFileName): "*\n",
+	}
+	for name, content := range files {
+		if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rules := NewIgnoreRules(root, global)
+	tests := []struct {
+		path    string
+		isDir   bool
+		ignored bool
+	}{
+		{"/error.log", false, true},
+		{"/app/error.log", false, true},
+		{"/app/keep.log", false, false},
+		{"/keep.log", false, true},
+		{"/build", true, true},
+		{"/build", false, false},
+		{"/build/out.bin", false, true},
+		{"/todo.txt", false, true},
+		{"/app/todo.txt", false, false},
+		{"/docs/a.tmp", false, true},
+		{"/docs/a/b/c.tmp", false, true},
+		{"/other/a.tmp", false, false},
+		{"/app/vendor/lib.go", false, true},
+		{"/app/main.go", false, false},
+	}
+	for _, test := range tests {
+		if ignored := rules.IsIgnored(test.path, test.isDir); ignored != test.ignored {
+			t.Errorf("IsIgnored(%q, %v) = %v, expected %v", test.path, test.isDir, ignored, test.ignored)
+		}
+	}
+}
@@ -47,6 +47,13 @@ func (l *Listener) poll() {
 		curCursor := l.cursor
 		log.Print("polling for new changes from cursor " + curCursor)
 		changes, err := l.client.Poll(curCursor)
+		if err == ErrUnauthorized {
+			log.Print("Logged in again, resuming polling")
+			continue
+		}
+		if err == ErrRateLimited {
+			continue
+		}
 		if err != nil {
 			log.Print("Error when polling for changes")
 			return
@@ -0,0 +1,127 @@
+package cloudsyncer
+
+import (
+	"bytes"
+	"errors"
+	"unicode/utf8"
+)
+
+// Maximum size of file which is merged using three-way merge. Bigger files are treated as binary.
+const maxMergeSize = 1024 * 1024
+
+// Errors returned by three-way merge.
+var (
+	ErrMergeConflict = errors.New("both versions changed the same lines")
+	ErrNotMergeable  = errors.New("file is not a text file")
+)
+
+// Returns true if given content looks like a text which might be merged line by line.
+func isMergeable(content []byte) bool {
+	if len(content) > maxMergeSize {
+		return false
+	}
+	if bytes.IndexByte(content, 0) != -1 {
+		return false
+	}
+	return utf8.Valid(content)
+}
+
+// Performs line based three-way merge of local and remote versions, using base as their common ancestor.
+// Returns merged content, or nil and ErrMergeConflict if changes overlap.
+func merge3(base []byte, local []byte, remote []byte) ([]byte, error) {
+	o := splitLines(base)
+	a := splitLines(local)
+	b := splitLines(remote)
+	ma := matchLines(o, a)
+	mb := matchLines(o, b)
+	var out [][]byte
+	i, ja, jb := 0, 0, 0
+	for i < len(o) || ja < len(a) || jb < len(b) {
+		if i < len(o) && ma[i] == ja && mb[i] == jb {
+			out = append(out, o[i])
+			i, ja, jb = i+1, ja+1, jb+1
+			continue
+		}
+		// find next line of base which is kept by both sides, everything before it is an unstable chunk
+		l := i
+		for l < len(o) && (ma[l] == -1 || mb[l] == -1) {
+			l++
+		}
+		endA, endB := len(a), len(b)
+		if l < len(o) {
+			endA, endB = ma[l], mb[l]
+		}
+		chunkO, chunkA, chunkB := o[i:l], a[ja:endA], b[jb:endB]
+		switch {
+		case equalLines(chunkA, chunkO):
+			out = append(out, chunkB...)
+		case equalLines(chunkB, chunkO), equalLines(chunkA, chunkB):
+			out = append(out, chunkA...)
+		default:
+			return nil, ErrMergeConflict
+		}
+		i, ja, jb = l, endA, endB
+	}
+	return bytes.Join(out, nil), nil
+}
+
+// Splits content into lines, keeping line endings.
+func splitLines(content []byte) [][]byte {
+	lines := make([][]byte, 0)
+	for len(content) > 0 {
+		n := bytes.IndexByte(content, '\n')
+		if n == -1 {
+			n = len(content) - 1
+		}
+		lines = append(lines, content[:n+1])
+		content = content[n+1:]
+	}
+	return lines
+}
+
+// Computes longest common subsequence of lines in base and other.
+// Returns slice where each index of base holds index of matching line in other, or -1 if line has no match.
+func matchLines(base [][]byte, other [][]byte) []int {
+	lcs := make([][]int, len(base)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(other)+1)
+	}
+	for i := len(base) - 1; i >= 0; i-- {
+		for j := len(other) - 1; j >= 0; j-- {
+			if bytes.Equal(base[i], other[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	matches := make([]int, len(base))
+	i, j := 0, 0
+	for i < len(base) {
+		switch {
+		case j < len(other) && bytes.Equal(base[i], other[j]):
+			matches[i] = j
+			i, j = i+1, j+1
+		case j < len(other) && lcs[i][j+1] > lcs[i+1][j]:
+			j++
+		default:
+			matches[i] = -1
+			i++
+		}
+	}
+	return matches
+}
+
+func equalLines(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,59 @@
+package cloudsyncer
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMerge3(t *testing.T) {
+	tests := []struct {
+		name     string
+		base     string
+		local    string
+		remote   string
+		expected string
+		err      error
+	}{
+		{"nothing changed", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nc\n", nil},
+		{"local change only", "a\nb\nc\n", "a\nB\nc\n", "a\nb\nc\n", "a\nB\nc\n", nil},
+		{"remote change only", "a\nb\nc\n", "a\nb\nc\n", "a\nb\nC\n", "a\nb\nC\n", nil},
+		{"separate changes", "a\nb\nc\nd\n", "A\nb\nc\nd\n", "a\nb\nc\nD\n", "A\nb\nc\nD\n", nil},
+		{"same change on both sides", "a\nb\nc\n", "a\nX\nc\n", "a\nX\nc\n", "a\nX\nc\n", nil},
+		{"local insert and remote append", "a\nb\n", "a\nnew\nb\n", "a\nb\nend\n", "a\nnew\nb\nend\n", nil},
+		{"local delete and remote change elsewhere", "a\nb\nc\nd\n", "a\nc\nd\n", "a\nb\nc\nD\n", "a\nc\nD\n", nil},
+		{"missing final newline", "a\nb", "a\nb", "A\nb", "A\nb", nil},
+		{"empty base", "", "local\n", "", "local\n", nil},
+		{"overlapping changes", "a\nb\nc\n", "a\nlocal\nc\n", "a\nremote\nc\n", "", ErrMergeConflict},
+		{"both append different lines", "a\n", "a\nlocal\n", "a\nremote\n", "", ErrMergeConflict},
+	}
+	for _, test := range tests {
+		merged, err := merge3([]byte(test.base), []byte(test.local), []byte(test.remote))
+		if err != test.err {
+			t.Errorf("%s: merge3 returned error %v, expected %v", test.name, err, test.err)
+			continue
+		}
+		if err == nil && !bytes.Equal(merged, []byte(test.expected)) {
+			t.Errorf("%s: merge3 = %q, expected %q", test.name, merged, test.expected)
+		}
+	}
+}
+
+func TestIsMergeable(t *testing.T) {
+	tests := []struct {
+		name      string
+		content   []byte
+		mergeable bool
+	}{
+		{"text", []byte("hello\nworld\n"), true},
+		{"empty", []byte{}, true},
+		{"utf-8", []byte("zażółć gęślą jaźń\n"), true},
+		{"null byte", []byte("a\x00b"), false},
+		{"invalid utf-8", []byte{0xff, 0xfe, 'a'}, false},
+		{"too big", bytes.Repeat([]byte("a"), maxMergeSize+1), false},
+	}
+	for _, test := range tests {
+		if mergeable := isMergeable(test.content); mergeable != test.mergeable {
+			t.Errorf("%s: isMergeable = %v, expected %v", test.name, mergeable, test.mergeable)
+		}
+	}
+}
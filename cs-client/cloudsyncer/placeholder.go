@@ -0,0 +1,234 @@
+package cloudsyncer
+
+import (
+	"cloudsyncer/cs-client/db"
+	"cloudsyncer/toolkit"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"code.google.com/p/go-uuid/uuid"
+)
+
+// Config keys controlling online-only files. When online_only is set to "true", new remote files are created
+// locally as zero-byte placeholders, unless they match one of keep_offline patterns (newline separated,
+// gitignore syntax). Files matching keep_offline are always downloaded.
+const (
+	onlineOnlyKey  = "online_only"
+	keepOfflineKey = "keep_offline"
+)
+
+// Error returned when placeholder would overwrite local content.
+var ErrLocalContent = errors.New("local file has content which would be overwritten by placeholder")
+
+// Returns true if new files should be created as placeholders.
+func isOnlineOnly() bool {
+	return db.GetCfgValue(onlineOnlyKey) == "true"
+}
+
+// Returns true if file at given path matches one of keep offline rules. Rule matching a folder applies to all its files.
+func keepOffline(key string) bool {
+	parts := strings.Split(strings.Trim(key, "/"), "/")
+	for _, line := range strings.Split(db.GetCfgValue(keepOfflineKey), "\n") {
+		pattern, ok := parseIgnorePattern(line)
+		if !ok {
+			continue
+		}
+		for i := 1; i <= len(parts); i++ {
+			if pattern.match(parts[:i], i < len(parts)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Adds keep offline rule. Placeholders matching the rule are downloaded.
+func (w *Worker) AddKeepOfflineRule(rule string) error {
+	if _, ok := parseIgnorePattern(rule); !ok {
+		return errors.New("invalid rule: " + rule)
+	}
+	rules := db.GetCfgValue(keepOfflineKey)
+	if rules != "" {
+		rules += "\n"
+	}
+	if !db.SetCfgValue(keepOfflineKey, rules+rule) {
+		return errors.New("unable to save keep offline rule")
+	}
+	return w.Hydrate("/", true)
+}
+
+// Returns true if file which is about to be created locally should be a placeholder.
+// Existing placeholders stay placeholders, new files become placeholders in online-only mode.
+func (w *Worker) shouldBePlaceholder(file *db.File) bool {
+	if file.IsDir || keepOffline(file.Path) {
+		return false
+	}
+	return file.IsPlaceholder || (!toolkit.Exists(w.localPath(file.Path, file.Name)) && isOnlineOnly())
+}
+
+// Returns true if local copy of placeholder was written since the placeholder was created,
+// that is if it is not empty or its modification time differs. Missing file is not changed.
+func (w *Worker) placeholderChanged(file *db.File) bool {
+	info, err := os.Stat(w.localPath(file.Path, file.Name))
+	if err != nil {
+		return false
+	}
+	diff := info.ModTime().Sub(file.ModificationTime)
+	return info.Size() != 0 || diff <= -time.Second || diff >= time.Second
+}
+
+// Clears placeholder flag of file whose local copy was written, so local content is handled
+// as regular local change. Synced flag is kept, as not synced files are downloaded over local content.
+// Returns true if flag was cleared.
+func (w *Worker) clearChangedPlaceholder(file *db.File) (bool, error) {
+	if !file.IsPlaceholder || !w.placeholderChanged(file) {
+		return false, nil
+	}
+	log.Printf("Placeholder %s was written locally, it is not a placeholder anymore", file.Path)
+	file.IsPlaceholder = false
+	return true, file.Save()
+}
+
+// Clears placeholder flag of file whose local copy was written and uploads local content as new revision
+// of the file. Returns true if local copy was written.
+func (w *Worker) uploadWrittenPlaceholder(file *db.File) (bool, error) {
+	cleared, err := w.clearChangedPlaceholder(file)
+	if !cleared || err != nil {
+		return cleared, err
+	}
+	// placeholder stood for current revision, which stays parent of uploaded content
+	return true, w.createRemoteFile(w.localPath(file.Path, file.Name), file.Metdata())
+}
+
+// Handles local write of file at given path. Placeholder which got local content becomes regular file
+// and the content is uploaded.
+func (w *Worker) placeholderWritten(key string) {
+	file, err := db.GetFileByPath(key)
+	if err != nil || file == nil {
+		return
+	}
+	if _, err = w.uploadWrittenPlaceholder(file); err != nil {
+		log.Printf("Error uploading written placeholder %s: %s", key, err)
+	}
+}
+
+// Creates zero-byte placeholder for given file and marks it as not hydrated in database.
+// Returns ErrLocalContent if local file exists and is not empty. Database metadata of the file is already updated
+// at this point, so modification time of previous placeholder is checked before, when incoming change is checked for conflicts.
+func (w *Worker) createPlaceholder(file *db.File) error {
+	targetpath := w.localPath(file.Path, file.Name)
+	if info, err := os.Stat(targetpath); err == nil && info.Size() != 0 {
+		log.Printf("Not creating placeholder %s, local file is not empty", targetpath)
+		return ErrLocalContent
+	}
+	file.IsPlaceholder = true
+	file.Synced = true
+	if err := file.Save(); err != nil {
+		return err
+	}
+	out, err := os.Create(targetpath)
+	if err != nil {
+		log.Printf("Error creating placeholder %s: %s", targetpath, err)
+		return err
+	}
+	out.Close()
+	os.Chtimes(targetpath, file.ModificationTime, file.ModificationTime)
+	log.Print("Placeholder created succesfully: ", file.Path)
+	return nil
+}
+
+// Replaces local file with empty file with given modification time. Placeholder is prepared in temporary folder
+// and renamed over the file, so watcher never sees emptied file with new modification time as local write.
+func (w *Worker) replaceWithPlaceholder(localPath string, modified time.Time) error {
+	tmpFileName := getTmpDir() + string(os.PathSeparator) + uuid.New()
+	out, err := os.Create(tmpFileName)
+	if err != nil {
+		return err
+	}
+	out.Close()
+	if err = os.Chtimes(tmpFileName, modified, modified); err != nil {
+		os.Remove(tmpFileName)
+		return err
+	}
+	// watcher should not handle replacing the file as local change, it reports cleaned paths
+	discard[filepath.Clean(localPath)] = true
+	if err = os.Rename(tmpFileName, localPath); err != nil {
+		delete(discard, filepath.Clean(localPath))
+		os.Remove(tmpFileName)
+		return err
+	}
+	return nil
+}
+
+// Downloads placeholders at given path. If path is a folder, all placeholders inside are downloaded.
+// If onlyKeptOffline is true, only files matching keep offline rules are downloaded.
+func (w *Worker) Hydrate(path string, onlyKeptOffline bool) error {
+	files, err := db.GetFilesUnder(normalizeRemotePath(path))
+	if err != nil {
+		return err
+	}
+	if files == nil && !onlyKeptOffline {
+		return db.ErrNotExist
+	}
+	for _, file := range files {
+		if file.IsDir || !file.IsPlaceholder || (onlyKeptOffline && !keepOffline(file.Path)) {
+			continue
+		}
+		uploaded, err := w.uploadWrittenPlaceholder(&file)
+		if err != nil {
+			return err
+		}
+		if uploaded {
+			continue
+		}
+		log.Printf("Hydrating %s", file.Path)
+		file.IsPlaceholder = false
+		file.Synced = false
+		if err = file.Save(); err != nil {
+			return err
+		}
+		if err = w.createLocalFile(file.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Frees up space by turning synced files at given path into placeholders.
+// Files with local changes and files matching keep offline rules are left untouched.
+func (w *Worker) Dehydrate(path string) (freed int64, err error) {
+	files, err := db.GetFilesUnder(normalizeRemotePath(path))
+	if err != nil {
+		return 0, err
+	}
+	if files == nil {
+		return 0, db.ErrNotExist
+	}
+	for _, file := range files {
+		if file.IsDir || file.IsPlaceholder || !file.Synced || keepOffline(file.Path) {
+			continue
+		}
+		localPath := w.localPath(file.Path, file.Name)
+		local, err := getMetaForLocalFile(localPath)
+		if err != nil {
+			return freed, err
+		}
+		if local.Hash != file.Hash {
+			log.Printf("File %s has local changes, not freeing it", localPath)
+			continue
+		}
+		file.IsPlaceholder = true
+		if err = file.Save(); err != nil {
+			return freed, err
+		}
+		if err = w.replaceWithPlaceholder(localPath, file.ModificationTime); err != nil {
+			return freed, err
+		}
+		freed += local.Size
+	}
+	return freed, nil
+}
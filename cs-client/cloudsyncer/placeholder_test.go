@@ -0,0 +1,194 @@
+package cloudsyncer
+
+import (
+	"cloudsyncer/cs-client/db"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+)
+
+var testDbOnce sync.Once
+
+// Opens database of the client shared by tests of the package and removes files stored by previous test.
+func initTestDb(t *testing.T) {
+	testDbOnce.Do(func() {
+		dir, err := ioutil.TempDir("", "cloudsyncer-test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = db.InitDb(filepath.Join(dir, "cloudsyncer.db"), logrus.New()); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if err := db.Reset(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPlaceholderChanged(t *testing.T) {
+	w := &Worker{path: t.TempDir()}
+	modified := time.Date(2014, 6, 1, 10, 12, 54, 0, time.UTC)
+	tests := []struct {
+		name     string
+		content  string
+		mtime    time.Time
+		missing  bool
+		expected bool
+	}{
+		{"intact.txt", "", modified, false, false},
+		{"mtime-rounded.txt", "", modified.Add(300 * time.Millisecond), false, false},
+		{"written.txt", "local edits", modified, false, true},
+		{"touched.txt", "", modified.Add(time.Hour), false, true},
+		{"missing.txt", "", modified, true, false},
+	}
+	for _, test := range tests {
+		file := &db.File{Path: "/" + test.name, Name: test.name, IsPlaceholder: true, ModificationTime: modified}
+		if !test.missing {
+			localPath := filepath.Join(w.path, test.name)
+			if err := ioutil.WriteFile(localPath, []byte(test.content), 0666); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(localPath, test.mtime, test.mtime); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if changed := w.placeholderChanged(file); changed != test.expected {
+			t.Errorf("%s: placeholderChanged = %v, expected %v", test.name, changed, test.expected)
+		}
+	}
+}
+
+func TestCreatePlaceholderKeepsLocalContent(t *testing.T) {
+	w := &Worker{path: t.TempDir()}
+	localPath := filepath.Join(w.path, "notes.txt")
+	if err := ioutil.WriteFile(localPath, []byte("local edits"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	file := &db.File{Path: "/notes.txt", Name: "notes.txt", IsPlaceholder: true}
+	if err := w.createPlaceholder(file); err != ErrLocalContent {
+		t.Fatalf("createPlaceholder returned %v, expected %v", err, ErrLocalContent)
+	}
+	content, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "local edits" {
+		t.Errorf("local content was overwritten with %q", content)
+	}
+}
+
+func TestWrittenPlaceholderUploaded(t *testing.T) {
+	initTestDb(t)
+	modified := time.Date(2014, 6, 1, 10, 12, 54, 0, time.UTC)
+	var uploaded []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "PUT" || r.URL.Path != "/files_put/notes.txt" {
+			t.Errorf("unexpected request %s %s, placeholder content might be overwritten", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		content, _ := ioutil.ReadAll(r.Body)
+		uploaded = append(uploaded, string(content))
+		json.NewEncoder(w).Encode(db.Metadata{Size: int64(len(content)), Rev: 8, Name: "notes.txt", Path: "/notes.txt",
+			Modified: time.Now(), Hash: "uploaded"})
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	w := &Worker{path: dir, client: NewClient(dir)}
+	w.client.hostname = server.URL
+	w.client.SetCredentials("token", "user")
+	file := &db.File{Path: "/notes.txt", Name: "notes.txt", Parent: "/", CurrentRevision: 7, Size: 100, Hash: "remote",
+		ModificationTime: modified}
+	if err := w.createPlaceholder(file); err != nil {
+		t.Fatal(err)
+	}
+	localPath := filepath.Join(dir, "notes.txt")
+	if err := ioutil.WriteFile(localPath, []byte("local edits"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	metadata, err := getMetaForLocalFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metadata.Path = "/notes.txt"
+	op := NewFileOperation()
+	op.Path = localPath
+	op.Type = Modify
+	op.Attributes = metadata
+	w.handleFileOp(op)
+	if len(uploaded) != 1 || uploaded[0] != "local edits" {
+		t.Fatalf("uploaded %q, expected local content once", uploaded)
+	}
+	stored, err := db.GetFileByPath("/notes.txt")
+	if err != nil || stored == nil {
+		t.Fatalf("file record not found: %v", err)
+	}
+	if stored.IsPlaceholder || !stored.Synced || stored.CurrentRevision != 8 || stored.ParentRevision != 8 {
+		t.Errorf("file record after upload %+v", *stored)
+	}
+	// restart must not download the revision the placeholder stood for
+	if err = w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "local edits" {
+		t.Errorf("local content was overwritten with %q", content)
+	}
+}
+
+func TestDehydrateReplacesFileAtOnce(t *testing.T) {
+	initTestDb(t)
+	workDir := t.TempDir()
+	appConfig["work_dir"] = workDir
+	defer delete(appConfig, "work_dir")
+	if err := os.Mkdir(getTmpDir(), 0777); err != nil {
+		t.Fatal(err)
+	}
+	w := &Worker{path: t.TempDir()}
+	localPath := filepath.Join(w.path, "photo.jpg")
+	if err := ioutil.WriteFile(localPath, []byte("jpeg data"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	local, err := getMetaForLocalFile(localPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := time.Date(2014, 6, 1, 10, 12, 54, 0, time.UTC)
+	file := &db.File{Path: "/photo.jpg", Name: "photo.jpg", Parent: "/", CurrentRevision: 3, Size: local.Size, Hash: local.Hash,
+		Synced: true, ModificationTime: modified}
+	if err = file.Save(); err != nil {
+		t.Fatal(err)
+	}
+	freed, err := w.Dehydrate("/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if freed != local.Size {
+		t.Errorf("freed %d bytes, expected %d", freed, local.Size)
+	}
+	if !discard[localPath] {
+		t.Error("watcher was not told to discard replacing the file")
+	}
+	delete(discard, localPath)
+	stored, err := db.GetFileByPath("/photo.jpg")
+	if err != nil || stored == nil {
+		t.Fatalf("file record not found: %v", err)
+	}
+	if !stored.IsPlaceholder || w.placeholderChanged(stored) {
+		t.Errorf("freed file is not intact placeholder: %+v", *stored)
+	}
+	if temps, _ := ioutil.ReadDir(getTmpDir()); len(temps) != 0 {
+		t.Errorf("%d temporary files left", len(temps))
+	}
+}
@@ -0,0 +1,172 @@
+package cloudsyncer
+
+import (
+	"cloudsyncer/cs-client/db"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+)
+
+// ConflictResolver decides what happens when remote change arrives for a file which has been changed locally as well.
+// Resolve is invoked by Worker before incoming metadata is stored. local holds metadata of the local file,
+// remote holds incoming metadata. Resolve returns true if incoming revision should be downloaded and overwrite
+// the local file, false if local file has been kept in its place.
+type ConflictResolver interface {
+	Resolve(w *Worker, key string, local *db.Metadata, remote *db.Metadata) (download bool, err error)
+}
+
+// Names of available conflict resolution strategies.
+const (
+	ConflictCopy     = "copy"
+	LastWriterWins   = "newest"
+	ServerWins       = "server"
+	LocalWins        = "local"
+	ThreeWayMerge    = "merge"
+	DefaultConflicts = ConflictCopy
+)
+
+// Config key holding default strategy. Strategy for particular sync folder is stored under this key
+// followed by colon and folder path relative to work dir, for example "conflict_strategy:/docs".
+const conflictStrategyKey = "conflict_strategy"
+
+var conflictResolvers = map[string]ConflictResolver{
+	ConflictCopy:   conflictCopyResolver{},
+	LastWriterWins: lastWriterWinsResolver{},
+	ServerWins:     serverWinsResolver{},
+	LocalWins:      localWinsResolver{},
+	ThreeWayMerge:  mergeResolver{},
+}
+
+// Returns conflict resolver configured for given path. The most specific folder strategy is used,
+// if none is configured, the default one is returned.
+func getConflictResolver(key string) ConflictResolver {
+	strategy := db.GetCfgValue(conflictStrategyKey)
+	matched := ""
+	for folder, value := range db.GetCfgValuesByPrefix(conflictStrategyKey + ":") {
+		folder = strings.TrimSuffix(strings.ToLower(folder), "/")
+		if (key == folder || strings.HasPrefix(key, folder+"/")) && len(folder) >= len(matched) {
+			matched = folder
+			strategy = value
+		}
+	}
+	if resolver, ok := conflictResolvers[strategy]; ok {
+		return resolver
+	}
+	if strategy != "" {
+		log.Printf("Unknown conflict strategy '%s', using '%s'", strategy, DefaultConflicts)
+	}
+	return conflictResolvers[DefaultConflicts]
+}
+
+// Resolves conflict between local file and incoming metadata using strategy configured for given path.
+func (w *Worker) resolveConflict(key string, local *db.Metadata, remote *db.Metadata) (bool, error) {
+	return getConflictResolver(key).Resolve(w, key, local, remote)
+}
+
+// Keeps both versions. Local file is renamed to conflicted copy and uploaded.
+type conflictCopyResolver struct{}
+
+func (conflictCopyResolver) Resolve(w *Worker, key string, local *db.Metadata, remote *db.Metadata) (bool, error) {
+	if err := w.createConflictedCopy(key, local); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Keeps the version with later modification time.
+type lastWriterWinsResolver struct{}
+
+func (lastWriterWinsResolver) Resolve(w *Worker, key string, local *db.Metadata, remote *db.Metadata) (bool, error) {
+	if local.Modified.After(remote.Modified) {
+		log.Printf("Local version of %s is newer, keeping it", key)
+		return localWinsResolver{}.Resolve(w, key, local, remote)
+	}
+	log.Printf("Remote version of %s is newer, downloading it", key)
+	return true, nil
+}
+
+// Always overwrites local changes with the incoming revision.
+type serverWinsResolver struct{}
+
+func (serverWinsResolver) Resolve(w *Worker, key string, local *db.Metadata, remote *db.Metadata) (bool, error) {
+	return true, nil
+}
+
+// Always keeps local version and uploads it as new revision.
+type localWinsResolver struct{}
+
+func (localWinsResolver) Resolve(w *Worker, key string, local *db.Metadata, remote *db.Metadata) (bool, error) {
+	metadata := *local
+	metadata.Path = key
+	if err := w.createRemoteFile(w.localPath(key, local.Name), metadata); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Merges text files line by line using revision both versions originate from.
+// Binary files and overlapping changes fall back to conflicted copy.
+type mergeResolver struct{}
+
+func (mergeResolver) Resolve(w *Worker, key string, local *db.Metadata, remote *db.Metadata) (bool, error) {
+	merged, err := w.mergeFile(key, local, remote)
+	if err != nil {
+		log.Printf("Unable to merge %s: %s, creating conflicted copy", key, err)
+		return conflictCopyResolver{}.Resolve(w, key, local, remote)
+	}
+	localPath := w.localPath(key, local.Name)
+	discard[localPath] = true // merged file is uploaded below, watcher should not upload it again
+	if err = ioutil.WriteFile(localPath, merged, 0666); err != nil {
+		delete(discard, localPath)
+		return false, err
+	}
+	metadata, err := getMetaForLocalFile(localPath)
+	if err != nil {
+		return false, err
+	}
+	metadata.Path = key
+	log.Printf("Merged local and remote changes of %s", key)
+	if err = w.createRemoteFile(localPath, metadata); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// Returns content of merged local and remote version of file. The common ancestor is the parent revision,
+// which is the revision last downloaded or uploaded by this client.
+func (w *Worker) mergeFile(key string, local *db.Metadata, remote *db.Metadata) ([]byte, error) {
+	file, err := db.GetFileByPath(key)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil || file.ParentRevision == 0 {
+		return nil, ErrMergeConflict
+	}
+	base, err := w.getRevisionContent(key, file.ParentRevision)
+	if err != nil {
+		return nil, err
+	}
+	theirs, err := w.getRevisionContent(key, remote.Rev)
+	if err != nil {
+		return nil, err
+	}
+	ours, err := ioutil.ReadFile(w.localPath(key, local.Name))
+	if err != nil {
+		return nil, err
+	}
+	if !isMergeable(base) || !isMergeable(ours) || !isMergeable(theirs) {
+		return nil, ErrNotMergeable
+	}
+	return merge3(base, ours, theirs)
+}
+
+// Downloads content of given revision into memory.
+func (w *Worker) getRevisionContent(key string, rev int64) ([]byte, error) {
+	body, err := w.client.GetFile(key, strconv.FormatInt(rev, 10))
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return ioutil.ReadAll(body)
+}
@@ -0,0 +1,79 @@
+package cloudsyncer
+
+import (
+	"cloudsyncer/cs-client/db"
+	"cloudsyncer/toolkit"
+	"errors"
+	"log"
+	"os"
+	"strings"
+)
+
+// Returns path in the form used as key in database, for path given by the user.
+func normalizeRemotePath(path string) string {
+	return toolkit.CleanPath("/" + strings.Replace(path, `\`, "/", -1))
+}
+
+// Excludes remote folder from syncing on this device. Local copy of the folder is removed,
+// files in it stay untouched on the server. Returns error if folder contains not uploaded changes.
+func (w *Worker) ExcludeFolder(folder string) error {
+	folder = normalizeRemotePath(folder)
+	if folder == "/" {
+		return errors.New("main directory cannot be excluded")
+	}
+	files, err := db.GetFilesUnder(folder)
+	if err != nil {
+		return err
+	}
+	var localPath string
+	for _, file := range files {
+		if file.CurrentRevision == 0 {
+			return errors.New("folder contains changes which were not uploaded yet: " + file.Path)
+		}
+		if file.Path == folder {
+			localPath = w.localPath(file.Path, file.Name)
+		}
+	}
+	if err = db.AddExcludedFolder(folder); err != nil {
+		return err
+	}
+	if err = db.RemoveFilesUnder(folder); err != nil {
+		return err
+	}
+	if localPath != "" {
+		log.Printf("Removing local copy of excluded folder %s", localPath)
+		if err = os.RemoveAll(localPath); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Brings back excluded remote folder. Files from the folder are added to state as not synced and downloaded.
+func (w *Worker) IncludeFolder(folder string) error {
+	folder = normalizeRemotePath(folder)
+	if err := db.RemoveExcludedFolder(folder); err != nil {
+		return err
+	}
+	delta, err := w.client.GetDelta("")
+	if err != nil {
+		return err
+	}
+	for _, entry := range delta.Entries {
+		for key, metadata := range entry {
+			if metadata == nil || (key != folder && !strings.HasPrefix(key, folder+"/")) {
+				continue
+			}
+			if w.ignore.IsIgnored(key, metadata.IsDir) || db.IsExcluded(key) {
+				continue
+			}
+			if file, _ := db.GetFileByPath(key); file != nil {
+				continue
+			}
+			if err = db.AddFile(key, metadata, false); err != nil {
+				return err
+			}
+		}
+	}
+	return w.Sync()
+}
@@ -28,6 +28,10 @@ func getDbFilePath() string {
 	return getConfigFileDir() + string(os.PathSeparator) + "cloudsyncer.db"
 }
 
+func getIgnoreFilePath() string {
+	return getConfigFileDir() + string(os.PathSeparator) + "cloudsyncignore"
+}
+
 func getRelativePath(path string) string {
 	path = strings.Replace(path, appConfig["work_dir"], "", 1)
 	return strings.ToLower(path)
@@ -83,10 +83,24 @@ func (w *Watcher) Watch(wg *sync.WaitGroup) {
 					break
 				case ev.Name == getTmpDir():
 					break
+				case w.worker.ignore.IsIgnoredLocal(ev.Name, toolkit.IsDirectory(ev.Name)):
+					log.Printf("Ignoring event for %s", ev.Name)
+					break
+				case db.IsExcluded(getRelativePath(ev.Name)):
+					log.Printf("Ignoring event for excluded %s", ev.Name)
+					break
 				case ev.Op&fsnotify.Create == fsnotify.Create:
+					// files renamed into place by the worker are reported as created
+					if _, ok := discard[ev.Name]; ok {
+						log.Printf("Discarding Create for %s", ev.Name)
+						delete(discard, ev.Name)
+						break
+					}
 					if toolkit.IsDirectory(ev.Name) {
+						w.worker.ignore.LoadLocalDir(ev.Name)
 						w.watcher.Add(ev.Name)
 					}
+					w.reloadIgnoreFile(ev.Name)
 					metadata, err := getMetaForLocalFile(ev.Name)
 					if err != nil {
 						log.Printf("Received error when reading metadata for file %s. Error: %s", ev.Name, err)
@@ -108,6 +122,7 @@ func (w *Watcher) Watch(wg *sync.WaitGroup) {
 						break
 					}
 					log.Printf("We don't have discard for %s", ev.Name)
+					w.reloadIgnoreFile(ev.Name)
 					var metadata db.Metadata
 					metadata.IsRemoved = true
 					metadata.Name = path.Base(ev.Name)
@@ -119,6 +134,12 @@ func (w *Watcher) Watch(wg *sync.WaitGroup) {
 					op.Attributes = metadata
 					w.operations <- op
 				case ev.Op&fsnotify.Write == fsnotify.Write && ev.Op&fsnotify.Remove != fsnotify.Remove:
+					if _, ok := discard[ev.Name]; ok {
+						log.Printf("Discarding Write for %s", ev.Name)
+						delete(discard, ev.Name)
+						break
+					}
+					w.reloadIgnoreFile(ev.Name)
 					metadata, err := getMetaForLocalFile(ev.Name)
 					if err != nil {
 						log.Printf("Received error when reading metadata for file %s. Error: %s", ev.Name, err)
@@ -168,6 +189,14 @@ func (w *Watcher) Watch(wg *sync.WaitGroup) {
 	}()
 }
 
+// Reloads ignore rules of the directory if given path is an ignore file.
+func (w *Watcher) reloadIgnoreFile(path string) {
+	if filepath.Base(path) == ignoreFileName {
+		log.Printf("Ignore file %s changed, reloading rules", path)
+		w.worker.ignore.LoadLocalDir(filepath.Dir(path))
+	}
+}
+
 func (w *Watcher) registerExit() {
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -185,6 +214,20 @@ func (w *Watcher) returnWalker() filepath.WalkFunc {
 			log.Printf("Error walking into path %s", path)
 			return err
 		}
+		if path != appConfig["work_dir"] && w.worker.ignore.IsIgnoredLocal(path, info.IsDir()) {
+			log.Printf("Ignoring %s", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if db.IsExcluded(getRelativePath(path)) {
+			log.Printf("Path %s is excluded from sync", path)
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		if info.IsDir() {
 			for _, folder := range w.excludedFolders {
 
@@ -194,6 +237,7 @@ func (w *Watcher) returnWalker() filepath.WalkFunc {
 				}
 			}
 			log.Printf("Adding %s to watcher", path)
+			w.worker.ignore.LoadLocalDir(path)
 			w.watcher.Add(path)
 
 		}
@@ -219,6 +263,8 @@ func (w *Watcher) returnWalker() filepath.WalkFunc {
 				w.operations <- op
 				log.Printf("New file/folder %s. Adding to local state", path)
 
+			} else if dbFile.IsPlaceholder && info.Size() == 0 {
+				return nil
 			} else if dbFile.ModificationTime.Unix() < info.ModTime().Unix() {
 				if dbFile.Size == info.Size() {
 
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
 
@@ -20,6 +21,7 @@ import (
 // path holds current user work dir.
 // client holds Client instance which is responsible for network operations.
 // listener instance - kept here to restart listener after successful delta handling.
+// ignore holds rules deciding which files are not synced.
 type Worker struct {
 	operations        chan FileOperation
 	deltas            chan Delta
@@ -27,11 +29,13 @@ type Worker struct {
 	client            *Client
 	pendingOperations map[string]FileOperation
 	listener          *Listener
+	ignore            *IgnoreRules
 }
 
 // Creates and returns new worker instance with given parameters.
 func NewWorker(operations chan FileOperation, deltas chan Delta, client *Client, listener *Listener, path string) *Worker {
 	w := Worker{operations: operations, deltas: deltas, path: path, client: client, pendingOperations: make(map[string]FileOperation), listener: listener}
+	w.ignore = NewIgnoreRules(path, getIgnoreFilePath())
 	return &w
 }
 
@@ -60,6 +64,10 @@ func (w *Worker) InitDb() error {
 		}
 		for _, entry := range delta.Entries {
 			for key, metadata := range entry {
+				if w.ignore.IsIgnored(key, metadata != nil && metadata.IsDir) || db.IsExcluded(key) {
+					log.Printf("InitDb ignoring %s", key)
+					continue
+				}
 				db.AddFile(key, metadata, false)
 			}
 		}
@@ -141,10 +149,28 @@ func (w *Worker) handleDelta(delta Delta) {
 		entryErr := make(map[string]error)
 		for _, entry := range delta.Entries {
 			for key, metadata := range entry {
+				if w.ignore.IsIgnored(key, metadata != nil && metadata.IsDir) {
+					log.Printf("Delta entry ignored for %s", key)
+					continue
+				}
+				if db.IsExcluded(key) {
+					log.Printf("Delta entry excluded from sync for %s", key)
+					continue
+				}
 				if !w.isNewEntry(key, metadata) {
 					log.Printf("Delta entry already in state for %s", key)
 					break
 				}
+				if local := w.getConflict(key, metadata); local != nil {
+					download, err := w.resolveConflict(key, local, metadata)
+					if err != nil {
+						entryErr[key] = err
+						break
+					}
+					if !download {
+						continue
+					}
+				}
 				err := w.setMetadata(key, metadata, false)
 				if err != nil {
 					entryErr[key] = err
@@ -182,8 +208,10 @@ func (w *Worker) setMetadata(key string, metadata *db.Metadata, synced bool) err
 	if file == nil {
 		file = new(db.File)
 	}
-	if file.CurrentRevision != 0 {
-		file.ParentRevision = file.CurrentRevision
+	if synced {
+		// local content matches this revision, it is common ancestor of later local and remote changes
+		file.ParentRevision = metadata.Rev
+		file.IsPlaceholder = false
 	}
 	file.Path = metadata.Path
 	file.Name = metadata.Name
@@ -206,6 +234,9 @@ func (w *Worker) createLocalFile(key string) (err error) {
 	if file == nil {
 		return errors.New("No file database when it was requested")
 	}
+	if w.shouldBePlaceholder(file) {
+		return w.createPlaceholder(file)
+	}
 	if file.IsDir == false {
 		tmpFileName := getTmpDir() + string(os.PathSeparator) + uuid.New()
 		out, err := os.Create(tmpFileName)
@@ -214,7 +245,11 @@ func (w *Worker) createLocalFile(key string) (err error) {
 			return err
 		}
 		defer out.Close()
-		body, err := w.client.GetFile(key, strconv.FormatInt(file.CurrentRevision, 10))
+		var body io.ReadCloser
+		err = retryRateLimited(func() (err error) {
+			body, err = w.client.GetFile(key, strconv.FormatInt(file.CurrentRevision, 10))
+			return
+		})
 		if err != nil {
 			log.Printf("Error downloading file %s", err)
 			return err
@@ -238,6 +273,7 @@ func (w *Worker) createLocalFile(key string) (err error) {
 		log.Printf("Setting discard for %s", targetpath)
 		discard[targetpath] = true // we need to say watcher to do not care about this remove operation
 
+		file.ParentRevision = file.CurrentRevision
 		err = file.Sync()
 		if err != nil {
 			log.Printf("Error on syncing in createLocalFile %s", err)
@@ -245,6 +281,9 @@ func (w *Worker) createLocalFile(key string) (err error) {
 		}
 		log.Printf("Renaming file from %s to %s", tmpFileName, targetpath)
 		os.Rename(tmpFileName, targetpath)
+		if file.Name == ignoreFileName {
+			w.ignore.LoadLocalDir(filepath.Dir(targetpath))
+		}
 		log.Print("Local file created succesfully: ", key)
 		return nil
 	} else {
@@ -283,6 +322,8 @@ func (w *Worker) handleFileOp(op FileOperation) {
 			} else {
 				w.createRemoteFile(op.Path, op.Attributes)
 			}
+		case Modify:
+			w.placeholderWritten(op.Attributes.Path)
 		case Delete:
 			w.removeRemoteFile(op.Path)
 		}
@@ -306,6 +347,10 @@ func (w *Worker) isNewOperation(op FileOperation) bool {
 	if op.Type == Delete {
 		return true
 	}
+	if file.IsPlaceholder && !op.Attributes.IsDir && op.Attributes.Size == 0 {
+		log.Printf("Placeholder not changed %s", op.Attributes.Path)
+		return false
+	}
 	if op.Attributes.Name != file.Name {
 		log.Printf("File name mismatch  %s : %s", op.Attributes.Name, file.Name)
 		return true
@@ -369,7 +414,11 @@ func (w *Worker) isNewEntry(key string, metadata *db.Metadata) bool {
 
 func (w *Worker) createRemoteDirectory(path string, metadata db.Metadata) error {
 	w.setMetadata(metadata.Path, &metadata, true)
-	newMetadata, err := w.client.Mkdir(path)
+	var newMetadata db.Metadata
+	err := retryRateLimited(func() (err error) {
+		newMetadata, err = w.client.Mkdir(path)
+		return
+	})
 	if err != nil {
 		log.Printf("error during creating directory '%s': '%s'", path, err)
 		return err
@@ -383,7 +432,7 @@ func (w *Worker) createRemoteDirectory(path string, metadata db.Metadata) error
 }
 
 func (w *Worker) removeRemoteFile(path string) {
-	err := w.client.Remove(path)
+	err := retryRateLimited(func() error { return w.client.Remove(path) })
 	if err != nil {
 		log.Printf("error during removing path '%s': '%s'", path, err)
 	} else {
@@ -393,7 +442,11 @@ func (w *Worker) removeRemoteFile(path string) {
 
 func (w *Worker) createRemoteFile(path string, metadata db.Metadata) error {
 	w.setMetadata(metadata.Path, &metadata, true)
-	newMetadata, err := w.client.Upload(path)
+	var newMetadata db.Metadata
+	err := retryRateLimited(func() (err error) {
+		newMetadata, err = w.client.Upload(path)
+		return
+	})
 	if err != nil {
 		log.Printf("error during file upload '%s': '%s'", path, err)
 		return err
@@ -401,3 +454,15 @@ func (w *Worker) createRemoteFile(path string, metadata db.Metadata) error {
 	w.setMetadata(metadata.Path, &newMetadata, true)
 	return nil
 }
+
+// Calls given client operation again as long as server rejects it because of rate limit, so the operation
+// is not dropped. Client has already waited as long as server requested when it returns ErrRateLimited.
+func retryRateLimited(operation func() error) error {
+	for {
+		err := operation()
+		if err != ErrRateLimited {
+			return err
+		}
+		log.Print("retrying request rejected because of rate limit")
+	}
+}
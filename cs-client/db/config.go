@@ -1,5 +1,7 @@
 package db
 
+import "strings"
+
 // Struct which keeps application configuration
 type Config struct {
 	Id    int64  `db:"id"`
@@ -34,3 +36,21 @@ func SetCfgValue(key string, value string) (success bool) {
 	return true
 
 }
+
+// Returns all values stored for keys starting with given prefix. Keys in returned map have the prefix stripped.
+// Returns empty map if no such keys exist or error has occured.
+func GetCfgValuesByPrefix(prefix string) map[string]string {
+	values := make(map[string]string)
+	var configs []Config
+	if _, err := dbAccess.Select(&configs, "select * from config where key like ?", prefix+"%"); err != nil {
+		logger.Error(err)
+		return values
+	}
+	for _, config := range configs {
+		if !strings.HasPrefix(config.Key, prefix) {
+			continue
+		}
+		values[config.Key[len(prefix):]] = config.Value
+	}
+	return values
+}
@@ -5,6 +5,7 @@ import (
 	"database/sql"
 	"errors"
 	_ "fmt"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/coopernurse/gorp"
@@ -46,13 +47,47 @@ func InitDb(dbpath string, _logger *logrus.Logger) (err error) {
 	//dbAccess.TraceOn("[gorp]", &gorpLogger{logger: logger})
 	dbAccess.AddTableWithName(File{}, "files").SetKeys(true, "Id")
 	dbAccess.AddTableWithName(Config{}, "config").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(ExcludedFolder{}, "excluded_folders").SetKeys(true, "Id")
 	if err = dbAccess.CreateTablesIfNotExists(); err != nil {
 		logger.Fatal("Unable to create database Tables: " + err.Error())
 		return err
 	}
+	if err = addColumnIfMissing("files", "is_placeholder", "integer not null default 0"); err != nil {
+		logger.Fatal("Unable to migrate database Tables: " + err.Error())
+		return err
+	}
 	return nil
 }
 
+// Adds column to existing table, if the table was created by older version of application.
+// Returns error if error has occured.
+func addColumnIfMissing(table string, column string, definition string) error {
+	rows, err := dbAccess.Db.Query("pragma table_info(" + table + ")")
+	if err != nil {
+		return err
+	}
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue interface{}
+		if err = rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+	if exists {
+		return nil
+	}
+	logger.Infof("Adding column %s to table %s", column, table)
+	_, err = dbAccess.Exec("alter table " + table + " add column " + column + " " + definition)
+	return err
+}
+
 // Closes connection to database.
 func Close() {
 	dbAccess.Db.Close()
@@ -79,7 +114,7 @@ func GetUnsyncedFiles() ([]File, error) {
 		return nil, nil
 	}
 	files := make([]File, 0)
-	_, err = dbAccess.Select(&files, "select * from files where synced = ?", false)
+	_, err = dbAccess.Select(&files, "select * from files where synced = ? order by path", false)
 	if err != nil {
 		logger.Error(err)
 		return nil, err
@@ -126,3 +161,32 @@ func GetFileByPath(path string) (file *File, err error) {
 	}
 	return file, nil
 }
+
+// Returns slice of File structs being given path or its descendants.
+// Returns double nil if no such files were found.
+// Returns nil and error if error has occured.
+func GetFilesUnder(path string) ([]File, error) {
+	files := make([]File, 0)
+	_, err := dbAccess.Select(&files, "select * from files where path = ? or path like ? escape '\\'", path,
+		escapeLike(strings.TrimSuffix(path, "/"))+"/%")
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if len(files) < 1 {
+		return nil, nil
+	}
+	return files, nil
+}
+
+// Escapes wildcard characters of SQL like pattern, backslash is used as escape character.
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
+
+// Removes records of given path and all its descendants. Use with caution.
+// Returns error if error has occured.
+func RemoveFilesUnder(path string) error {
+	_, err := dbAccess.Exec("delete from files where path = ? or path like ? escape '\\'", path, escapeLike(path)+"/%")
+	return err
+}
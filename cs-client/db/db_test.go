@@ -0,0 +1,22 @@
+package db
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"/docs", "/docs"},
+		{"/a_b", `/a\_b`},
+		{"/100%", `/100\%`},
+		{`/back\slash`, `/back\\slash`},
+		{`/_%\`, `/\_\%\\`},
+		{"", ""},
+	}
+	for _, test := range tests {
+		if escaped := escapeLike(test.value); escaped != test.expected {
+			t.Errorf("escapeLike(%q) = %q, expected %q", test.value, escaped, test.expected)
+		}
+	}
+}
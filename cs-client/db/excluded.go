@@ -0,0 +1,63 @@
+package db
+
+import "strings"
+
+// Struct keeps remote folder which is excluded from syncing on this device.
+type ExcludedFolder struct {
+	Id   int64  `db:"id"`
+	Path string `db:"path"`
+}
+
+// Returns paths of all excluded folders. Returns nil and error if error has occured.
+func GetExcludedFolders() ([]string, error) {
+	var folders []ExcludedFolder
+	if _, err := dbAccess.Select(&folders, "select * from excluded_folders order by path"); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	paths := make([]string, 0, len(folders))
+	for _, folder := range folders {
+		paths = append(paths, folder.Path)
+	}
+	return paths, nil
+}
+
+// Returns true if given path is an excluded folder or lies under one.
+func IsExcluded(path string) bool {
+	folders, err := GetExcludedFolders()
+	if err != nil {
+		return false
+	}
+	for _, folder := range folders {
+		if path == folder || strings.HasPrefix(path, folder+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Adds given path to excluded folders. Returns ErrEntityAlreadyExists if path is already excluded.
+func AddExcludedFolder(path string) error {
+	count, err := dbAccess.SelectInt("select count(*) from excluded_folders where path = ?", path)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if count > 0 {
+		return ErrEntityAlreadyExists
+	}
+	return dbAccess.Insert(&ExcludedFolder{Path: path})
+}
+
+// Removes given path from excluded folders. Returns ErrEntityNotExists if path is not excluded.
+func RemoveExcludedFolder(path string) error {
+	result, err := dbAccess.Exec("delete from excluded_folders where path = ?", path)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected < 1 {
+		return ErrEntityNotExists
+	}
+	return nil
+}
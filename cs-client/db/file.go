@@ -19,6 +19,7 @@ type File struct {
 	Name             string    `db:"name"`
 	Size             int64     `db:"size"`
 	Synced           bool      `db:"synced"`
+	IsPlaceholder    bool      `db:"is_placeholder"`
 	Hash             string    `db:"hash"`
 	ModificationTime time.Time `db:"modification_time"`
 	CreationTime     time.Time `db:"creation_time"`
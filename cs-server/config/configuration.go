@@ -6,3 +6,85 @@ const (
 	DB_PATH        = "/Users/bigfun/cloudsyncer.db"
 	DATA_DIR       = "/Users/bigfun/clouddata"
 )
+
+// Password hashing. PASSWORD_HASH selects algorithm for new hashes: "argon2id" or "bcrypt".
+// Hashes created with other algorithm or parameters are upgraded on successful login.
+const (
+	PASSWORD_HASH     = "argon2id"
+	ARGON2_TIME       = 1
+	ARGON2_MEMORY     = 64 * 1024
+	ARGON2_THREADS    = 4
+	ARGON2_KEY_LENGTH = 32
+	BCRYPT_COST       = 12
+)
+
+// Session lifetime in seconds. Each authenticated request extends the session, but last seen time
+// is stored at most once per SESSION_RENEW_INTERVAL seconds.
+const (
+	SESSION_LIFETIME       = 30 * 24 * 60 * 60
+	SESSION_RENEW_INTERVAL = 5 * 60
+)
+
+// Lifetime in seconds of API tokens created without explicit expiry, and the maximum lifetime which might be requested.
+const (
+	API_TOKEN_DEFAULT_LIFETIME = 90 * 24 * 60 * 60
+	API_TOKEN_MAX_LIFETIME     = 365 * 24 * 60 * 60
+)
+
+// OpenID Connect single sign-on. Leave OIDC_ISSUER empty to disable it. OIDC_REDIRECT_URL must point to /oidc/callback
+// endpoint of this server. If OIDC_AUTO_PROVISION is set, users unknown to this server are created on first login,
+// otherwise only existing users (matched by verified email) might log in.
+const (
+	OIDC_ISSUER         = ""
+	OIDC_CLIENT_ID      = ""
+	OIDC_CLIENT_SECRET  = ""
+	OIDC_REDIRECT_URL   = "http://localhost:9999/oidc/callback"
+	OIDC_AUTO_PROVISION = false
+)
+
+// Rate limits, in requests per minute with bursts up to given number of requests. 0 disables the limit.
+// IP limit applies to all requests, AUTH limit applies per IP to login and register endpoints,
+// USER limit applies to authenticated requests per user or API token, POLL limit applies to polling
+// of single device authorization.
+const (
+	RATE_LIMIT_IP_PER_MINUTE   = 600
+	RATE_LIMIT_IP_BURST        = 100
+	RATE_LIMIT_AUTH_PER_MINUTE = 10
+	RATE_LIMIT_AUTH_BURST      = 5
+	RATE_LIMIT_USER_PER_MINUTE = 300
+	RATE_LIMIT_USER_BURST      = 60
+	RATE_LIMIT_POLL_PER_MINUTE = 20
+	RATE_LIMIT_POLL_BURST      = 3
+)
+
+// Login lockout. After LOGIN_LOCKOUT_THRESHOLD failed logins username is locked out for LOGIN_LOCKOUT_BASE seconds,
+// doubled with each further failure up to LOGIN_LOCKOUT_MAX seconds.
+const (
+	LOGIN_LOCKOUT_THRESHOLD = 5
+	LOGIN_LOCKOUT_BASE      = 30
+	LOGIN_LOCKOUT_MAX       = 60 * 60
+)
+
+// Registration policy. REGISTRATION_MODE is one of:
+//	open - anyone might register
+//	invite - registration requires single-use invite code created by administrator
+//	closed - only administrators might create users
+// Invites expire after INVITE_LIFETIME seconds. ADMIN_USERS is comma separated list of usernames which are made
+// administrators on server start if they exist. Listed usernames which do not exist might not be registered.
+const (
+	REGISTRATION_MODE = "open"
+	INVITE_LIFETIME   = 7 * 24 * 60 * 60
+	ADMIN_USERS       = ""
+)
+
+// Username and password rules checked on registration. Passwords must contain characters from at least
+// PASSWORD_MIN_CHARACTER_CLASSES of: lowercase letters, uppercase letters, digits and other characters.
+const (
+	USERNAME_MIN_LENGTH            = 3
+	USERNAME_MAX_LENGTH            = 64
+	PASSWORD_MIN_LENGTH            = 10
+	PASSWORD_MIN_CHARACTER_CLASSES = 2
+)
+
+// Storage quota in bytes for users without individual quota set by administrator. 0 means unlimited.
+const DEFAULT_QUOTA = 0
@@ -0,0 +1,168 @@
+package db
+
+import (
+	"cloudsyncer/cs-server/config"
+	"time"
+)
+
+// Usage struct keeps storage usage of single user. Used counts current revisions of existing files and is checked
+// against quota, Stored counts all revisions kept by the server, including removed files and older revisions.
+type Usage struct {
+	Files  int64 `json:"files"`
+	Used   int64 `json:"used"`
+	Stored int64 `json:"stored"`
+}
+
+// ServerStats struct keeps overall statistics of the server.
+type ServerStats struct {
+	Users          int64 `json:"users"`
+	Admins         int64 `json:"admins"`
+	DisabledUsers  int64 `json:"disabled_users"`
+	ActiveSessions int64 `json:"active_sessions"`
+	ApiTokens      int64 `json:"api_tokens"`
+	Files          int64 `json:"files"`
+	Revisions      int64 `json:"revisions"`
+	StoredBytes    int64 `json:"stored_bytes"`
+}
+
+// Returns all users ordered by username. Returns nil and error if error has occured.
+func GetUsers() ([]User, error) {
+	var users []User
+	if _, err := dbAccess.Select(&users, "select * from users order by username"); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return users, nil
+}
+
+// Returns user with given id, or nil if there is no such user.
+func GetUserById(id int64) *User {
+	var user User
+	if err := dbAccess.SelectOne(&user, "select * from users where id=?", id); err != nil {
+		logger.Error(err)
+		return nil
+	}
+	if user.Username == "" {
+		return nil
+	}
+	return &user
+}
+
+// Stores changes of user attributes in database.
+func (user *User) Save() error {
+	if _, err := dbAccess.Update(user); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Returns storage quota of this user in bytes, or 0 if quota is unlimited.
+func (user *User) GetQuota() int64 {
+	if user.Quota < 0 {
+		return 0
+	}
+	if user.Quota == 0 {
+		return config.DEFAULT_QUOTA
+	}
+	return user.Quota
+}
+
+// Returns storage usage of this user. Returns nil and error if error has occured.
+func (user *User) GetUsage() (*Usage, error) {
+	usage := new(Usage)
+	if err := dbAccess.SelectOne(usage, `select count(*) Files, coalesce(sum(revisions.size), 0) Used
+	                                     from files join revisions on files.current_revision_id = revisions.id
+	                                     where files.user_id = ? and files.is_removed = 0 and files.is_dir = 0`, user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	stored, err := dbAccess.SelectInt(`select coalesce(sum(size), 0) from (select distinct uuid, size from revisions
+	                                     where user_id = ? and is_dir = 0) blobs`, user.Id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	usage.Stored = stored
+	return usage, nil
+}
+
+// Returns true if storing additional bytes would exceed quota of this user.
+// replaced is size of the file which is overwritten, as it does not count into usage anymore.
+func (user *User) ExceedsQuota(additional int64, replaced int64) (bool, error) {
+	quota := user.GetQuota()
+	if quota == 0 {
+		return false, nil
+	}
+	usage, err := user.GetUsage()
+	if err != nil {
+		return false, err
+	}
+	return usage.Used-replaced+additional > quota, nil
+}
+
+// Returns overall statistics of the server. Returns nil and error if error has occured.
+func GetServerStats() (*ServerStats, error) {
+	stats := new(ServerStats)
+	queries := []struct {
+		target *int64
+		query  string
+		args   []interface{}
+	}{
+		{&stats.Users, "select count(*) from users", nil},
+		{&stats.Admins, "select count(*) from users where is_admin = 1", nil},
+		{&stats.DisabledUsers, "select count(*) from users where disabled = 1", nil},
+		{&stats.ActiveSessions, "select count(*) from sessions where expires = 0 or expires > ?", []interface{}{time.Now().Unix()}},
+		{&stats.ApiTokens, "select count(*) from api_tokens", nil},
+		{&stats.Files, "select count(*) from files where is_removed = 0 and is_dir = 0", nil},
+		{&stats.Revisions, "select count(*) from revisions", nil},
+		{&stats.StoredBytes, "select coalesce(sum(size), 0) from (select distinct uuid, size from revisions where is_dir = 0) blobs", nil},
+	}
+	for _, q := range queries {
+		value, err := dbAccess.SelectInt(q.query, q.args...)
+		if err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+		*q.target = value
+	}
+	return stats, nil
+}
+
+// Removes user and all records belonging to the user in single transaction.
+// File contents kept in storage are not removed, use CreatePurgeJob to remove them as well.
+func (user *User) Delete() error {
+	if err := user.LeaveAllShares(); err != nil {
+		return err
+	}
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"sessions", "api_tokens", "recovery_codes", "links", "file_requests", "team_members", "revisions", "files"} {
+		if _, err = tx.Exec("delete from "+table+" where user_id = ?", user.Id); err != nil {
+			tx.Rollback()
+			logger.Error(err)
+			return err
+		}
+	}
+	// user might be account of a team
+	for _, query := range []string{"delete from team_members where team_id in (select id from teams where user_id = ?)", "delete from teams where user_id = ?"} {
+		if _, err = tx.Exec(query, user.Id); err != nil {
+			tx.Rollback()
+			logger.Error(err)
+			return err
+		}
+	}
+	if _, err = tx.Exec("delete from invites where used_by = 0 and created_by = ?", user.Id); err != nil {
+		tx.Rollback()
+		logger.Error(err)
+		return err
+	}
+	if _, err = tx.Delete(user); err != nil {
+		tx.Rollback()
+		logger.Error(err)
+		return err
+	}
+	return tx.Commit()
+}
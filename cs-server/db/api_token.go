@@ -0,0 +1,121 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"crypto/subtle"
+	"strings"
+	"time"
+)
+
+// Permissions which might be granted to API token.
+const (
+	PermRead   = "read"
+	PermWrite  = "write"
+	PermDelete = "delete"
+)
+
+// Prefix of API tokens, helps to tell them apart from session tokens.
+const apiTokenPrefix = "cst_"
+
+// ApiToken struct keeps information about personal access token used for automation.
+// Token is limited to files under PathPrefix and to Permissions, which is comma separated list of PermRead, PermWrite and PermDelete.
+// Only hash of the token is stored. Expires and LastUsed are unix timestamps.
+type ApiToken struct {
+	Id          int64  `db:"id"`
+	UserId      int64  `db:"user_id"`
+	Name        string `db:"name"`
+	Token       string `db:"token"`
+	PathPrefix  string `db:"path_prefix"`
+	Permissions string `db:"permissions"`
+	Created     int64  `db:"created"`
+	Expires     int64  `db:"expires"`
+	LastUsed    int64  `db:"last_used"`
+}
+
+// Creates new API token for this user. Returns token struct and the token itself, which is not stored and cannot be retrieved later.
+// Returns nil and error if error has occured.
+func (user *User) CreateApiToken(name string, pathPrefix string, permissions []string, expires time.Time) (*ApiToken, string, error) {
+	var token = apiTokenPrefix + toolkit.GetRandHex(40)
+	var apiToken = ApiToken{UserId: user.Id, Name: name, Token: hashToken(token), PathPrefix: toolkit.CleanPath(pathPrefix),
+		Permissions: strings.Join(permissions, ","), Created: time.Now().Unix(), Expires: expires.Unix()}
+	if err := dbAccess.Insert(&apiToken); err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+	return &apiToken, token, nil
+}
+
+// Returns all API tokens of this user. Returns nil and error if error has occured.
+func (user *User) GetApiTokens() ([]ApiToken, error) {
+	var tokens []ApiToken
+	if _, err := dbAccess.Select(&tokens, "select * from api_tokens where user_id = ? order by created", user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return tokens, nil
+}
+
+// Revokes API token with given id, if it belongs to this user. Returns ErrEntityNotExists if there is no such token.
+func (user *User) RevokeApiToken(id int64) error {
+	result, err := dbAccess.Exec("delete from api_tokens where user_id = ? and id = ?", user.Id, id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected < 1 {
+		return ErrEntityNotExists
+	}
+	return nil
+}
+
+// Returns true if given string looks like API token.
+func IsApiToken(token string) bool {
+	return strings.HasPrefix(token, apiTokenPrefix)
+}
+
+// Returns API token and its owner for given token string. Returns nil if token does not exist or error has occured.
+func GetApiToken(tokenString string) (*ApiToken, *User) {
+	var apiToken ApiToken
+	hash := hashToken(tokenString)
+	if err := dbAccess.SelectOne(&apiToken, "select * from api_tokens where token = ?", hash); err != nil {
+		logger.Warning(err)
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(apiToken.Token), []byte(hash)) != 1 {
+		return nil, nil
+	}
+	var user User
+	if err := dbAccess.SelectOne(&user, "select * from users where id = ?", apiToken.UserId); err != nil {
+		logger.Error(err)
+		return nil, nil
+	}
+	return &apiToken, &user
+}
+
+// Returns true if token has expired.
+func (token *ApiToken) IsExpired() bool {
+	return token.Expires < time.Now().Unix()
+}
+
+// Returns true if token grants given permission.
+func (token *ApiToken) HasPermission(permission string) bool {
+	for _, granted := range strings.Split(token.Permissions, ",") {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if given path lies within path prefix of the token.
+func (token *ApiToken) AllowsPath(path string) bool {
+	path = toolkit.CleanPath(path)
+	return token.PathPrefix == "/" || path == token.PathPrefix || strings.HasPrefix(path, token.PathPrefix+"/")
+}
+
+// Stores the time of last use of the token.
+func (token *ApiToken) Touch() error {
+	token.LastUsed = time.Now().Unix()
+	_, err := dbAccess.Update(token)
+	return err
+}
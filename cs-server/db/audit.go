@@ -0,0 +1,97 @@
+package db
+
+import (
+	"strings"
+	"time"
+)
+
+// Actions recorded in audit log.
+const (
+	AuditLogin        = "login"
+	AuditLoginFailed  = "login_failed"
+	AuditUpload       = "upload"
+	AuditRemove       = "remove"
+	AuditCreateFolder = "create_folder"
+	AuditShare        = "share"
+	AuditRestore      = "restore"
+	AuditRegister     = "register"
+	AuditAccount      = "account"
+	AuditAdmin        = "admin"
+)
+
+// AuditEvent struct keeps single entry of audit log. Audit log is append-only, entries are never changed or removed,
+// also not when user is deleted, so username is stored along with UserId. ComputerName is name of the session
+// or API token used. Details describe the action further, e.g. member and access level for sharing actions.
+// Created is unix timestamp.
+type AuditEvent struct {
+	Id           int64  `db:"id"`
+	Created      int64  `db:"created"`
+	UserId       int64  `db:"user_id"`
+	Username     string `db:"username"`
+	ComputerName string `db:"computername"`
+	Ip           string `db:"ip"`
+	Action       string `db:"action"`
+	Path         string `db:"path"`
+	Details      string `db:"details"`
+}
+
+// AuditFilter struct keeps conditions of audit log query. Zero values do not restrict the query.
+// Path matches given path and everything under it. Since and Until are unix timestamps, both inclusive.
+// Events are returned in order they were recorded, starting after AfterId.
+type AuditFilter struct {
+	Username string
+	Action   string
+	Path     string
+	Since    int64
+	Until    int64
+	AfterId  int64
+	Limit    int
+}
+
+// Appends event to the audit log. Created is set to current time.
+func LogAudit(event *AuditEvent) error {
+	event.Id = 0
+	event.Created = time.Now().Unix()
+	if err := dbAccess.Insert(event); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Returns audit log events matching the filter. Returns nil and error if error has occured.
+func GetAuditEvents(filter AuditFilter) ([]AuditEvent, error) {
+	conditions := []string{"id > ?"}
+	args := []interface{}{filter.AfterId}
+	if filter.Username != "" {
+		conditions = append(conditions, "username = ?")
+		args = append(args, filter.Username)
+	}
+	if filter.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, filter.Action)
+	}
+	if filter.Path != "" && filter.Path != "/" {
+		conditions = append(conditions, "(path = ? or path like ?)")
+		args = append(args, filter.Path, escapeLike(strings.TrimSuffix(filter.Path, "/"))+"/%")
+	}
+	if filter.Since != 0 {
+		conditions = append(conditions, "created >= ?")
+		args = append(args, filter.Since)
+	}
+	if filter.Until != 0 {
+		conditions = append(conditions, "created <= ?")
+		args = append(args, filter.Until)
+	}
+	query := "select * from audit_log where " + strings.Join(conditions, " and ") + " order by id"
+	if filter.Limit > 0 {
+		query += " limit ?"
+		args = append(args, filter.Limit)
+	}
+	var events []AuditEvent
+	if _, err := dbAccess.Select(&events, query, args...); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return events, nil
+}
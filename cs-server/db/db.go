@@ -8,7 +8,7 @@ import (
 
 	"github.com/Sirupsen/logrus"
 	"github.com/coopernurse/gorp"
-	_ "github.com/go-sql-driver/mysql"
+	"github.com/go-sql-driver/mysql"
 )
 
 var dbAccess *gorp.DbMap
@@ -28,6 +28,7 @@ var (
 	ErrEntityAlreadyExists = errors.New("Entity already exists")
 	ErrExist               = errors.New("file already exists")
 	ErrNotExist            = errors.New("file does not exist")
+	ErrInvalidCode         = errors.New("invalid one-time password")
 )
 
 // Initalization function for package. Sets database access, creates missing tables and initalizes logger.
@@ -44,10 +45,111 @@ func InitDb(dbpath string, _logger *logrus.Logger) {
 	dbAccess.AddTableWithName(Session{}, "sessions").SetKeys(true, "Id")
 	dbAccess.AddTableWithName(File{}, "files").SetKeys(true, "Id")
 	dbAccess.AddTableWithName(Revision{}, "revisions").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(ApiToken{}, "api_tokens").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(RecoveryCode{}, "recovery_codes").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(Invite{}, "invites").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(PurgeJob{}, "purge_jobs").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(Share{}, "shares").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(ShareMember{}, "share_members").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(Link{}, "links").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(FileRequest{}, "file_requests").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(Team{}, "teams").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(TeamMember{}, "team_members").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(AuditEvent{}, "audit_log").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(OidcIdentity{}, "oidc_identities").SetKeys(true, "Id")
+	dbAccess.AddTableWithName(UploadReservation{}, "upload_reservations").SetKeys(true, "Id")
 	if err = dbAccess.CreateTablesIfNotExists(); err != nil {
 		logger.Fatal("Unable to create database Tables: " + err.Error())
 	}
+	migrations := []struct{ table, column, definition string }{
+		{"sessions", "last_seen", "bigint not null default 0"},
+		{"sessions", "expires", "bigint not null default 0"},
+		{"sessions", "ip", "varchar(255) not null default ''"},
+		{"users", "totp_secret", "varchar(255) not null default ''"},
+		{"users", "totp_enabled", "tinyint(1) not null default 0"},
+		{"users", "totp_last_step", "bigint not null default 0"},
+		{"users", "is_admin", "tinyint(1) not null default 0"},
+		{"users", "disabled", "tinyint(1) not null default 0"},
+		{"users", "quota", "bigint not null default 0"},
+		{"revisions", "is_removed", "tinyint(1) not null default 0"},
+	}
+	for _, m := range migrations {
+		if err = addColumnIfMissing(m.table, m.column, m.definition); err != nil {
+			logger.Fatal("Unable to migrate database Tables: " + err.Error())
+		}
+	}
+	indexes := []struct {
+		table, name, columns string
+		unique               bool
+	}{
+		{"audit_log", "audit_log_created", "created", false},
+		{"audit_log", "audit_log_username", "username, id", false},
+		{"files", "files_user_path", "user_id, path(191)", false},
+		{"files", "files_user_parent", "user_id, parent(191)", false},
+		{"revisions", "revisions_file_created", "file_id, created", false},
+		{"files", "files_user_removed_id", "user_id, is_removed, id", false},
+		{"oidc_identities", "oidc_identities_subject", "issuer, subject", true},
+		{"upload_reservations", "upload_reservations_path", "user_id, path_hash", true},
+	}
+	for _, i := range indexes {
+		if err = addIndexIfMissing(i.table, i.name, i.columns, i.unique); err != nil {
+			logger.Fatal("Unable to create database indexes: " + err.Error())
+		}
+	}
+	if err = migrateOidcSubjects(); err != nil {
+		logger.Fatal("Unable to migrate single sign-on subjects: " + err.Error())
+	}
+	if err = migrateSessionTokens(); err != nil {
+		logger.Fatal("Unable to migrate session tokens: " + err.Error())
+	}
+	if err = migrateTombstones(); err != nil {
+		logger.Fatal("Unable to record removals of files: " + err.Error())
+	}
+	if err = grantConfiguredAdmins(); err != nil {
+		logger.Fatal("Unable to grant administrator role: " + err.Error())
+	}
+
+}
+
+// Adds column to existing table, if the table was created by older version of server.
+// Returns error if error has occured.
+func addColumnIfMissing(table string, column string, definition string) error {
+	count, err := dbAccess.SelectInt(`select count(*) from information_schema.columns
+	                                     where table_schema = database() and table_name = ? and column_name = ?`, table, column)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	logger.Infof("Adding column %s to table %s", column, table)
+	_, err = dbAccess.Exec("alter table " + table + " add column " + column + " " + definition)
+	return err
+}
+
+// Creates index on given columns of table, if it does not exist yet. Returns error if error has occured.
+func addIndexIfMissing(table string, name string, columns string, unique bool) error {
+	count, err := dbAccess.SelectInt(`select count(*) from information_schema.statistics
+	                                     where table_schema = database() and table_name = ? and index_name = ?`, table, name)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+	logger.Infof("Adding index %s to table %s", name, table)
+	kind := "index"
+	if unique {
+		kind = "unique index"
+	}
+	_, err = dbAccess.Exec("create " + kind + " " + name + " on " + table + " (" + columns + ")")
+	return err
+}
 
+// Returns true if error was caused by violation of unique index.
+func isDuplicateEntry(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
 }
 
 // Closes database connection.
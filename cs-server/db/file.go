@@ -93,6 +93,7 @@ func (file *File) GetMetadata(rev *Revision) (metadata *Metadata, err error) {
 }
 
 // Removes this file. Optional transaction might be given - files is then deleted in context of transaction.
+// Removal is recorded as new revision of the file.
 // Returns nil if successful.
 // Returnes error if error has occured.
 func (file *File) Remove(tx *gorp.Transaction) (err error) {
@@ -109,6 +110,17 @@ func (file *File) Remove(tx *gorp.Transaction) (err error) {
 			}
 		}
 	}
+	current, err := file.GetCurrentRevision()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	tombstone := Revision{IsDir: file.IsDir, Modified: time.Now(), FileId: file.Id, Name: current.Name, UserId: file.UserId, IsRemoved: true}
+	if err = tx.Insert(&tombstone); err != nil {
+		tx.Rollback()
+		return err
+	}
+	file.CurrentRevisionId = tombstone.Id
 	file.IsRemoved = true
 	_, err = tx.Update(file)
 	if err != nil {
@@ -0,0 +1,202 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"crypto/subtle"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long path reserved for upload is kept, in seconds. Reservations of uploads interrupted by server crash
+// are removed after that time.
+const uploadReservationLifetime = 24 * 60 * 60
+
+// FileRequest struct keeps upload-only link, which allows anyone with the link to upload files into folder of the user
+// without seeing its contents. Only hash of the token is stored. Created and Expires are unix timestamps, Expires is 0
+// if request does not expire. MaxFileSize limits single upload and MaxTotalSize all uploads together, 0 means unlimited.
+type FileRequest struct {
+	Id            int64  `db:"id"`
+	UserId        int64  `db:"user_id"`
+	Path          string `db:"path"`
+	Token         string `db:"token"`
+	Created       int64  `db:"created"`
+	Expires       int64  `db:"expires"`
+	MaxFileSize   int64  `db:"max_file_size"`
+	MaxTotalSize  int64  `db:"max_total_size"`
+	Uploads       int64  `db:"uploads"`
+	UploadedBytes int64  `db:"uploaded_bytes"`
+}
+
+// UploadReservation struct keeps path taken by file being uploaded with file request, so concurrent uploads
+// of files with the same name get different names. Path is stored as hash, so it fits in unique index.
+type UploadReservation struct {
+	Id       int64  `db:"id"`
+	UserId   int64  `db:"user_id"`
+	PathHash string `db:"path_hash"`
+	Created  int64  `db:"created"`
+}
+
+// Creates file request for folder at given path. Expires might be zero time.
+// Returns the request and its token, which is not stored and cannot be retrieved later.
+// Returns ErrEntityNotExists if folder does not exist and ErrNotFolder if path is not a folder.
+func (user *User) CreateFileRequest(folder string, expires time.Time, maxFileSize int64, maxTotalSize int64) (*FileRequest, string, error) {
+	folder = toolkit.CleanPath(folder)
+	location, err := user.Resolve(folder)
+	if err != nil {
+		return nil, "", err
+	}
+	if location.Path != "/" {
+		file, err := location.Owner.GetFileByPath(location.Path)
+		if err != nil {
+			return nil, "", err
+		}
+		if file == nil || file.IsRemoved {
+			return nil, "", ErrEntityNotExists
+		}
+		if !file.IsDir {
+			return nil, "", ErrNotFolder
+		}
+	}
+	if !location.CanWrite() {
+		return nil, "", ErrReadOnlyShare
+	}
+	token := toolkit.GetRandHex(32)
+	request := FileRequest{UserId: user.Id, Path: folder, Token: hashToken(token), Created: time.Now().Unix(),
+		MaxFileSize: maxFileSize, MaxTotalSize: maxTotalSize}
+	if !expires.IsZero() {
+		request.Expires = expires.Unix()
+	}
+	if err = dbAccess.Insert(&request); err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+	return &request, token, nil
+}
+
+// Returns all file requests of this user. Returns nil and error if error has occured.
+func (user *User) GetFileRequests() ([]FileRequest, error) {
+	var requests []FileRequest
+	if _, err := dbAccess.Select(&requests, "select * from file_requests where user_id = ? order by created", user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return requests, nil
+}
+
+// Revokes file request with given id, if it belongs to this user. Returns ErrEntityNotExists if there is no such request.
+func (user *User) RevokeFileRequest(id int64) error {
+	result, err := dbAccess.Exec("delete from file_requests where user_id = ? and id = ?", user.Id, id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected < 1 {
+		return ErrEntityNotExists
+	}
+	return nil
+}
+
+// Returns file request and user who created it for given token. Returns nil if request does not exist or error has occured.
+func GetFileRequest(token string) (*FileRequest, *User) {
+	var request FileRequest
+	hash := hashToken(token)
+	if err := dbAccess.SelectOne(&request, "select * from file_requests where token = ?", hash); err != nil {
+		logger.Warning(err)
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(request.Token), []byte(hash)) != 1 {
+		return nil, nil
+	}
+	user := GetUserById(request.UserId)
+	if user == nil {
+		return nil, nil
+	}
+	return &request, user
+}
+
+// Returns true if file request has expired.
+func (request *FileRequest) IsExpired() bool {
+	return request.Expires != 0 && request.Expires < time.Now().Unix()
+}
+
+// Reserves size bytes of total size allowed by the request before upload. Returns false if upload would exceed
+// the limit, also when concurrent uploads used the remaining space.
+func (request *FileRequest) Reserve(size int64) (bool, error) {
+	result, err := dbAccess.Exec(`update file_requests set uploads = uploads + 1, uploaded_bytes = uploaded_bytes + ?
+	                                     where id = ? and (max_total_size = 0 or uploaded_bytes + ? <= max_total_size)`, size, request.Id, size)
+	if err != nil {
+		logger.Error(err)
+		return false, err
+	}
+	if affected, _ := result.RowsAffected(); affected < 1 {
+		return false, nil
+	}
+	request.Uploads++
+	request.UploadedBytes += size
+	return true, nil
+}
+
+// Releases space reserved by Reserve when upload has failed.
+func (request *FileRequest) Release(size int64) error {
+	if _, err := dbAccess.Exec("update file_requests set uploads = uploads - 1, uploaded_bytes = uploaded_bytes - ? where id = ?", size, request.Id); err != nil {
+		logger.Error(err)
+		return err
+	}
+	request.Uploads--
+	request.UploadedBytes -= size
+	return nil
+}
+
+// Reserves path in given folder where file with given name might be uploaded without overwriting existing file
+// or file being uploaded concurrently. Number is appended to the name if it is taken, as uploader can not see
+// folder contents. Returns reserved path and reservation, which should be released when upload is finished.
+func (user *User) ReserveUploadPath(folder string, name string) (string, *UploadReservation, error) {
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return "", nil, err
+	}
+	if _, err = tx.Exec("delete from upload_reservations where created < ?", time.Now().Unix()-uploadReservationLifetime); err != nil {
+		tx.Rollback()
+		logger.Error(err)
+		return "", nil, err
+	}
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	candidate := name
+	for i := 2; ; i++ {
+		filepath := strings.TrimSuffix(folder, "/") + "/" + candidate
+		candidate = base + " (" + strconv.Itoa(i) + ")" + ext
+		file, err := user.GetFileByPath(toolkit.NormalizePath(filepath))
+		if err != nil {
+			tx.Rollback()
+			return "", nil, err
+		}
+		if file != nil && !file.IsRemoved {
+			continue
+		}
+		reservation := &UploadReservation{UserId: user.Id, PathHash: toolkit.GetSha256([]byte(toolkit.NormalizePath(filepath))), Created: time.Now().Unix()}
+		// unique index makes concurrent upload of the same name wait for this transaction and fail
+		if err = tx.Insert(reservation); err != nil {
+			if isDuplicateEntry(err) {
+				continue
+			}
+			tx.Rollback()
+			logger.Error(err)
+			return "", nil, err
+		}
+		if err = tx.Commit(); err != nil {
+			logger.Error(err)
+			return "", nil, err
+		}
+		return filepath, reservation, nil
+	}
+}
+
+// Releases path reserved for upload. Once the upload is stored, the path is taken by the file itself.
+func (reservation *UploadReservation) Release() {
+	if _, err := dbAccess.Delete(reservation); err != nil {
+		logger.Error(err)
+	}
+}
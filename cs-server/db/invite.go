@@ -0,0 +1,80 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"time"
+)
+
+// Invite struct keeps single-use code which allows registration when server is in invite-only mode.
+// Only hash of the code is stored. Created, Expires and Used are unix timestamps, UsedBy is id of registered user.
+type Invite struct {
+	Id        int64  `db:"id"`
+	Code      string `db:"code"`
+	CreatedBy int64  `db:"created_by"`
+	Created   int64  `db:"created"`
+	Expires   int64  `db:"expires"`
+	UsedBy    int64  `db:"used_by"`
+	Used      int64  `db:"used"`
+}
+
+// Creates invite valid for given number of seconds. Returns the invite and its code, which is not stored in plain text.
+func (user *User) CreateInvite(lifetime int64) (*Invite, string, error) {
+	code := toolkit.GetRandHex(16)
+	now := time.Now().Unix()
+	invite := Invite{Code: hashToken(code), CreatedBy: user.Id, Created: now, Expires: now + lifetime}
+	if err := dbAccess.Insert(&invite); err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+	return &invite, code, nil
+}
+
+// Returns all invites, including used and expired ones.
+func GetInvites() ([]Invite, error) {
+	var invites []Invite
+	if _, err := dbAccess.Select(&invites, "select * from invites order by created desc"); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return invites, nil
+}
+
+// Removes unused invite with given id. Returns ErrEntityNotExists if there is no such unused invite.
+func RevokeInvite(id int64) error {
+	result, err := dbAccess.Exec("delete from invites where id = ? and used_by = 0", id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if count, _ := result.RowsAffected(); count < 1 {
+		return ErrEntityNotExists
+	}
+	return nil
+}
+
+// Creates user with given username and password, consuming given invite code. Invite is reserved before
+// the user is created, so it might be used only once even by concurrent requests, and released if user creation fails.
+// Returns ErrInvalidCode if invite does not exist, has expired or was already used.
+func CreateUserWithInvite(username string, password string, code string) (*User, error) {
+	now := time.Now().Unix()
+	// used_by = -1 marks invite reserved by registration in progress
+	result, err := dbAccess.Exec("update invites set used_by = -1, used = ? where code = ? and used_by = 0 and expires > ?", now, hashToken(code), now)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if count, _ := result.RowsAffected(); count < 1 {
+		return nil, ErrInvalidCode
+	}
+	user, err := CreateUser(username, password)
+	if err != nil {
+		if _, releaseErr := dbAccess.Exec("update invites set used_by = 0, used = 0 where code = ?", hashToken(code)); releaseErr != nil {
+			logger.Error(releaseErr)
+		}
+		return nil, err
+	}
+	if _, err = dbAccess.Exec("update invites set used_by = ? where code = ?", user.Id, hashToken(code)); err != nil {
+		logger.Error(err)
+	}
+	return user, nil
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"crypto/subtle"
+	"time"
+)
+
+// Link struct keeps public link to file or folder, which might be downloaded without account.
+// Path is in namespace of the user who created the link, so link to file in mounted shared folder stops working
+// when the user leaves it. Only hashes of the token and the password are stored, Password is empty if link is not protected.
+// Created and Expires are unix timestamps, Expires is 0 if link does not expire. MaxDownloads is 0 if downloads are unlimited.
+type Link struct {
+	Id           int64  `db:"id"`
+	UserId       int64  `db:"user_id"`
+	Path         string `db:"path"`
+	Token        string `db:"token"`
+	Password     string `db:"password"`
+	Created      int64  `db:"created"`
+	Expires      int64  `db:"expires"`
+	MaxDownloads int64  `db:"max_downloads"`
+	Downloads    int64  `db:"downloads"`
+}
+
+// Creates public link to file or folder at given path. Password might be empty, expires might be zero time.
+// Returns the link and its token, which is not stored and cannot be retrieved later.
+// Returns ErrEntityNotExists if path does not exist.
+func (user *User) CreateLink(filepath string, password string, expires time.Time, maxDownloads int64) (*Link, string, error) {
+	filepath = toolkit.CleanPath(filepath)
+	location, err := user.Resolve(filepath)
+	if err != nil {
+		return nil, "", err
+	}
+	file, err := location.Owner.GetFileByPath(location.Path)
+	if err != nil {
+		return nil, "", err
+	}
+	if file == nil || file.IsRemoved {
+		return nil, "", ErrEntityNotExists
+	}
+	token := toolkit.GetRandHex(32)
+	link := Link{UserId: user.Id, Path: filepath, Token: hashToken(token), Created: time.Now().Unix(), MaxDownloads: maxDownloads}
+	if !expires.IsZero() {
+		link.Expires = expires.Unix()
+	}
+	if password != "" {
+		if link.Password, err = HashPassword(password); err != nil {
+			return nil, "", err
+		}
+	}
+	if err = dbAccess.Insert(&link); err != nil {
+		logger.Error(err)
+		return nil, "", err
+	}
+	return &link, token, nil
+}
+
+// Returns all public links of this user. Returns nil and error if error has occured.
+func (user *User) GetLinks() ([]Link, error) {
+	var links []Link
+	if _, err := dbAccess.Select(&links, "select * from links where user_id = ? order by created", user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return links, nil
+}
+
+// Revokes public link with given id, if it belongs to this user. Returns ErrEntityNotExists if there is no such link.
+func (user *User) RevokeLink(id int64) error {
+	result, err := dbAccess.Exec("delete from links where user_id = ? and id = ?", user.Id, id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected < 1 {
+		return ErrEntityNotExists
+	}
+	return nil
+}
+
+// Returns public link and user who created it for given token. Returns nil if link does not exist or error has occured.
+func GetLink(token string) (*Link, *User) {
+	var link Link
+	hash := hashToken(token)
+	if err := dbAccess.SelectOne(&link, "select * from links where token = ?", hash); err != nil {
+		logger.Warning(err)
+		return nil, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(link.Token), []byte(hash)) != 1 {
+		return nil, nil
+	}
+	user := GetUserById(link.UserId)
+	if user == nil {
+		return nil, nil
+	}
+	return &link, user
+}
+
+// Returns true if link has expired or its downloads are exhausted.
+func (link *Link) IsExpired() bool {
+	if link.Expires != 0 && link.Expires < time.Now().Unix() {
+		return true
+	}
+	return link.MaxDownloads != 0 && link.Downloads >= link.MaxDownloads
+}
+
+// Returns true if link is protected by password.
+func (link *Link) HasPassword() bool {
+	return link.Password != ""
+}
+
+// Returns true if password matches password of the link.
+func (link *Link) CheckPassword(password string) bool {
+	return verifyPassword(link.Password, "", password)
+}
+
+// Counts download of the link. Returns false if downloads are exhausted, also when concurrent downloads
+// used the last one.
+func (link *Link) CountDownload() (bool, error) {
+	result, err := dbAccess.Exec("update links set downloads = downloads + 1 where id = ? and (max_downloads = 0 or downloads < max_downloads)", link.Id)
+	if err != nil {
+		logger.Error(err)
+		return false, err
+	}
+	if affected, _ := result.RowsAffected(); affected < 1 {
+		return false, nil
+	}
+	link.Downloads++
+	return true, nil
+}
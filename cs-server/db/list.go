@@ -0,0 +1,125 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Sorting of folder listing. After is the last entry of previous page, listing starts after it, nil for first page.
+// Entries with the same sort key are sorted by path, so the order is stable between requests.
+type ListOrder struct {
+	Sort  string // "name", "size" or "modified"
+	Desc  bool
+	After *Metadata
+}
+
+// Part of folder listing: files of user OwnerId under folder Path, up to Depth levels below it, except contents
+// of Excluded folders. Paths of listed files are translated from prefix Path to prefix ListPath.
+type listSource struct {
+	OwnerId  int64
+	Path     string
+	ListPath string
+	Depth    int
+	Excluded []string
+}
+
+// Returns number of folders above entries of given folder.
+func pathLevel(folder string) int {
+	if folder == "/" {
+		return 0
+	}
+	return strings.Count(folder, "/")
+}
+
+// Returns sources of listing of folder at given location up to given depth. Shared folders mounted inside the folder
+// are listed from namespaces of their owners, as they are mounted now.
+func (user *User) listSources(location *Location, depth int) ([]listSource, error) {
+	folder := toolkit.NormalizePath(location.Path)
+	sources := []listSource{{OwnerId: location.Owner.Id, Path: folder, ListPath: location.Translate(folder), Depth: depth}}
+	if location.Mount != nil || depth <= 1 {
+		return sources, nil
+	}
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, mount := range mounts {
+		levels := pathLevel(mount.MountPath) - pathLevel(folder)
+		if mount.MountPath == folder || !isUnder(mount.MountPath, folder) || levels >= depth {
+			continue
+		}
+		sources[0].Excluded = append(sources[0].Excluded, mount.MountPath)
+		sources = append(sources, listSource{OwnerId: mount.OwnerId, Path: mount.SharePath, ListPath: mount.MountPath, Depth: depth - levels})
+	}
+	return sources, nil
+}
+
+// Returns query listing given sources, as they are now or, if asOf is not zero time, as they were at that time.
+func listQuery(sources []listSource, asOf time.Time, order ListOrder, limit int) (string, []interface{}) {
+	var selects []string
+	var args []interface{}
+	for _, source := range sources {
+		from := strings.TrimSuffix(source.Path, "/")
+		args = append(args, strings.TrimSuffix(source.ListPath, "/"), utf8.RuneCountInString(from)+1)
+		query := `select revisions.hash Hash, revisions.name Name, concat(?, substring(files.path, ?)) Path, revisions.is_dir IsDir,
+		                                     revisions.size Size, revisions.id Rev, revisions.modified Modified, revisions.is_removed IsRemoved`
+		if asOf.IsZero() {
+			query += " from files join revisions on files.current_revision_id = revisions.id where files.is_removed = 0"
+		} else {
+			query += " from " + snapshotJoin + " where revisions.is_removed = 0"
+			args = append(args, asOf.UnixNano())
+		}
+		query += " and files.user_id = ? and files.path like ? and length(files.path) - length(replace(files.path, '/', '')) <= ?"
+		args = append(args, source.OwnerId, escapeLike(from)+"/%", pathLevel(source.Path)+source.Depth)
+		for _, excluded := range source.Excluded {
+			query += " and files.path not like ?"
+			args = append(args, escapeLike(excluded)+"/%")
+		}
+		selects = append(selects, query)
+	}
+	key, param, direction, compare := "lower(Name)", "lower(?)", "asc", ">"
+	switch order.Sort {
+	case "size":
+		key, param = "Size", "?"
+	case "modified":
+		key, param = "Modified", "?"
+	}
+	if order.Desc {
+		direction, compare = "desc", "<"
+	}
+	query := "select * from (" + strings.Join(selects, " union all ") + ") entries"
+	if after := order.After; after != nil {
+		var value interface{} = after.Name
+		switch order.Sort {
+		case "size":
+			value = after.Size
+		case "modified":
+			value = after.Modified
+		}
+		query += " where (" + key + " " + compare + " " + param + " or " + key + " = " + param + " and Path " + compare + " ?)"
+		args = append(args, value, value, after.Path)
+	}
+	query += " order by " + key + " " + direction + ", Path " + direction + " limit ?"
+	args = append(args, limit)
+	return query, args
+}
+
+// Returns page of entries of folder at given location and, up to given depth, of its subfolders, including shared
+// folders mounted inside it, as they are now or, if asOf is not zero time, as they were at that time. Depth 1 returns
+// only children of the folder. Paths are translated to paths seen by the user. Sorting, paging and limit are done
+// by the database.
+func (user *User) ListTree(location *Location, asOf time.Time, depth int, order ListOrder, limit int) ([]Metadata, error) {
+	sources, err := user.listSources(location, depth)
+	if err != nil {
+		return nil, err
+	}
+	query, args := listQuery(sources, asOf, order, limit)
+	entries := make([]Metadata, 0)
+	if _, err = dbAccess.Select(&entries, query, args...); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return entries, nil
+}
@@ -0,0 +1,70 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPathLevel(t *testing.T) {
+	tests := []struct {
+		folder   string
+		expected int
+	}{
+		{"/", 0},
+		{"/a", 1},
+		{"/a/b", 2},
+	}
+	for _, test := range tests {
+		if level := pathLevel(test.folder); level != test.expected {
+			t.Errorf("pathLevel(%q) = %d, expected %d", test.folder, level, test.expected)
+		}
+	}
+}
+
+func TestListQuery(t *testing.T) {
+	asOf := time.Unix(1000, 0)
+	modified := time.Unix(2000, 0)
+	sources := []listSource{
+		{OwnerId: 1, Path: "/", ListPath: "/", Depth: 2, Excluded: []string{"/shared_50%"}},
+		{OwnerId: 2, Path: "/projects/ž", ListPath: "/shared_50%", Depth: 1},
+	}
+	tests := []struct {
+		name     string
+		asOf     time.Time
+		order    ListOrder
+		contains []string
+		args     []interface{}
+	}{
+		{"first page", time.Time{}, ListOrder{Sort: "name"},
+			[]string{"files.is_removed = 0", "order by lower(Name) asc, Path asc limit ?"},
+			[]interface{}{"", 1, int64(1), "/%", 2, "/shared\\_50\\%/%", "/shared_50%", 12, int64(2), "/projects/ž/%", 3, 101}},
+		{"as of", asOf, ListOrder{Sort: "size", Desc: true, After: &Metadata{Size: 7, Path: "/b"}},
+			[]string{"r.created <= ?", "where (Size < ? or Size = ? and Path < ?)", "order by Size desc, Path desc limit ?"},
+			[]interface{}{"", 1, asOf.UnixNano(), int64(1), "/%", 2, "/shared\\_50\\%/%",
+				"/shared_50%", 12, asOf.UnixNano(), int64(2), "/projects/ž/%", 3, int64(7), int64(7), "/b", 101}},
+		{"modified", time.Time{}, ListOrder{Sort: "modified", After: &Metadata{Name: "B", Modified: modified, Path: "/b"}},
+			[]string{"where (Modified > ? or Modified = ? and Path > ?)"},
+			[]interface{}{"", 1, int64(1), "/%", 2, "/shared\\_50\\%/%", "/shared_50%", 12, int64(2), "/projects/ž/%", 3,
+				modified, modified, "/b", 101}},
+		{"name cursor", time.Time{}, ListOrder{Sort: "name", After: &Metadata{Name: "B", Path: "/b"}},
+			[]string{"where (lower(Name) > lower(?) or lower(Name) = lower(?) and Path > ?)"},
+			[]interface{}{"", 1, int64(1), "/%", 2, "/shared\\_50\\%/%", "/shared_50%", 12, int64(2), "/projects/ž/%", 3,
+				"B", "B", "/b", 101}},
+	}
+	for _, test := range tests {
+		query, args := listQuery(sources, test.asOf, test.order, 101)
+		for _, part := range test.contains {
+			if !strings.Contains(query, part) {
+				t.Errorf("%s: query %q does not contain %q", test.name, query, part)
+			}
+		}
+		if strings.Count(query, "?") != len(args) {
+			t.Errorf("%s: query has %d parameters, %d arguments given", test.name, strings.Count(query, "?"), len(args))
+		}
+		if !reflect.DeepEqual(args, test.args) {
+			t.Errorf("%s: arguments %v, expected %v", test.name, args, test.args)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package db
+
+import (
+	"cloudsyncer/cs-server/config"
+	"cloudsyncer/toolkit"
+	"strings"
+)
+
+// Struct linking user to account at OpenID Connect provider. Subject is unique only within its issuer,
+// so identities are matched by both.
+type OidcIdentity struct {
+	Id      int64  `db:"id"`
+	UserId  int64  `db:"user_id"`
+	Issuer  string `db:"issuer"`
+	Subject string `db:"subject"`
+}
+
+// Returns user linked to given OpenID Connect subject of given issuer, or nil if there is no such user.
+func GetUserByOidcSubject(issuer string, subject string) *User {
+	var user User
+	if err := dbAccess.SelectOne(&user, `select users.* from users join oidc_identities on oidc_identities.user_id = users.id
+	                                        where oidc_identities.issuer = ? and oidc_identities.subject = ?`, issuer, subject); err != nil {
+		return nil
+	}
+	if user.Username == "" {
+		return nil
+	}
+	return &user
+}
+
+// Returns true if user is linked to account at any OpenID Connect provider.
+func (user *User) HasOidcIdentity() bool {
+	count, err := dbAccess.SelectInt("select count(*) from oidc_identities where user_id = ?", user.Id)
+	if err != nil {
+		logger.Error(err)
+	}
+	return count > 0
+}
+
+// Returns user for identity confirmed by OpenID Connect provider. Users are matched by issuer and subject first.
+// If no user is linked to the subject, user with username equal to verified email is linked to it.
+// If there is no such user and autoProvision is set, new user is created with random password,
+// so the account is accessible only with single sign-on. Username of new user must satisfy username rules
+// and must not be reserved for administrator.
+// Returns ErrEntityNotExists if user does not exist and might not be created, ErrEntityAlreadyExists
+// if matching user is already linked to other subject of the issuer.
+func GetOidcUser(issuer string, subject string, verifiedEmail string, preferredUsername string, autoProvision bool) (*User, error) {
+	if user := GetUserByOidcSubject(issuer, subject); user != nil {
+		return user, nil
+	}
+	username := strings.TrimSpace(verifiedEmail)
+	if username == "" {
+		username = strings.TrimSpace(preferredUsername)
+	}
+	if username == "" || len(username) > 255 {
+		return nil, ErrEntityNotExists
+	}
+	user := GetUser(username)
+	if user != nil && verifiedEmail == "" {
+		// only verified email is trusted to link existing account
+		return nil, ErrEntityAlreadyExists
+	}
+	if user == nil {
+		if !autoProvision || IsConfiguredAdmin(username) {
+			return nil, ErrEntityNotExists
+		}
+		if errs := ValidateUsername(username); errs != nil {
+			logger.Info("Not provisioning user " + username + " from single sign-on: " + errs.Error())
+			return nil, ErrEntityNotExists
+		}
+		var err error
+		if user, err = CreateUser(username, toolkit.GetRandHex(32)); err != nil {
+			return nil, err
+		}
+		logger.Info("Provisioned user " + username + " from single sign-on")
+	}
+	count, err := dbAccess.SelectInt("select count(*) from oidc_identities where user_id = ? and issuer = ?", user.Id, issuer)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrEntityAlreadyExists
+	}
+	if err = dbAccess.Insert(&OidcIdentity{UserId: user.Id, Issuer: issuer, Subject: subject}); err != nil {
+		if isDuplicateEntry(err) {
+			return nil, ErrEntityAlreadyExists
+		}
+		logger.Error(err)
+		return nil, err
+	}
+	return user, nil
+}
+
+// Moves subjects stored in users table by older versions of server to oidc_identities, namespacing them
+// by configured issuer, and drops the old column. Links are dropped if single sign-on is not configured,
+// users are linked again by verified email on their next single sign-on.
+func migrateOidcSubjects() error {
+	count, err := dbAccess.SelectInt(`select count(*) from information_schema.columns
+	                                     where table_schema = database() and table_name = 'users' and column_name = 'oidc_subject'`)
+	if err != nil || count == 0 {
+		return err
+	}
+	if config.OIDC_ISSUER != "" {
+		result, err := dbAccess.Exec(`insert ignore into oidc_identities (user_id, issuer, subject)
+		                                select id, ?, oidc_subject from users where oidc_subject != ''`, config.OIDC_ISSUER)
+		if err != nil {
+			return err
+		}
+		if moved, _ := result.RowsAffected(); moved > 0 {
+			logger.Infof("Moved %d single sign-on subjects to oidc_identities", moved)
+		}
+	}
+	logger.Info("Dropping column oidc_subject from table users")
+	_, err = dbAccess.Exec("alter table users drop column oidc_subject")
+	return err
+}
@@ -0,0 +1,91 @@
+package db
+
+import (
+	"cloudsyncer/cs-server/config"
+	"cloudsyncer/toolkit"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Prefixes of encoded password hashes. Hashes without known prefix are legacy sha1(salt+password) hashes.
+const (
+	argon2idPrefix = "$argon2id$"
+	bcryptPrefix   = "$2"
+)
+
+var ErrUnknownHash = errors.New("unknown password hash format")
+
+// Returns encoded hash of given password, using algorithm and cost parameters from configuration.
+// Encoded hash carries algorithm prefix, parameters and salt, so it might be verified without any other data.
+func HashPassword(password string) (string, error) {
+	switch config.PASSWORD_HASH {
+	case "bcrypt":
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), config.BCRYPT_COST)
+		if err != nil {
+			return "", err
+		}
+		return string(hash), nil
+	case "argon2id":
+		salt := toolkit.GetRandBytes(16)
+		hash := argon2.IDKey([]byte(password), salt, config.ARGON2_TIME, config.ARGON2_MEMORY, config.ARGON2_THREADS, config.ARGON2_KEY_LENGTH)
+		return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, config.ARGON2_MEMORY, config.ARGON2_TIME, config.ARGON2_THREADS,
+			base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(hash)), nil
+	}
+	return "", ErrUnknownHash
+}
+
+// Returns true if password matches encoded hash. Legacy hashes are checked using given salt.
+func verifyPassword(encoded string, salt string, password string) bool {
+	switch {
+	case strings.HasPrefix(encoded, argon2idPrefix):
+		var version int
+		var memory, time uint32
+		var threads uint8
+		parts := strings.Split(encoded, "$")
+		if len(parts) != 6 {
+			return false
+		}
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return false
+		}
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+			return false
+		}
+		saltBytes, err := base64.RawStdEncoding.DecodeString(parts[4])
+		if err != nil {
+			return false
+		}
+		hash, err := base64.RawStdEncoding.DecodeString(parts[5])
+		if err != nil {
+			return false
+		}
+		calculated := argon2.IDKey([]byte(password), saltBytes, time, memory, threads, uint32(len(hash)))
+		return subtle.ConstantTimeCompare(hash, calculated) == 1
+	case strings.HasPrefix(encoded, bcryptPrefix):
+		return bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password)) == nil
+	}
+	calculated := toolkit.GetSha1([]byte(salt + password))
+	return subtle.ConstantTimeCompare([]byte(encoded), []byte(calculated)) == 1
+}
+
+// Returns true if encoded hash was not created with current algorithm and parameters.
+func needsRehash(encoded string) bool {
+	switch config.PASSWORD_HASH {
+	case "bcrypt":
+		if !strings.HasPrefix(encoded, bcryptPrefix) {
+			return true
+		}
+		cost, err := bcrypt.Cost([]byte(encoded))
+		return err != nil || cost != config.BCRYPT_COST
+	case "argon2id":
+		params := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$", argon2idPrefix, argon2.Version, config.ARGON2_MEMORY, config.ARGON2_TIME, config.ARGON2_THREADS)
+		return !strings.HasPrefix(encoded, params)
+	}
+	return false
+}
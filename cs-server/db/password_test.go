@@ -0,0 +1,88 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerifyPassword(t *testing.T) {
+	argon2Hash, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacyHash := toolkit.GetSha1([]byte("salt" + "secret"))
+	tampered := argon2Hash[:len(argon2Hash)-2] + "AA"
+	if strings.HasSuffix(argon2Hash, "AA") {
+		tampered = argon2Hash[:len(argon2Hash)-2] + "BB"
+	}
+	tests := []struct {
+		name     string
+		encoded  string
+		salt     string
+		password string
+		expected bool
+	}{
+		{"argon2id", argon2Hash, "", "secret", true},
+		{"argon2id wrong password", argon2Hash, "", "Secret", false},
+		{"argon2id tampered hash", tampered, "", "secret", false},
+		{"argon2id missing part", strings.Join(strings.Split(argon2Hash, "$")[:5], "$"), "", "secret", false},
+		{"argon2id other version", strings.Replace(argon2Hash, "$v=19$", "$v=16$", 1), "", "secret", false},
+		{"bcrypt", string(bcryptHash), "", "secret", true},
+		{"bcrypt wrong password", string(bcryptHash), "", "secret ", false},
+		{"legacy", legacyHash, "salt", "secret", true},
+		{"legacy wrong salt", legacyHash, "pepper", "secret", false},
+		{"empty hash", "", "", "", false},
+	}
+	for _, test := range tests {
+		if verified := verifyPassword(test.encoded, test.salt, test.password); verified != test.expected {
+			t.Errorf("%s: verifyPassword = %v, expected %v", test.name, verified, test.expected)
+		}
+	}
+}
+
+func TestHashPasswordSalted(t *testing.T) {
+	first, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Errorf("HashPassword returned the same hash twice: %s", first)
+	}
+}
+
+func TestNeedsRehash(t *testing.T) {
+	current, err := HashPassword("secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		encoded  string
+		expected bool
+	}{
+		{"current", current, false},
+		{"other argon2id parameters", strings.Replace(current, ",t=1,", ",t=2,", 1), true},
+		{"bcrypt", string(bcryptHash), true},
+		{"legacy", toolkit.GetSha1([]byte("saltsecret")), true},
+	}
+	for _, test := range tests {
+		if rehash := needsRehash(test.encoded); rehash != test.expected {
+			t.Errorf("%s: needsRehash = %v, expected %v", test.name, rehash, test.expected)
+		}
+	}
+}
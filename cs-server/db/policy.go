@@ -0,0 +1,98 @@
+package db
+
+import (
+	"cloudsyncer/cs-server/config"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Usernames might contain letters, digits and ".", "_", "-", "@" characters, so emails are valid usernames.
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9._@-]*$`)
+
+// Passwords rejected regardless of their length and character classes.
+var commonPasswords = map[string]bool{
+	"password": true, "password1": true, "password123": true, "12345678": true, "123456789": true,
+	"1234567890": true, "qwerty123": true, "qwertyuiop": true, "iloveyou": true, "letmein1": true,
+	"welcome1": true, "admin123": true, "abc12345": true, "11111111": true, "00000000": true,
+	"cloudsyncer": true,
+}
+
+// PolicyError describes single violation of registration policy. Field is the name of form parameter
+// which caused the violation, empty if it is not related to single parameter. Code is stable identifier
+// which clients might use to translate the message.
+type PolicyError struct {
+	Field   string `json:"field,omitempty"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e PolicyError) Error() string {
+	return e.Message
+}
+
+// PolicyErrors is a list of all violations found in single request.
+type PolicyErrors []PolicyError
+
+func (e PolicyErrors) Error() string {
+	messages := make([]string, len(e))
+	for i, err := range e {
+		messages[i] = err.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
+// Checks whether username satisfies username rules. Returns nil if it does.
+func ValidateUsername(username string) PolicyErrors {
+	var errs PolicyErrors
+	if len(username) < config.USERNAME_MIN_LENGTH || len(username) > config.USERNAME_MAX_LENGTH {
+		errs = append(errs, PolicyError{"username", "username_length", "Username must be between " +
+			strconv.Itoa(config.USERNAME_MIN_LENGTH) + " and " + strconv.Itoa(config.USERNAME_MAX_LENGTH) + " characters long"})
+	}
+	if !usernamePattern.MatchString(username) {
+		errs = append(errs, PolicyError{"username", "username_characters",
+			"Username might contain only letters, digits and . _ - @ characters, and must start with letter or digit"})
+	}
+	return errs
+}
+
+// Checks whether password satisfies password strength rules. Returns nil if it does.
+func ValidatePassword(username string, password string) PolicyErrors {
+	var errs PolicyErrors
+	if len(password) < config.PASSWORD_MIN_LENGTH {
+		errs = append(errs, PolicyError{"password", "password_too_short",
+			"Password must be at least " + strconv.Itoa(config.PASSWORD_MIN_LENGTH) + " characters long"})
+	}
+	var lower, upper, digit, other bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			other = true
+		}
+	}
+	classes := 0
+	for _, present := range []bool{lower, upper, digit, other} {
+		if present {
+			classes++
+		}
+	}
+	if classes < config.PASSWORD_MIN_CHARACTER_CLASSES {
+		errs = append(errs, PolicyError{"password", "password_too_simple", "Password must contain at least " +
+			strconv.Itoa(config.PASSWORD_MIN_CHARACTER_CLASSES) + " of: lowercase letters, uppercase letters, digits, other characters"})
+	}
+	lowered := strings.ToLower(password)
+	if commonPasswords[lowered] {
+		errs = append(errs, PolicyError{"password", "password_common", "Password is too common"})
+	}
+	if username != "" && strings.Contains(lowered, strings.ToLower(username)) {
+		errs = append(errs, PolicyError{"password", "password_contains_username", "Password must not contain username"})
+	}
+	return errs
+}
@@ -0,0 +1,83 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Returns codes of policy errors in order they were reported.
+func policyCodes(errs PolicyErrors) []string {
+	var codes []string
+	for _, err := range errs {
+		codes = append(codes, err.Code)
+	}
+	return codes
+}
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		username string
+		expected []string
+	}{
+		{"john", nil},
+		{"john.smith@example.com", nil},
+		{"j_s-1", nil},
+		{"abc", nil},
+		{strings.Repeat("a", 64), nil},
+		{"ab", []string{"username_length"}},
+		{strings.Repeat("a", 65), []string{"username_length"}},
+		{"", []string{"username_length", "username_characters"}},
+		{".john", []string{"username_characters"}},
+		{"-john", []string{"username_characters"}},
+		{"john smith", []string{"username_characters"}},
+		{"john/smith", []string{"username_characters"}},
+		{"jöhn", []string{"username_characters"}},
+		{"john\n", []string{"username_characters"}},
+	}
+	for _, test := range tests {
+		if codes := policyCodes(ValidateUsername(test.username)); !reflect.DeepEqual(codes, test.expected) {
+			t.Errorf("ValidateUsername(%q) = %v, expected %v", test.username, codes, test.expected)
+		}
+	}
+}
+
+func TestValidatePassword(t *testing.T) {
+	tests := []struct {
+		username string
+		password string
+		expected []string
+	}{
+		{"john", "correct horse battery", nil},
+		{"john", "Tr0ub4dor&3", nil},
+		{"john", "abcdefghij1", nil},
+		{"john", "short1A", []string{"password_too_short"}},
+		{"john", "abcdefghijk", []string{"password_too_simple"}},
+		{"john", "ABCDEFGHIJK", []string{"password_too_simple"}},
+		{"john", "1234567890", []string{"password_too_simple", "password_common"}},
+		{"john", "Password123", []string{"password_common"}},
+		{"john", "qwerty123", []string{"password_too_short", "password_common"}},
+		{"john", "my name is John!", []string{"password_contains_username"}},
+		{"", "my name is John!", nil},
+		{"john", "", []string{"password_too_short", "password_too_simple"}},
+	}
+	for _, test := range tests {
+		if codes := policyCodes(ValidatePassword(test.username, test.password)); !reflect.DeepEqual(codes, test.expected) {
+			t.Errorf("ValidatePassword(%q, %q) = %v, expected %v", test.username, test.password, codes, test.expected)
+		}
+	}
+}
+
+func TestPolicyErrorsError(t *testing.T) {
+	errs := append(ValidateUsername("a b"), ValidatePassword("a b", "x")...)
+	var messages []string
+	for _, err := range errs {
+		if err.Field == "" || err.Message == "" {
+			t.Errorf("policy error %+v has no field or message", err)
+		}
+		messages = append(messages, err.Message)
+	}
+	if message := errs.Error(); message != strings.Join(messages, ", ") {
+		t.Errorf("PolicyErrors.Error() = %q, expected messages of %d errors", message, len(errs))
+	}
+}
@@ -0,0 +1,187 @@
+package db
+
+import (
+	"errors"
+	"time"
+)
+
+// Error returned when user being purged is not disabled.
+var ErrUserEnabled = errors.New("purged user is enabled")
+
+// States of purge job.
+const (
+	PurgePending = "pending"
+	PurgeRunning = "running"
+	PurgeDone    = "done"
+	PurgeFailed  = "failed"
+)
+
+// PurgeJob struct keeps state of removal of user account with all their data. Job is processed in background
+// and its progress is stored after each batch, so it resumes where it stopped after server restart.
+// File contents are removed from storage only if no other user references them.
+// Created, Updated and Finished are unix timestamps.
+type PurgeJob struct {
+	Id             int64  `db:"id"`
+	UserId         int64  `db:"user_id"`
+	Username       string `db:"username"`
+	RequestedBy    string `db:"requested_by"`
+	Status         string `db:"status"`
+	TotalBlobs     int64  `db:"total_blobs"`
+	ProcessedBlobs int64  `db:"processed_blobs"`
+	RemovedBlobs   int64  `db:"removed_blobs"`
+	Error          string `db:"error"`
+	Created        int64  `db:"created"`
+	Updated        int64  `db:"updated"`
+	Finished       int64  `db:"finished"`
+}
+
+// Creates purge job for given user. User is disabled and logged out immediately, so their data does not change
+// while it is removed. Their shared folders are unshared and mounted shared folders are left. Returns ErrEntityAlreadyExists and existing job if user is already being purged.
+// Job is stored before the user is disabled, so the user can not be enabled again meanwhile.
+func CreatePurgeJob(user *User, requestedBy string) (*PurgeJob, error) {
+	var existing PurgeJob
+	if err := dbAccess.SelectOne(&existing, "select * from purge_jobs where user_id = ? and status != ?", user.Id, PurgeDone); err == nil && existing.Id != 0 {
+		return &existing, ErrEntityAlreadyExists
+	}
+	now := time.Now().Unix()
+	job := PurgeJob{UserId: user.Id, Username: user.Username, RequestedBy: requestedBy, Status: PurgePending,
+		Created: now, Updated: now}
+	if err := dbAccess.Insert(&job); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if err := job.prepare(user); err != nil {
+		// user is not disabled, so the job might not run
+		dbAccess.Delete(&job)
+		return nil, err
+	}
+	return &job, nil
+}
+
+// Disables and logs out user of new job, leaves their shares and stores number of blobs to process.
+func (job *PurgeJob) prepare(user *User) error {
+	user.Disabled = true
+	if err := user.Save(); err != nil {
+		return err
+	}
+	if _, err := user.RevokeAllSessions(); err != nil {
+		return err
+	}
+	if _, err := dbAccess.Exec("delete from api_tokens where user_id = ?", user.Id); err != nil {
+		logger.Error(err)
+		return err
+	}
+	if err := user.LeaveAllShares(); err != nil {
+		return err
+	}
+	total, err := dbAccess.SelectInt("select count(distinct uuid) from revisions where user_id = ? and uuid != ''", user.Id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	job.TotalBlobs = total
+	return job.Save()
+}
+
+// Returns true if account of the user is being deleted, that is if the user has purge job which is not done.
+func (user *User) IsBeingPurged() (bool, error) {
+	count, err := dbAccess.SelectInt("select count(*) from purge_jobs where user_id = ? and status != ?", user.Id, PurgeDone)
+	if err != nil {
+		logger.Error(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Returns ErrUserEnabled if purged user exists and is not disabled, so their account must not be deleted.
+func (job *PurgeJob) CheckUserDisabled() error {
+	user := GetUserById(job.UserId)
+	if user != nil && !user.Disabled {
+		return ErrUserEnabled
+	}
+	return nil
+}
+
+// Returns all purge jobs, newest first.
+func GetPurgeJobs() ([]PurgeJob, error) {
+	var jobs []PurgeJob
+	if _, err := dbAccess.Select(&jobs, "select * from purge_jobs order by id desc"); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return jobs, nil
+}
+
+// Returns purge job with given id, or nil if it does not exist.
+func GetPurgeJob(id int64) *PurgeJob {
+	var job PurgeJob
+	if err := dbAccess.SelectOne(&job, "select * from purge_jobs where id = ?", id); err != nil || job.Id == 0 {
+		return nil
+	}
+	return &job
+}
+
+// Returns oldest job which is pending or was interrupted while running. Returns double nil if there is no such job.
+func NextPurgeJob() (*PurgeJob, error) {
+	var jobs []PurgeJob
+	if _, err := dbAccess.Select(&jobs, "select * from purge_jobs where status in (?, ?) order by id limit 1", PurgePending, PurgeRunning); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if len(jobs) == 0 {
+		return nil, nil
+	}
+	return &jobs[0], nil
+}
+
+// Stores progress of the job.
+func (job *PurgeJob) Save() error {
+	job.Updated = time.Now().Unix()
+	if _, err := dbAccess.Update(job); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Returns up to limit storage uuids still referenced by revisions of purged user.
+func (job *PurgeJob) NextBlobs(limit int) ([]string, error) {
+	var uuids []string
+	if _, err := dbAccess.Select(&uuids, "select distinct uuid from revisions where user_id = ? and uuid != '' limit ?", job.UserId, limit); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return uuids, nil
+}
+
+// Returns true if content identified by uuid is referenced by revisions of other users than the purged one.
+func (job *PurgeJob) IsBlobShared(uuid string) (bool, error) {
+	count, err := dbAccess.SelectInt("select count(*) from revisions where uuid = ? and user_id != ?", uuid, job.UserId)
+	if err != nil {
+		logger.Error(err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+// Removes revisions of purged user referencing given uuid. Called after content is removed from storage,
+// so the uuid is not returned by NextBlobs anymore.
+func (job *PurgeJob) RemoveBlobRevisions(uuid string) error {
+	if _, err := dbAccess.Exec("delete from revisions where user_id = ? and uuid = ?", job.UserId, uuid); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Removes remaining records of purged user, including the user itself, and marks the job as done.
+func (job *PurgeJob) Finish() error {
+	if user := GetUserById(job.UserId); user != nil {
+		if err := user.Delete(); err != nil {
+			return err
+		}
+	}
+	job.Status = PurgeDone
+	job.Finished = time.Now().Unix()
+	return job.Save()
+}
@@ -0,0 +1,196 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"path"
+	"sort"
+	"time"
+)
+
+// Actions of folder restore.
+const (
+	RestoreUndelete = "undelete"
+	RestoreRevert   = "revert"
+	RestoreRemove   = "remove"
+)
+
+// RestoreChange describes change of single file made by restore of folder to point in time.
+// Path is in namespace of the owner. Rev is revision which becomes current again, 0 for removals.
+// Size is size of that revision and Replaced is size of current revision, which no longer counts into usage.
+type RestoreChange struct {
+	Action   string
+	Path     string
+	Name     string
+	IsDir    bool
+	Rev      int64
+	Size     int64
+	Replaced int64
+	revision *Revision
+}
+
+// Returns revision of this file which was current at given time, or nil if file did not exist yet.
+func (file *File) getRevisionAsOf(asOf time.Time) (*Revision, error) {
+	var revisions []Revision
+	if _, err := dbAccess.Select(&revisions, `select * from revisions where id =
+	                                     (select max(id) from revisions where file_id = ? and created <= ?)`, file.Id, asOf.UnixNano()); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if len(revisions) == 0 {
+		return nil, nil
+	}
+	return &revisions[0], nil
+}
+
+// Returns changes needed to restore folder at given path to its state at given time: files removed since then
+// are undeleted, changed files are reverted to revision current at that time and files created later are removed.
+// Mount points of shared folders are left as they are. Changes are sorted by path.
+// Returns ErrEntityNotExists if folder did not exist at given time and ErrNotFolder if path was not a folder.
+func (user *User) PlanRestore(folder string, asOf time.Time) ([]RestoreChange, error) {
+	folder = toolkit.NormalizePath(folder)
+	var files []File
+	if folder == "/" {
+		if _, err := dbAccess.Select(&files, "select * from files where user_id = ?", user.Id); err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+	} else {
+		metadata, err := user.GetMetadataAsOf(folder, asOf)
+		if err != nil {
+			return nil, err
+		}
+		if metadata == nil {
+			return nil, ErrEntityNotExists
+		}
+		if !metadata.IsDir {
+			return nil, ErrNotFolder
+		}
+		if _, err = dbAccess.Select(&files, "select * from files where user_id = ? and (path = ? or path like ?)",
+			user.Id, folder, escapeLike(folder)+"/%"); err != nil {
+			logger.Error(err)
+			return nil, err
+		}
+	}
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	changes := make([]RestoreChange, 0)
+next:
+	for _, file := range files {
+		for _, mount := range mounts {
+			if isUnder(file.Path, mount.MountPath) {
+				continue next
+			}
+		}
+		past, err := file.getRevisionAsOf(asOf)
+		if err != nil {
+			return nil, err
+		}
+		current, err := file.GetCurrentRevision()
+		if err != nil {
+			return nil, err
+		}
+		var replaced int64
+		if !file.IsRemoved {
+			replaced = current.Size
+		}
+		change := RestoreChange{Path: file.Path, Name: current.Name, IsDir: current.IsDir, Replaced: replaced}
+		switch {
+		case past == nil || past.IsRemoved:
+			if file.IsRemoved {
+				continue
+			}
+			change.Action = RestoreRemove
+		case file.IsRemoved:
+			change.Action = RestoreUndelete
+		case past.Uuid != current.Uuid || past.Hash != current.Hash || past.Size != current.Size ||
+			past.Name != current.Name || past.IsDir != current.IsDir:
+			change.Action = RestoreRevert
+		default:
+			continue
+		}
+		if change.Action != RestoreRemove {
+			change.Name, change.IsDir, change.Rev, change.Size, change.revision = past.Name, past.IsDir, past.Id, past.Size, past
+		}
+		changes = append(changes, change)
+	}
+	sort.Sort(restoreByPath(changes))
+	return changes, nil
+}
+
+// Applies changes returned by PlanRestore in single transaction, if error occurs no change is applied.
+// Undeleted and reverted files get new revision with contents of restored revision. Folders are restored before
+// their contents and removed together with them. Folders shared from removed folders are unshared after the changes
+// are committed. Returns metadata of changed files and members whose mount points were removed by unsharing.
+func (user *User) ApplyRestore(changes []RestoreChange) ([]Metadata, []ShareMember, error) {
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return nil, nil, err
+	}
+	var removed []*File
+next:
+	for _, change := range changes {
+		file, err := user.GetFileByPath(change.Path)
+		if err == nil && file == nil {
+			err = ErrEntityNotExists
+		}
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		if change.Action != RestoreRemove {
+			rev := change.revision
+			if _, err = user.storeFile(tx, file, path.Join(toolkit.Dir(change.Path), rev.Name), rev.IsDir, rev.Uuid, rev.Size, rev.Hash); err != nil {
+				tx.Rollback()
+				return nil, nil, err
+			}
+			continue
+		}
+		// contents of removed folder are removed with it
+		for _, folder := range removed {
+			if isUnder(file.Path, folder.Path) {
+				continue next
+			}
+		}
+		if err = file.Remove(tx); err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		removed = append(removed, file)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	var unmounted []ShareMember
+	for _, file := range removed {
+		if !file.IsDir {
+			continue
+		}
+		members, err := user.DeleteSharesUnder(file.Path)
+		if err != nil {
+			return nil, unmounted, err
+		}
+		unmounted = append(unmounted, members...)
+	}
+	applied := make([]Metadata, 0, len(changes))
+	for _, change := range changes {
+		file, err := user.GetFileByPath(change.Path)
+		if err != nil {
+			return applied, unmounted, err
+		}
+		metadata, err := file.GetMetadata(nil)
+		if err != nil {
+			return applied, unmounted, err
+		}
+		applied = append(applied, *metadata)
+	}
+	return applied, unmounted, nil
+}
+
+// Sorts restore changes by path, so parent folders are before their contents.
+type restoreByPath []RestoreChange
+
+func (c restoreByPath) Len() int           { return len(c) }
+func (c restoreByPath) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c restoreByPath) Less(i, j int) bool { return c[i].Path < c[j].Path }
@@ -6,19 +6,21 @@ import (
 	"github.com/coopernurse/gorp"
 )
 
-// Revision struct keeps information about single revision of file.
+// Revision struct keeps information about single revision of file. Removal of file is recorded as revision
+// with IsRemoved set, so state of files at any point in time might be reconstructed.
 type Revision struct {
-	Id       int64     `db:"id"`
-	Uuid     string    `db:"uuid"`
-	Hash     string    `db:"hash"`
-	Size     int64     `db:"size"`
-	Created  int64     `db:"created"`
-	Updated  int64     `db:"updated"`
-	Modified time.Time `db:"modified"`
-	FileId   int64     `db:"file_id"`
-	IsDir    bool      `db:"is_dir"`
-	Name     string    `db:"name"`
-	UserId   int64     `db:"user_id"`
+	Id        int64     `db:"id"`
+	Uuid      string    `db:"uuid"`
+	Hash      string    `db:"hash"`
+	Size      int64     `db:"size"`
+	Created   int64     `db:"created"`
+	Updated   int64     `db:"updated"`
+	Modified  time.Time `db:"modified"`
+	FileId    int64     `db:"file_id"`
+	IsDir     bool      `db:"is_dir"`
+	Name      string    `db:"name"`
+	UserId    int64     `db:"user_id"`
+	IsRemoved bool      `db:"is_removed"`
 }
 
 // Method invoked by gorp each time new Revision record is inserted into the database.
@@ -36,6 +38,40 @@ func (r *Revision) PreUpdate(s gorp.SqlExecutor) error {
 	return nil
 }
 
+// Records removal of files removed by older versions of server, which did not create tombstone revisions.
+// Time of such removal is not known, so it is recorded right after the last change of the file.
+// Snapshots and restores never bring such files back, but they miss the file between its last change and removal.
+func migrateTombstones() error {
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return err
+	}
+	result, err := tx.Exec(`insert into revisions (uuid, hash, size, created, updated, modified, file_id, is_dir, name, user_id, is_removed)
+	                          select '', '', 0, greatest(revisions.created, revisions.updated) + 1, greatest(revisions.created, revisions.updated) + 1,
+	                                 revisions.modified, files.id, files.is_dir, revisions.name, files.user_id, 1
+	                          from files join revisions on revisions.id = files.current_revision_id
+	                          where files.is_removed = 1 and revisions.is_removed = 0`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	added, _ := result.RowsAffected()
+	if added == 0 {
+		return tx.Rollback()
+	}
+	if _, err = tx.Exec(`update files set current_revision_id =
+	                       (select max(id) from revisions where revisions.file_id = files.id and revisions.is_removed = 1)
+	                       where is_removed = 1 and current_revision_id in (select id from revisions where is_removed = 0)`); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	logger.Infof("Recorded removal of %d files removed by older version of server", added)
+	return nil
+}
+
 // Returns pointer to Metadata struct on this revision. Returns nil and error if error has occured.
 func (revision *Revision) GetMetadata() (metadata *Metadata, err error) {
 	metadata = new(Metadata)
@@ -47,3 +83,13 @@ func (revision *Revision) GetMetadata() (metadata *Metadata, err error) {
 	}
 	return metadata, nil
 }
+
+// Returns revision of this user with given id. Returns nil and error if revision does not exist or error has occured.
+func (user *User) GetRevisionById(id int64) (*Revision, error) {
+	revision := new(Revision)
+	if err := dbAccess.SelectOne(revision, "select * from revisions where id = ? and user_id = ?", id, user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return revision, nil
+}
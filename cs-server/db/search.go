@@ -0,0 +1,91 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"strings"
+	"time"
+)
+
+// SearchQuery struct keeps conditions of file search. Zero values do not restrict the search, except that
+// MaxSize 0 means no upper limit. Name is substring of file name, or glob pattern matching whole name
+// if it contains * or ?. Names are matched case insensitively. Results are ordered by file id, starting after AfterId.
+type SearchQuery struct {
+	Name           string
+	Extension      string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	AfterId        int64
+	Limit          int
+}
+
+// SearchResult struct keeps file found by search, with id of the file used for paging.
+type SearchResult struct {
+	Id       int64
+	Size     int64
+	Rev      int64
+	Name     string
+	Modified time.Time
+	Path     string
+	Hash     string
+}
+
+// Converts pattern given by user to LIKE pattern. Glob wildcards * and ? are converted, if pattern contains none,
+// it matches names containing it.
+func likePattern(pattern string) string {
+	like := strings.NewReplacer("*", "%", "?", "_").Replace(escapeLike(pattern))
+	if !strings.ContainsAny(pattern, "*?") {
+		like = "%" + like + "%"
+	}
+	return like
+}
+
+// Searches files of this user in given folder and its subfolders. Folders themselves are not returned.
+// Returns nil and error if error has occured.
+func (user *User) Search(folder string, query SearchQuery) ([]SearchResult, error) {
+	conditions := []string{"files.user_id = ?", "files.is_removed = 0", "revisions.is_dir = 0", "files.id > ?"}
+	args := []interface{}{user.Id, query.AfterId}
+	if folder = toolkit.NormalizePath(folder); folder != "/" {
+		conditions = append(conditions, "files.path like ?")
+		args = append(args, escapeLike(strings.TrimSuffix(folder, "/"))+"/%")
+	}
+	if query.Name != "" {
+		conditions = append(conditions, "revisions.name like ?")
+		args = append(args, likePattern(query.Name))
+	}
+	if query.Extension != "" {
+		conditions = append(conditions, "revisions.name like ?")
+		args = append(args, "%."+escapeLike(strings.TrimPrefix(query.Extension, ".")))
+	}
+	if query.MinSize > 0 {
+		conditions = append(conditions, "revisions.size >= ?")
+		args = append(args, query.MinSize)
+	}
+	if query.MaxSize > 0 {
+		conditions = append(conditions, "revisions.size <= ?")
+		args = append(args, query.MaxSize)
+	}
+	if !query.ModifiedAfter.IsZero() {
+		conditions = append(conditions, "revisions.modified >= ?")
+		args = append(args, query.ModifiedAfter)
+	}
+	if !query.ModifiedBefore.IsZero() {
+		conditions = append(conditions, "revisions.modified <= ?")
+		args = append(args, query.ModifiedBefore)
+	}
+	sql := `select files.id Id, revisions.size Size, revisions.id Rev, revisions.name Name, revisions.modified Modified,
+	                                     files.path Path, revisions.hash Hash
+	                                     from files join revisions on files.current_revision_id = revisions.id
+	                                     where ` + strings.Join(conditions, " and ") + " order by files.id"
+	if query.Limit > 0 {
+		sql += " limit ?"
+		args = append(args, query.Limit)
+	}
+	var results []SearchResult
+	if _, err := dbAccess.Select(&results, sql, args...); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return results, nil
+}
@@ -0,0 +1,42 @@
+package db
+
+import "testing"
+
+func TestEscapeLike(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"report", "report"},
+		{"50%", "50\\%"},
+		{"a_b", "a\\_b"},
+		{"c:\\dir", "c:\\\\dir"},
+		{"\\%_", "\\\\\\%\\_"},
+		{"*?", "*?"},
+	}
+	for _, test := range tests {
+		if escaped := escapeLike(test.s); escaped != test.expected {
+			t.Errorf("escapeLike(%q) = %q, expected %q", test.s, escaped, test.expected)
+		}
+	}
+}
+
+func TestLikePattern(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{"report", "%report%"},
+		{"*.pdf", "%.pdf"},
+		{"report-20??.pdf", "report-20__.pdf"},
+		{"report*", "report%"},
+		{"50%", "%50\\%%"},
+		{"a_b*", "a\\_b%"},
+		{"back\\slash", "%back\\\\slash%"},
+	}
+	for _, test := range tests {
+		if like := likePattern(test.pattern); like != test.expected {
+			t.Errorf("likePattern(%q) = %q, expected %q", test.pattern, like, test.expected)
+		}
+	}
+}
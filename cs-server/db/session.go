@@ -1,18 +1,26 @@
 package db
 
 import (
+	"cloudsyncer/cs-server/config"
+	"cloudsyncer/toolkit"
+	"crypto/subtle"
 	"time"
 
 	"github.com/nu7hatch/gouuid"
 )
 
 // Session struct keeps information about single user session.
+// Expires and LastSeen are unix timestamps, Expires equal to 0 means session was created before expiry was introduced
+// and it is set on next use.
 type Session struct {
 	Id           int64  `db:"id"`
 	UserId       int64  `db:"user_id"`
 	Token        string `db:"token"`
 	Created      int64  `db:"created"`
 	ComputerName string `db:"computername"`
+	LastSeen     int64  `db:"last_seen"`
+	Expires      int64  `db:"expires"`
+	Ip           string `db:"ip"`
 }
 
 // Returns user attached with this session. returns nil if there was an error.
@@ -30,30 +38,129 @@ func (session *Session) GetUser() *User {
 
 }
 
-// Creates new session for given user and computer name. Session does not check anything (for example password),
-// It just creates new token and stores session record in database. If successful, returns pointer to session struct.
+// Creates new session for given user, computer name and client IP address. Session does not check anything (for example password),
+// It just creates new token and stores session record in database. Only hash of the token is stored, so the token itself
+// is returned separately and cannot be retrieved later. If successful, returns pointer to session struct and token.
 // Returns nil and error if error has occured.
-func CreateSession(user *User, computername string) (*Session, error) {
+func CreateSession(user *User, computername string, ip string) (*Session, string, error) {
 	u4, err := uuid.NewV4()
-	var calculatedToken = u4.String()
-	var session = Session{UserId: user.Id, Token: calculatedToken, ComputerName: computername, Created: time.Now().Unix()}
+	if err != nil {
+		return nil, "", err
+	}
+	var token = u4.String()
+	var now = time.Now().Unix()
+	var session = Session{UserId: user.Id, Token: hashToken(token), ComputerName: computername, Created: now,
+		LastSeen: now, Expires: now + config.SESSION_LIFETIME, Ip: ip}
 	err = dbAccess.Insert(&session)
-	return &session, err
+	return &session, token, err
 }
 
-// Returns session for given user and token. If successful, returns pointer to session struct.
+// Returns session for given user and token. Token is compared with stored hashes in constant time.
+// If successful, returns pointer to session struct.
 // Returns nil if session does not exist or error has occured.
 func GetSession(user *User, tokenString string) *Session {
-	var session Session
-	if err := dbAccess.SelectOne(&session, "select * from sessions where user_id=? and token=?", user.Id, tokenString); err != nil {
+	var sessions []Session
+	if _, err := dbAccess.Select(&sessions, "select * from sessions where user_id=?", user.Id); err != nil {
 		logger.Error(err)
 		return nil
 	}
-	if session.Token == "" {
-		logger.Warning(ErrEntityNotExists)
+	hash := []byte(hashToken(tokenString))
+	for index := range sessions {
+		if subtle.ConstantTimeCompare([]byte(sessions[index].Token), hash) == 1 {
+			return &sessions[index]
+		}
+	}
+	logger.Warning(ErrEntityNotExists)
+	return nil
+}
+
+// Returns hash of session token, as stored in database.
+func hashToken(token string) string {
+	return toolkit.GetSha256([]byte(token))
+}
+
+// Replaces plaintext tokens stored by older versions of server with their hashes.
+// Hashes are always 64 characters long, while plaintext tokens are uuids.
+func migrateSessionTokens() error {
+	var sessions []Session
+	if _, err := dbAccess.Select(&sessions, "select * from sessions where length(token) != 64"); err != nil {
+		return err
+	}
+	for index := range sessions {
+		sessions[index].Token = hashToken(sessions[index].Token)
+		if _, err := dbAccess.Update(&sessions[index]); err != nil {
+			return err
+		}
+	}
+	if len(sessions) > 0 {
+		logger.Infof("Hashed %d plaintext session tokens", len(sessions))
+	}
+	return nil
+}
+
+// Returns true if session has expired and can no longer be used.
+func (session *Session) IsExpired() bool {
+	return session.Expires != 0 && session.Expires < time.Now().Unix()
+}
+
+// Extends session lifetime and records time and IP address of its last use.
+// Database is updated only if session was not renewed recently or IP address has changed.
+func (session *Session) Touch(ip string) error {
+	now := time.Now().Unix()
+	if now-session.LastSeen < config.SESSION_RENEW_INTERVAL && session.Ip == ip && session.Expires != 0 {
 		return nil
+	}
+	session.LastSeen = now
+	session.Expires = now + config.SESSION_LIFETIME
+	session.Ip = ip
+	if _, err := dbAccess.Update(session); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
 
+// Returns all not expired sessions of this user, most recently used first.
+// Returns nil and error if error has occured.
+func (user *User) GetSessions() ([]Session, error) {
+	var sessions []Session
+	if _, err := dbAccess.Select(&sessions, "select * from sessions where user_id = ? and (expires = 0 or expires >= ?) order by last_seen desc",
+		user.Id, time.Now().Unix()); err != nil {
+		logger.Error(err)
+		return nil, err
 	}
-	return &session
+	return sessions, nil
+}
 
+// Revokes session with given id, if it belongs to this user. Returns ErrEntityNotExists if there is no such session.
+func (user *User) RevokeSession(id int64) error {
+	result, err := dbAccess.Exec("delete from sessions where user_id = ? and id = ?", user.Id, id)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if affected, _ := result.RowsAffected(); affected < 1 {
+		return ErrEntityNotExists
+	}
+	return nil
+}
+
+// Revokes all sessions of this user except the given one. Returns number of revoked sessions.
+func (user *User) RevokeOtherSessions(current *Session) (int64, error) {
+	result, err := dbAccess.Exec("delete from sessions where user_id = ? and id != ?", user.Id, current.Id)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// Removes all sessions of this user, logging out all devices. Returns number of removed sessions.
+func (user *User) RevokeAllSessions() (int64, error) {
+	result, err := dbAccess.Exec("delete from sessions where user_id = ?", user.Id)
+	if err != nil {
+		logger.Error(err)
+		return 0, err
+	}
+	return result.RowsAffected()
 }
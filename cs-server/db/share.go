@@ -0,0 +1,452 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"errors"
+	"github.com/coopernurse/gorp"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Access levels of shared folder members.
+const (
+	ShareRead  = "read"
+	ShareWrite = "write"
+)
+
+// Custom errors
+var (
+	ErrNotFolder     = errors.New("path is not a folder")
+	ErrNestedShare   = errors.New("folder is inside or contains other shared folder")
+	ErrReadOnlyShare = errors.New("shared folder is read-only")
+)
+
+// Share struct keeps folder of owner which is shared with other users. Files of shared folder are stored only
+// in namespace of the owner, members access them through mount points.
+type Share struct {
+	Id      int64  `db:"id"`
+	OwnerId int64  `db:"owner_id"`
+	Path    string `db:"path"`
+	Created int64  `db:"created"`
+}
+
+// ShareMember struct keeps membership of user in shared folder. Shared folder is visible to the member at MountPath,
+// where empty folder entry is created in member namespace, so the mount point appears in delta like any other folder.
+// JoinedRev is revision of that folder entry. Changes made before the member joined are sent to the member in full
+// when their cursor is older than JoinedRev.
+type ShareMember struct {
+	Id        int64  `db:"id"`
+	ShareId   int64  `db:"share_id"`
+	UserId    int64  `db:"user_id"`
+	MountPath string `db:"mount_path"`
+	Access    string `db:"access"`
+	JoinedRev int64  `db:"joined_rev"`
+	Created   int64  `db:"created"`
+}
+
+// Mount describes shared folder as seen by single member.
+type Mount struct {
+	ShareId   int64
+	UserId    int64
+	OwnerId   int64
+	SharePath string
+	MountPath string
+	Access    string
+	JoinedRev int64
+}
+
+// Location of path requested by user. Paths inside shared folders mounted by the user are located in namespace
+// of the share owner. Mount is nil if path is in namespace of the user.
+type Location struct {
+	Owner *User
+	Path  string
+	Mount *Mount
+}
+
+// Returns true if user might modify files at this location.
+func (l *Location) CanWrite() bool {
+	return l.Mount == nil || l.Mount.Access == ShareWrite
+}
+
+// Translates path in namespace of the owner to path seen by the user who requested this location.
+func (l *Location) Translate(ownerPath string) string {
+	if l.Mount == nil {
+		return ownerPath
+	}
+	return translatePath(ownerPath, l.Mount.SharePath, l.Mount.MountPath)
+}
+
+// Returns true if this location is the mount point itself.
+func (l *Location) IsMountPoint() bool {
+	return l.Mount != nil && l.Path == l.Mount.SharePath
+}
+
+// Replaces prefix from of given path with prefix to. Either of prefixes might be the root folder.
+func translatePath(p string, from string, to string) string {
+	rest := strings.TrimPrefix(p[len(from):], "/")
+	if rest == "" {
+		return to
+	}
+	return strings.TrimSuffix(to, "/") + "/" + rest
+}
+
+// Returns true if p is equal to folder or is inside it.
+func isUnder(p string, folder string) bool {
+	return p == folder || strings.HasPrefix(p, strings.TrimSuffix(folder, "/")+"/")
+}
+
+// Returns shared folders mounted by this user.
+func (user *User) GetMounts() ([]Mount, error) {
+	var mounts []Mount
+	if _, err := dbAccess.Select(&mounts, `select shares.id ShareId, share_members.user_id UserId, shares.owner_id OwnerId, shares.path SharePath,
+	                                     share_members.mount_path MountPath, share_members.access Access, share_members.joined_rev JoinedRev
+	                                     from share_members join shares on share_members.share_id = shares.id
+	                                     where share_members.user_id = ?`, user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return mounts, nil
+}
+
+// Returns location of given path. If path is inside shared folder mounted by this user,
+// location points to namespace of the share owner.
+func (user *User) Resolve(filepath string) (*Location, error) {
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	normalized := toolkit.NormalizePath(filepath)
+	for i, mount := range mounts {
+		if !isUnder(normalized, mount.MountPath) {
+			continue
+		}
+		owner := GetUserById(mount.OwnerId)
+		if owner == nil {
+			return nil, ErrEntityNotExists
+		}
+		// keep case of the remaining part, as it is used as file name
+		return &Location{Owner: owner, Path: translatePath(filepath, mount.MountPath, mount.SharePath), Mount: &mounts[i]}, nil
+	}
+	return &Location{Owner: user, Path: filepath}, nil
+}
+
+// Shares folder at given path. Returns ErrNestedShare if folder is inside or contains other shared folder
+// or mount point, as each file might belong to single share only.
+func (user *User) ShareFolder(folder string) (*Share, error) {
+	folder = toolkit.CleanPath(folder)
+	if folder == "/" {
+		return nil, ErrNotFolder
+	}
+	file, err := user.GetFileByPath(folder)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil || file.IsRemoved {
+		return nil, ErrEntityNotExists
+	}
+	if !file.IsDir {
+		return nil, ErrNotFolder
+	}
+	shares, err := user.GetShares()
+	if err != nil {
+		return nil, err
+	}
+	for _, share := range shares {
+		if isUnder(folder, share.Path) || isUnder(share.Path, folder) {
+			return nil, ErrNestedShare
+		}
+	}
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, mount := range mounts {
+		if isUnder(folder, mount.MountPath) || isUnder(mount.MountPath, folder) {
+			return nil, ErrNestedShare
+		}
+	}
+	share := Share{OwnerId: user.Id, Path: folder, Created: time.Now().Unix()}
+	if err = dbAccess.Insert(&share); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return &share, nil
+}
+
+// Returns folders shared by this user.
+func (user *User) GetShares() ([]Share, error) {
+	var shares []Share
+	if _, err := dbAccess.Select(&shares, "select * from shares where owner_id = ? order by path", user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return shares, nil
+}
+
+// Returns share with given id, or nil if it does not exist.
+func GetShare(id int64) *Share {
+	var share Share
+	if err := dbAccess.SelectOne(&share, "select * from shares where id = ?", id); err != nil || share.Id == 0 {
+		return nil
+	}
+	return &share
+}
+
+// Returns members of this share.
+func (share *Share) GetMembers() ([]ShareMember, error) {
+	var members []ShareMember
+	if _, err := dbAccess.Select(&members, "select * from share_members where share_id = ?", share.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return members, nil
+}
+
+// Adds user as member of this share, mounting shared folder in root of their namespace. If folder with the same
+// name exists there, number is appended to mount point name. Returns ErrEntityAlreadyExists if user is already a member.
+func (share *Share) AddMember(user *User, access string) (*ShareMember, error) {
+	owner := GetUserById(share.OwnerId)
+	if owner == nil {
+		return nil, ErrEntityNotExists
+	}
+	root, err := owner.GetFileByPath(share.Path)
+	if err != nil || root == nil {
+		return nil, ErrEntityNotExists
+	}
+	rootMeta, err := root.GetMetadata(nil)
+	if err != nil {
+		return nil, err
+	}
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	member, err := share.mount(tx, user, access, rootMeta.Name)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// Adds user as member of this share within given transaction, mounting shared folder in root of their namespace
+// under given name. Transaction is not rolled back on error, that is left to the caller.
+func (share *Share) mount(tx *gorp.Transaction, user *User, access string, name string) (*ShareMember, error) {
+	if access != ShareRead && access != ShareWrite {
+		return nil, errors.New("invalid access " + access)
+	}
+	if user.Id == share.OwnerId {
+		return nil, ErrEntityAlreadyExists
+	}
+	count, err := tx.SelectInt("select count(*) from share_members where share_id = ? and user_id = ?", share.Id, user.Id)
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if count > 0 {
+		return nil, ErrEntityAlreadyExists
+	}
+	mountPath := "/" + name
+	var existing *File
+	for i := 2; ; i++ {
+		existing, err = user.GetFileByPath(toolkit.NormalizePath(mountPath))
+		if err != nil {
+			return nil, err
+		}
+		if existing == nil || existing.IsRemoved {
+			break
+		}
+		mountPath = "/" + name + " (" + strconv.Itoa(i) + ")"
+	}
+	folder, err := user.storeFile(tx, existing, mountPath, true, "", 0, "")
+	if err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	member := ShareMember{ShareId: share.Id, UserId: user.Id, MountPath: folder.Path, Access: access,
+		JoinedRev: folder.CurrentRevisionId, Created: time.Now().Unix()}
+	if err = tx.Insert(&member); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return &member, nil
+}
+
+// Changes access level of member of this share.
+func (share *Share) SetAccess(userId int64, access string) error {
+	if access != ShareRead && access != ShareWrite {
+		return errors.New("invalid access " + access)
+	}
+	result, err := dbAccess.Exec("update share_members set access = ? where share_id = ? and user_id = ?", access, share.Id, userId)
+	if err != nil {
+		logger.Error(err)
+		return err
+	}
+	if count, _ := result.RowsAffected(); count < 1 {
+		return ErrEntityNotExists
+	}
+	return nil
+}
+
+// Removes member of this share. Mount point is removed from member namespace with new revision,
+// so the removal appears in member delta. Returns ErrEntityNotExists if user is not a member.
+func (share *Share) RemoveMember(userId int64) error {
+	var member ShareMember
+	if err := dbAccess.SelectOne(&member, "select * from share_members where share_id = ? and user_id = ?", share.Id, userId); err != nil || member.Id == 0 {
+		return ErrEntityNotExists
+	}
+	if user := GetUserById(userId); user != nil {
+		if err := user.removeMountPoint(member.MountPath); err != nil {
+			return err
+		}
+	}
+	if _, err := dbAccess.Delete(&member); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Stops sharing the folder. All members lose access to it.
+func (share *Share) Delete() error {
+	members, err := share.GetMembers()
+	if err != nil {
+		return err
+	}
+	for _, member := range members {
+		if err = share.RemoveMember(member.UserId); err != nil {
+			return err
+		}
+	}
+	if _, err = dbAccess.Delete(share); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Stops sharing all folders at or under given path, used when shared folder is removed by its owner.
+// Returns former members of the shares, whose mount points were removed, so their devices might be notified.
+// If error occurs, returns members unmounted so far.
+func (user *User) DeleteSharesUnder(folder string) ([]ShareMember, error) {
+	shares, err := user.GetShares()
+	if err != nil {
+		return nil, err
+	}
+	var unmounted []ShareMember
+	for _, share := range shares {
+		if isUnder(share.Path, toolkit.NormalizePath(folder)) {
+			members, err := share.GetMembers()
+			if err != nil {
+				return unmounted, err
+			}
+			if err = share.Delete(); err != nil {
+				return unmounted, err
+			}
+			unmounted = append(unmounted, members...)
+		}
+	}
+	return unmounted, nil
+}
+
+// Leaves all shared folders and stops sharing own folders. Used when user is deleted.
+func (user *User) LeaveAllShares() error {
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if err = (&Share{Id: mount.ShareId}).RemoveMember(user.Id); err != nil {
+			return err
+		}
+	}
+	_, err = user.DeleteSharesUnder("/")
+	return err
+}
+
+// Marks mount point folder as removed with new revision, so the removal is sent to member in delta.
+func (user *User) removeMountPoint(mountPath string) error {
+	file, err := user.GetFileByPath(mountPath)
+	if err != nil || file == nil {
+		return err
+	}
+	revision := Revision{IsDir: true, Modified: time.Now(), FileId: file.Id, Name: path.Base(mountPath), UserId: user.Id, IsRemoved: true}
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return err
+	}
+	if err = tx.Insert(&revision); err != nil {
+		tx.Rollback()
+		return err
+	}
+	file.CurrentRevisionId = revision.Id
+	file.IsRemoved = true
+	if _, err = tx.Update(file); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Returns members of shares containing given path of this user, with their mount points.
+// Used to notify members about changes in shared folders.
+func (user *User) GetMountsOf(filepath string) ([]Mount, error) {
+	shares, err := user.GetShares()
+	if err != nil {
+		return nil, err
+	}
+	var mounts []Mount
+	for _, share := range shares {
+		if !isUnder(toolkit.NormalizePath(filepath), share.Path) {
+			continue
+		}
+		members, err := share.GetMembers()
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			mounts = append(mounts, Mount{ShareId: share.Id, UserId: member.UserId, OwnerId: user.Id, SharePath: share.Path,
+				MountPath: member.MountPath, Access: member.Access, JoinedRev: member.JoinedRev})
+		}
+	}
+	return mounts, nil
+}
+
+// Returns changes in shared folder made after given cursor, with paths translated to mount point,
+// and the newest revision id in shared folder. If member joined after the cursor, all files of shared folder are returned.
+func (mount *Mount) getChangesFromCursor(cursor int64) ([]Metadata, int64, error) {
+	full := mount.JoinedRev > cursor
+	if full {
+		cursor = 0
+	}
+	var children []Metadata
+	prefix := strings.TrimSuffix(mount.SharePath, "/") + "/"
+	if _, err := dbAccess.Select(&children, `select revisions.hash Hash, files.path Path, revisions.name Name, files.is_dir IsDir, revisions.size Size, revisions.id Rev, revisions.modified Modified, files.is_removed IsRemoved
+	                                     from files join revisions on files.current_revision_id = revisions.id
+	                                     where files.user_id = ? and files.path like ? and revisions.id > ?`, mount.OwnerId, escapeLike(prefix)+"%", cursor); err != nil {
+		return nil, 0, err
+	}
+	var newCursor int64
+	var changes []Metadata
+	for _, child := range children {
+		if !strings.HasPrefix(child.Path, prefix) || (full && child.IsRemoved) {
+			continue
+		}
+		if child.Rev > newCursor {
+			newCursor = child.Rev
+		}
+		child.Path = translatePath(child.Path, mount.SharePath, mount.MountPath)
+		changes = append(changes, child)
+	}
+	return changes, newCursor, nil
+}
+
+// Escapes wildcard characters of SQL like pattern.
+func escapeLike(s string) string {
+	return strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_").Replace(s)
+}
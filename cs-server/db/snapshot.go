@@ -0,0 +1,40 @@
+package db
+
+import (
+	"time"
+)
+
+// Columns of metadata of revision which was current at given time. Used with snapshotJoin.
+const snapshotColumns = `revisions.hash Hash, revisions.name Name, files.path Path, revisions.is_dir IsDir, revisions.size Size,
+	                                     revisions.id Rev, revisions.modified Modified, revisions.is_removed IsRemoved`
+
+// Joins each file with its latest revision created at given time or before.
+const snapshotJoin = `files join revisions on revisions.id =
+	                                     (select max(r.id) from revisions r where r.file_id = files.id and r.created <= ?)`
+
+// Returns metadata of file at given path as it was at given time, or nil if file did not exist then.
+func (user *User) GetMetadataAsOf(filepath string, asOf time.Time) (*Metadata, error) {
+	var files []Metadata
+	if _, err := dbAccess.Select(&files, "select "+snapshotColumns+" from "+snapshotJoin+" where files.user_id = ? and files.path = ?",
+		asOf.UnixNano(), user.Id, filepath); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if len(files) == 0 || files[0].IsRemoved {
+		return nil, nil
+	}
+	return &files[0], nil
+}
+
+// Returns children of folder at given path as they were at given time.
+// Returns double nil if folder had no children then. Returns nil and error if error has occured.
+func (user *User) GetChildrenAsOf(folder string, asOf time.Time) ([]Metadata, error) {
+	var children []Metadata
+	if _, err := dbAccess.Select(&children, "select "+snapshotColumns+" from "+snapshotJoin+
+		" where files.user_id = ? and files.parent = ? and files.path != ? and revisions.is_removed = 0",
+		asOf.UnixNano(), user.Id, folder, folder); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return children, nil
+}
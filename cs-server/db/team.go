@@ -0,0 +1,282 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"errors"
+	"github.com/coopernurse/gorp"
+	"strings"
+	"time"
+)
+
+// Roles of team members. Owners manage members and the team, editors modify files and viewers only read them.
+const (
+	TeamOwner  = "owner"
+	TeamEditor = "editor"
+	TeamViewer = "viewer"
+)
+
+// Prefix of usernames of team accounts. Colon is not allowed in usernames of users, so team accounts
+// never collide with them.
+const teamUsernamePrefix = "team:"
+
+// Custom errors
+var (
+	ErrInvalidRole = errors.New("invalid team role")
+	ErrLastOwner   = errors.New("team must have at least one owner")
+)
+
+// Team struct keeps organization which owns its own file tree and quota. Files of the team are stored in namespace
+// of team account, user which can not log in, referenced by UserId. Whole tree of team account is shared with members
+// through ShareId, so it is mounted in namespace of each member like any other shared folder.
+type Team struct {
+	Id      int64  `db:"id"`
+	UserId  int64  `db:"user_id"`
+	ShareId int64  `db:"share_id"`
+	Name    string `db:"name"`
+	Created int64  `db:"created"`
+}
+
+// TeamMember struct keeps role of user in team.
+type TeamMember struct {
+	Id      int64  `db:"id"`
+	TeamId  int64  `db:"team_id"`
+	UserId  int64  `db:"user_id"`
+	Role    string `db:"role"`
+	Created int64  `db:"created"`
+}
+
+// Returns true if role is one of known team roles.
+func IsTeamRole(role string) bool {
+	return role == TeamOwner || role == TeamEditor || role == TeamViewer
+}
+
+// Returns access to team files granted by role.
+func roleAccess(role string) string {
+	if role == TeamViewer {
+		return ShareRead
+	}
+	return ShareWrite
+}
+
+// Returns true if this user is account holding files of a team.
+func (user *User) IsTeam() bool {
+	return strings.HasPrefix(user.Username, teamUsernamePrefix)
+}
+
+// Validates name of the team. Name is used as folder name, so it must not contain slashes.
+func ValidateTeamName(name string) PolicyErrors {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > 64 || strings.ContainsAny(name, "/\\") || name == "." || name == ".." {
+		return PolicyErrors{{Field: "name", Code: "team_name_invalid", Message: "Team name must have 1 to 64 characters and must not contain slashes"}}
+	}
+	return nil
+}
+
+// Creates team with given name. Team account holding the files is created, and this user becomes its owner.
+// Everything is created in one transaction, so no account or share is left behind if any step fails.
+func (user *User) CreateTeam(name string) (*Team, error) {
+	name = strings.TrimSpace(name)
+	password, err := HashPassword(toolkit.GetRandHex(32))
+	if err != nil {
+		return nil, err
+	}
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	team, err := user.createTeam(tx, name, password)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return team, nil
+}
+
+// Creates team account, its share, the team and membership of this user as owner within given transaction.
+// Transaction is not rolled back on error, that is left to the caller.
+func (user *User) createTeam(tx *gorp.Transaction, name string, password string) (*Team, error) {
+	account := User{Username: teamUsernamePrefix + toolkit.GetRandHex(16), Password: password}
+	if err := tx.Insert(&account); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	share := Share{OwnerId: account.Id, Path: "/", Created: time.Now().Unix()}
+	if err := tx.Insert(&share); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	team := Team{UserId: account.Id, ShareId: share.Id, Name: name, Created: time.Now().Unix()}
+	if err := tx.Insert(&team); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if _, err := team.addMember(tx, &share, user, TeamOwner); err != nil {
+		return nil, err
+	}
+	return &team, nil
+}
+
+// Returns team with given id, or nil if it does not exist.
+func GetTeam(id int64) *Team {
+	var team Team
+	if err := dbAccess.SelectOne(&team, "select * from teams where id = ?", id); err != nil || team.Id == 0 {
+		return nil
+	}
+	return &team
+}
+
+// Returns true if share with given id holds files of a team. Membership of such share is managed through the team.
+func IsTeamShare(shareId int64) bool {
+	count, err := dbAccess.SelectInt("select count(*) from teams where share_id = ?", shareId)
+	return err == nil && count > 0
+}
+
+// Returns teams this user is member of.
+func (user *User) GetTeams() ([]Team, error) {
+	var teams []Team
+	if _, err := dbAccess.Select(&teams, `select teams.* from teams join team_members on teams.id = team_members.team_id
+	                                     where team_members.user_id = ? order by teams.name`, user.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return teams, nil
+}
+
+// Returns account holding files of this team.
+func (team *Team) Account() *User {
+	return GetUserById(team.UserId)
+}
+
+// Returns members of this team.
+func (team *Team) GetMembers() ([]TeamMember, error) {
+	var members []TeamMember
+	if _, err := dbAccess.Select(&members, "select * from team_members where team_id = ? order by created", team.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return members, nil
+}
+
+// Returns role of user in this team, or empty string if user is not a member.
+func (team *Team) GetRole(userId int64) string {
+	role, err := dbAccess.SelectStr("select role from team_members where team_id = ? and user_id = ?", team.Id, userId)
+	if err != nil {
+		return ""
+	}
+	return role
+}
+
+// Adds user to this team with given role. Team files are mounted in root of namespace of the user under team name.
+// Returns ErrEntityAlreadyExists if user is already a member.
+func (team *Team) AddMember(user *User, role string) (*TeamMember, error) {
+	if !IsTeamRole(role) {
+		return nil, ErrInvalidRole
+	}
+	if team.GetRole(user.Id) != "" {
+		return nil, ErrEntityAlreadyExists
+	}
+	share := GetShare(team.ShareId)
+	if share == nil {
+		return nil, ErrEntityNotExists
+	}
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	member, err := team.addMember(tx, share, user, role)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return member, nil
+}
+
+// Adds user to this team with given role within given transaction, mounting given share of the team.
+// Transaction is not rolled back on error, that is left to the caller.
+func (team *Team) addMember(tx *gorp.Transaction, share *Share, user *User, role string) (*TeamMember, error) {
+	if _, err := share.mount(tx, user, roleAccess(role), team.Name); err != nil {
+		return nil, err
+	}
+	member := TeamMember{TeamId: team.Id, UserId: user.Id, Role: role, Created: time.Now().Unix()}
+	if err := tx.Insert(&member); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return &member, nil
+}
+
+// Returns true if user is the only owner of this team.
+func (team *Team) isLastOwner(userId int64) bool {
+	if team.GetRole(userId) != TeamOwner {
+		return false
+	}
+	count, err := dbAccess.SelectInt("select count(*) from team_members where team_id = ? and role = ?", team.Id, TeamOwner)
+	return err == nil && count < 2
+}
+
+// Changes role of team member. Returns ErrLastOwner if the only owner would lose the role.
+func (team *Team) SetRole(userId int64, role string) error {
+	if !IsTeamRole(role) {
+		return ErrInvalidRole
+	}
+	if team.GetRole(userId) == "" {
+		return ErrEntityNotExists
+	}
+	if role != TeamOwner && team.isLastOwner(userId) {
+		return ErrLastOwner
+	}
+	if err := (&Share{Id: team.ShareId}).SetAccess(userId, roleAccess(role)); err != nil {
+		return err
+	}
+	if _, err := dbAccess.Exec("update team_members set role = ? where team_id = ? and user_id = ?", role, team.Id, userId); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Removes member from this team, team files are removed from their namespace.
+// Returns ErrLastOwner if the only owner would be removed.
+func (team *Team) RemoveMember(userId int64) error {
+	if team.GetRole(userId) == "" {
+		return ErrEntityNotExists
+	}
+	if team.isLastOwner(userId) {
+		return ErrLastOwner
+	}
+	if err := (&Share{Id: team.ShareId}).RemoveMember(userId); err != nil {
+		return err
+	}
+	if _, err := dbAccess.Exec("delete from team_members where team_id = ? and user_id = ?", team.Id, userId); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
+// Deletes the team. Members lose access immediately, files of the team are removed in background by purge job
+// of team account, which is returned.
+func (team *Team) Delete(requestedBy string) (*PurgeJob, error) {
+	var job *PurgeJob
+	if account := team.Account(); account != nil {
+		var err error
+		if job, err = CreatePurgeJob(account, requestedBy); err != nil && err != ErrEntityAlreadyExists {
+			return nil, err
+		}
+	}
+	if _, err := dbAccess.Exec("delete from team_members where team_id = ?", team.Id); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	if _, err := dbAccess.Delete(team); err != nil {
+		logger.Error(err)
+		return nil, err
+	}
+	return job, nil
+}
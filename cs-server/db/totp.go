@@ -0,0 +1,142 @@
+package db
+
+import (
+	"cloudsyncer/toolkit"
+	"crypto/subtle"
+	"strings"
+	"time"
+)
+
+// Number of recovery codes generated when two-factor authentication is enabled.
+const recoveryCodesCount = 10
+
+// RecoveryCode struct keeps single-use code which might be used instead of one-time password,
+// when user lost access to authenticator application. Only hash of the code is stored.
+type RecoveryCode struct {
+	Id     int64  `db:"id"`
+	UserId int64  `db:"user_id"`
+	Code   string `db:"code"`
+	Used   bool   `db:"used"`
+}
+
+// Generates and stores new TOTP secret for this user. Two-factor authentication is not enabled until
+// the secret is confirmed with VerifyTotp. Returns the secret.
+func (user *User) EnrollTotp() (string, error) {
+	if user.TotpEnabled {
+		return "", ErrEntityAlreadyExists
+	}
+	user.TotpSecret = toolkit.NewTotpSecret()
+	if _, err := dbAccess.Update(user); err != nil {
+		logger.Error(err)
+		return "", err
+	}
+	return user.TotpSecret, nil
+}
+
+// Returns true if given one-time password is valid for this user. Codes from adjacent time steps are accepted
+// to tolerate clock drift, but each code might be used only once.
+func (user *User) checkTotp(code string) bool {
+	if user.TotpSecret == "" {
+		return false
+	}
+	now := toolkit.TotpStep(time.Now())
+	for step := now - 1; step <= now+1; step++ {
+		if step <= user.TotpLastStep {
+			continue
+		}
+		if subtle.ConstantTimeCompare([]byte(toolkit.TotpCode(user.TotpSecret, step)), []byte(code)) == 1 {
+			user.TotpLastStep = step
+			if _, err := dbAccess.Update(user); err != nil {
+				logger.Error(err)
+				return false
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// Confirms enrollment with one-time password and enables two-factor authentication.
+// Returns recovery codes, which are shown to user only once. Returns nil and error if code is invalid or error has occured.
+func (user *User) VerifyTotp(code string) ([]string, error) {
+	if user.TotpEnabled || user.TotpSecret == "" {
+		return nil, ErrEntityNotExists
+	}
+	if !user.checkTotp(code) {
+		return nil, ErrInvalidCode
+	}
+	tx, err := dbAccess.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if _, err = tx.Exec("delete from recovery_codes where user_id = ?", user.Id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	codes := make([]string, recoveryCodesCount)
+	for i := range codes {
+		codes[i] = toolkit.GetRandHex(5) + "-" + toolkit.GetRandHex(5)
+		if err = tx.Insert(&RecoveryCode{UserId: user.Id, Code: hashToken(codes[i])}); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	user.TotpEnabled = true
+	if _, err = tx.Update(user); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Disables two-factor authentication and removes recovery codes. Requires valid one-time password or recovery code.
+func (user *User) DisableTotp(code string) error {
+	if !user.TotpEnabled {
+		return ErrEntityNotExists
+	}
+	if !user.CheckSecondFactor(code) {
+		return ErrInvalidCode
+	}
+	return user.ResetTotp()
+}
+
+// Disables two-factor authentication without checking the second factor. Used by administrators.
+func (user *User) ResetTotp() error {
+	user.TotpEnabled = false
+	user.TotpSecret = ""
+	if _, err := dbAccess.Update(user); err != nil {
+		logger.Error(err)
+		return err
+	}
+	_, err := dbAccess.Exec("delete from recovery_codes where user_id = ?", user.Id)
+	return err
+}
+
+// Returns true if given code is valid one-time password or unused recovery code. Recovery code is marked as used.
+func (user *User) CheckSecondFactor(code string) bool {
+	code = strings.TrimSpace(code)
+	if user.checkTotp(code) {
+		return true
+	}
+	var recoveryCodes []RecoveryCode
+	if _, err := dbAccess.Select(&recoveryCodes, "select * from recovery_codes where user_id = ? and used = 0", user.Id); err != nil {
+		logger.Error(err)
+		return false
+	}
+	hash := []byte(hashToken(strings.ToLower(code)))
+	for index := range recoveryCodes {
+		if subtle.ConstantTimeCompare([]byte(recoveryCodes[index].Code), hash) == 1 {
+			recoveryCodes[index].Used = true
+			if _, err := dbAccess.Update(&recoveryCodes[index]); err != nil {
+				logger.Error(err)
+				return false
+			}
+			logger.Infof("Recovery code used by user %s", user.Username)
+			return true
+		}
+	}
+	return false
+}
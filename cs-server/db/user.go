@@ -1,28 +1,107 @@
 package db
 
 import (
+	"cloudsyncer/cs-server/config"
 	"cloudsyncer/toolkit"
 	"errors"
 	"path"
+	"strings"
 	"time"
+
+	"github.com/coopernurse/gorp"
 )
 
 // Struct describing single user.
 type User struct {
 	Id       int64  `db:"id"`
 	Username string `db:"username"`
-	Salt     string `db:"salt"`
+	Salt     string `db:"salt"` // only used by legacy sha1 hashes
 	Password string `db:"password"`
+	// Two-factor authentication. Secret is set on enrollment, but is required on login only after it is verified and enabled.
+	TotpSecret   string `db:"totp_secret"`
+	TotpEnabled  bool   `db:"totp_enabled"`
+	TotpLastStep int64  `db:"totp_last_step"`
+	// Set by administrators. Disabled users can not log in nor use existing sessions.
+	// Quota is in bytes, 0 means DEFAULT_QUOTA setting, negative means unlimited.
+	Admin    bool  `db:"is_admin"`
+	Disabled bool  `db:"disabled"`
+	Quota    int64 `db:"quota"`
 }
 
 // Returns true if provided password matches record in database.
 func (user *User) CheckPassword(password string) bool {
-	if toolkit.GetSha1([]byte(user.Salt+password)) == user.Password {
-		return true
+	return verifyPassword(user.Password, user.Salt, password)
+}
+
+// Returns true if password of this user is stored using legacy algorithm or outdated cost parameters.
+func (user *User) NeedsRehash() bool {
+	return needsRehash(user.Password)
+}
+
+// Returns true if user is an administrator.
+func (user *User) IsAdmin() bool {
+	return user.Admin
+}
+
+// Returns true if given username is listed in ADMIN_USERS setting. Listed users which exist when the server starts
+// are made administrators, so there is at least one administrator who might grant the role to others.
+// Listed names which do not exist are reserved, so nobody might register them to become administrator.
+func IsConfiguredAdmin(username string) bool {
+	for _, name := range adminUsernames() {
+		if name == username {
+			return true
+		}
 	}
 	return false
 }
 
+// Grants administrator role to existing users listed in ADMIN_USERS setting.
+func grantConfiguredAdmins() error {
+	names := adminUsernames()
+	if len(names) == 0 {
+		return nil
+	}
+	args := []interface{}{}
+	for _, name := range names {
+		args = append(args, name)
+	}
+	result, err := dbAccess.Exec("update users set is_admin = 1 where is_admin = 0 and username in (?"+strings.Repeat(", ?", len(names)-1)+")", args...)
+	if err != nil {
+		return err
+	}
+	if granted, _ := result.RowsAffected(); granted > 0 {
+		logger.Infof("Granted administrator role to %d users listed in ADMIN_USERS", granted)
+	}
+	return nil
+}
+
+// Returns usernames listed in ADMIN_USERS setting.
+func adminUsernames() []string {
+	var names []string
+	for _, name := range strings.Split(config.ADMIN_USERS, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Hashes given password using current algorithm and stores it in database.
+// Returns error if error has occured.
+func (user *User) SetPassword(password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	user.Password = hash
+	user.Salt = ""
+	if _, err = dbAccess.Update(user); err != nil {
+		logger.Error(err)
+		return err
+	}
+	return nil
+}
+
 // If User with provided username exists in database, returns pointer to user struct. Returns nil otherwise.
 func GetUser(username string) *User {
 	var user User
@@ -43,9 +122,12 @@ func CreateUser(username string, password string) (*User, error) {
 	if user := GetUser(username); user != nil {
 		return nil, ErrEntityAlreadyExists
 	}
-	var salt = toolkit.GetRandHex(15)
-	var user = User{Username: username, Password: toolkit.GetSha1([]byte(salt + password)), Salt: salt}
-	var err = dbAccess.Insert(&user)
+	hash, err := HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	var user = User{Username: username, Password: hash}
+	err = dbAccess.Insert(&user)
 	if err != nil {
 		logger.Error(err)
 		return nil, err
@@ -96,6 +178,7 @@ func (user *User) GetCurrentState() ([]map[string]interface{}, error) {
 //Returns list of changes made since given cursor. Returns empty map if no new changes were made.
 //Returns slice of maps, where map has path as key and Metadata as value if file exists, or nil as value if file has been removed since that cursor.
 //Returns current cursor if changes were made.
+//Changes in shared folders mounted by the user are included, with paths under their mount points.
 //Returns nil, 0 and error if error has occured.
 func (user *User) GetChangesFromCursor(cursor int64) ([]map[string]interface{}, int64, error) {
 	var children []Metadata
@@ -117,6 +200,20 @@ func (user *User) GetChangesFromCursor(cursor int64) ([]map[string]interface{},
 																			 where files.user_id = ? and revisions.id > ?`, user.Id, cursor); err != nil {
 		return nil, 0, err
 	}
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return nil, 0, err
+	}
+	for _, mount := range mounts {
+		changes, mountCursor, err := mount.getChangesFromCursor(cursor)
+		if err != nil {
+			return nil, 0, err
+		}
+		if mountCursor > newCursor {
+			newCursor = mountCursor
+		}
+		children = append(children, changes...)
+	}
 	resp := make([]map[string]interface{}, 0)
 	for _, child := range children {
 		if child.IsRemoved {
@@ -184,44 +281,7 @@ func (user *User) CreateFile(filepath string, isDir bool, overwrite bool, uuid s
 	if err != nil {
 		return nil, err
 	}
-	if file == nil {
-		file = new(File)
-		file.Path = toolkit.NormalizePath(filepath)
-		file.Parent = toolkit.Dir(toolkit.NormalizePath(filepath))
-		file.IsDir = isDir
-		file.IsRemoved = false
-		file.UserId = user.Id
-		err = tx.Insert(file)
-		if err != nil {
-			tx.Rollback()
-			return nil, err
-		}
-	} else {
-		file.IsDir = isDir
-		file.IsRemoved = false
-		_, err = tx.Update(file)
-		if err != nil {
-			tx.Rollback()
-			return nil, err
-		}
-	}
-	var revision *Revision = new(Revision)
-	revision.Size = size
-	revision.IsDir = isDir
-	revision.Modified = time.Now()
-	revision.Uuid = uuid
-	revision.FileId = file.Id
-	revision.Name = path.Base(filepath)
-	revision.UserId = user.Id
-	revision.Hash = hash
-	err = tx.Insert(revision)
-	if err != nil {
-		tx.Rollback()
-		return nil, err
-	}
-	file.CurrentRevisionId = revision.Id
-	_, err = tx.Update(file)
-	if err != nil {
+	if file, err = user.storeFile(tx, file, filepath, isDir, uuid, size, hash); err != nil {
 		tx.Rollback()
 		return nil, err
 	}
@@ -254,6 +314,45 @@ func (user *User) Remove(filepath string) (file *File, err error) {
 
 }
 
+// Stores new revision of file at given path within given transaction, inserting the file if it is nil.
+// Transaction is not rolled back on error, that is left to the caller.
+func (user *User) storeFile(tx *gorp.Transaction, file *File, filepath string, isDir bool, uuid string, size int64, hash string) (*File, error) {
+	if file == nil {
+		file = new(File)
+		file.Path = toolkit.NormalizePath(filepath)
+		file.Parent = toolkit.Dir(toolkit.NormalizePath(filepath))
+		file.IsDir = isDir
+		file.IsRemoved = false
+		file.UserId = user.Id
+		if err := tx.Insert(file); err != nil {
+			return nil, err
+		}
+	} else {
+		file.IsDir = isDir
+		file.IsRemoved = false
+		if _, err := tx.Update(file); err != nil {
+			return nil, err
+		}
+	}
+	var revision *Revision = new(Revision)
+	revision.Size = size
+	revision.IsDir = isDir
+	revision.Modified = time.Now()
+	revision.Uuid = uuid
+	revision.FileId = file.Id
+	revision.Name = path.Base(filepath)
+	revision.UserId = user.Id
+	revision.Hash = hash
+	if err := tx.Insert(revision); err != nil {
+		return nil, err
+	}
+	file.CurrentRevisionId = revision.Id
+	if _, err := tx.Update(file); err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
 // Creates new revision for given path, with given parameters. Uses CreateFile() for that task.
 // If successful, returns pointer to Revision struct. Returns nil and error if error has occured.
 func (user *User) CreateRevision(filepath string, uuidVal string, size int64, hash string) (rev *Revision, err error) {
@@ -0,0 +1,327 @@
+// This package is responsible for communication with external OpenID Connect provider.
+// It supports authorization code flow used by browsers and device authorization flow used by headless clients.
+// ID tokens are verified with keys published by the provider, only RS256 signatures are supported.
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Minimal time between fetches of provider keys. Tokens signed with unknown key id do not cause
+// more frequent requests to the provider.
+const keysRefetchInterval = time.Minute
+
+// Custom errors
+var (
+	ErrInvalidToken         = errors.New("invalid id token")
+	ErrAuthorizationPending = errors.New("authorization pending")
+	ErrSlowDown             = errors.New("slow down")
+	ErrNotSupported         = errors.New("provider does not support device authorization")
+)
+
+// Provider keeps configuration of OpenID Connect provider, read from its discovery document.
+type Provider struct {
+	Issuer                      string `json:"issuer"`
+	AuthorizationEndpoint       string `json:"authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	JwksURI                     string `json:"jwks_uri"`
+	clientId                    string
+	clientSecret                string
+	redirectURL                 string
+	client                      *http.Client
+	keys                        map[string]*rsa.PublicKey
+	keysFetched                 time.Time
+	keysMutex                   sync.Mutex
+}
+
+// Claims of verified ID token used to identify the user.
+type Claims struct {
+	Issuer        string      `json:"iss"`
+	Subject       string      `json:"sub"`
+	Email         string      `json:"email"`
+	EmailVerified bool        `json:"email_verified"`
+	Username      string      `json:"preferred_username"`
+	Nonce         string      `json:"nonce"`
+	Expires       int64       `json:"exp"`
+	Audience      interface{} `json:"aud"`
+}
+
+// Response of device authorization endpoint. UserCode should be entered by the user at VerificationURI.
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int64  `json:"expires_in"`
+	Interval                int64  `json:"interval"`
+}
+
+// Response of token endpoint.
+type tokenResponse struct {
+	IdToken string `json:"id_token"`
+	Error   string `json:"error"`
+}
+
+// Reads discovery document of given issuer and returns configured Provider.
+func NewProvider(issuer string, clientId string, clientSecret string, redirectURL string) (*Provider, error) {
+	p := Provider{clientId: clientId, clientSecret: clientSecret, redirectURL: redirectURL}
+	p.client = &http.Client{Timeout: 30 * time.Second}
+	resp, err := p.client.Get(strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New("unable to read discovery document: " + resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&p); err != nil {
+		return nil, err
+	}
+	if p.Issuer != issuer {
+		return nil, errors.New("issuer mismatch in discovery document: " + p.Issuer)
+	}
+	return &p, nil
+}
+
+// Returns URL to which browser should be redirected to log in. state and nonce should be random values
+// checked on callback, codeChallenge is S256 PKCE challenge of the verifier passed later to Exchange.
+func (p *Provider) AuthCodeURL(state string, nonce string, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", p.clientId)
+	params.Set("redirect_uri", p.redirectURL)
+	params.Set("scope", "openid email profile")
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+	return p.AuthorizationEndpoint + "?" + params.Encode()
+}
+
+// Returns S256 PKCE challenge for given verifier.
+func CodeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Exchanges authorization code for ID token and verifies it. Returns claims of the token.
+func (p *Provider) Exchange(code string, codeVerifier string, nonce string) (*Claims, error) {
+	data := url.Values{}
+	data.Set("grant_type", "authorization_code")
+	data.Set("code", code)
+	data.Set("redirect_uri", p.redirectURL)
+	data.Set("code_verifier", codeVerifier)
+	token, err := p.requestToken(data)
+	if err != nil {
+		return nil, err
+	}
+	claims, err := p.Verify(token.IdToken)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Nonce != nonce {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+// Starts device authorization flow.
+func (p *Provider) StartDeviceAuthorization() (*DeviceAuthorization, error) {
+	if p.DeviceAuthorizationEndpoint == "" {
+		return nil, ErrNotSupported
+	}
+	data := url.Values{}
+	data.Set("client_id", p.clientId)
+	data.Set("scope", "openid email profile")
+	req, err := http.NewRequest("POST", p.DeviceAuthorizationEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientId), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New("device authorization failed: " + resp.Status)
+	}
+	authorization := new(DeviceAuthorization)
+	if err = json.NewDecoder(resp.Body).Decode(authorization); err != nil {
+		return nil, err
+	}
+	if authorization.Interval == 0 {
+		authorization.Interval = 5
+	}
+	return authorization, nil
+}
+
+// Checks whether user completed device authorization. Returns ErrAuthorizationPending if user has not finished yet,
+// ErrSlowDown if polling is too frequent. Returns claims of verified ID token when authorization is completed.
+func (p *Provider) PollDeviceToken(deviceCode string) (*Claims, error) {
+	data := url.Values{}
+	data.Set("grant_type", "urn:ietf:params:oauth:grant-type:device_code")
+	data.Set("device_code", deviceCode)
+	token, err := p.requestToken(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.Verify(token.IdToken)
+}
+
+// Sends request to token endpoint, authenticating with client credentials.
+func (p *Provider) requestToken(data url.Values) (*tokenResponse, error) {
+	data.Set("client_id", p.clientId)
+	req, err := http.NewRequest("POST", p.TokenEndpoint, strings.NewReader(data.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.SetBasicAuth(url.QueryEscape(p.clientId), url.QueryEscape(p.clientSecret))
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	token := new(tokenResponse)
+	rawJson, _ := ioutil.ReadAll(resp.Body)
+	if err = json.Unmarshal(rawJson, token); err != nil {
+		return nil, err
+	}
+	switch token.Error {
+	case "":
+	case "authorization_pending":
+		return nil, ErrAuthorizationPending
+	case "slow_down":
+		return nil, ErrSlowDown
+	default:
+		return nil, errors.New("token request failed: " + token.Error)
+	}
+	if resp.StatusCode != 200 || token.IdToken == "" {
+		return nil, errors.New("token request failed: " + resp.Status)
+	}
+	return token, nil
+}
+
+// Verifies signature, issuer, audience and expiry of ID token. Returns its claims.
+func (p *Provider) Verify(idToken string) (*Claims, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil || header.Alg != "RS256" {
+		return nil, ErrInvalidToken
+	}
+	key, err := p.getKey(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	hashed := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err = rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], signature); err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := new(Claims)
+	if err = decodeSegment(parts[1], claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != p.Issuer || !claims.hasAudience(p.clientId) || claims.Expires < time.Now().Unix() || claims.Subject == "" {
+		return nil, ErrInvalidToken
+	}
+	return claims, nil
+}
+
+func (c *Claims) hasAudience(clientId string) bool {
+	switch aud := c.Audience.(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, value := range aud {
+			if value == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// Returns signing key with given id. Keys are fetched again when unknown key id appears, to support key rotation,
+// but not more often than keysRefetchInterval.
+func (p *Provider) getKey(kid string) (*rsa.PublicKey, error) {
+	p.keysMutex.Lock()
+	defer p.keysMutex.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keysRefetchInterval {
+		return nil, ErrInvalidToken
+	}
+	p.keysFetched = time.Now()
+	resp, err := p.client.Get(p.JwksURI)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return nil, errors.New("unable to read provider keys: " + resp.Status)
+	}
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err = json.NewDecoder(resp.Body).Decode(&jwks); err != nil {
+		return nil, err
+	}
+	p.keys = make(map[string]*rsa.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			continue
+		}
+		p.keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+	}
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, ErrInvalidToken
+}
@@ -0,0 +1,235 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// mockIssuer is OpenID Connect provider serving discovery document, keys and token endpoint.
+type mockIssuer struct {
+	server     *httptest.Server
+	key        *rsa.PrivateKey
+	kid        string
+	keyFetches int
+	token      map[string]interface{}
+	form       map[string]string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockIssuer{key: key, kid: "key-1"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                        m.server.URL,
+			"authorization_endpoint":        m.server.URL + "/authorize",
+			"token_endpoint":                m.server.URL + "/token",
+			"device_authorization_endpoint": m.server.URL + "/device",
+			"jwks_uri":                      m.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		m.keyFetches++
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": m.kid,
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		m.form = map[string]string{}
+		for name := range r.PostForm {
+			m.form[name] = r.PostForm.Get(name)
+		}
+		if m.token["error"] != nil {
+			w.WriteHeader(400)
+		}
+		json.NewEncoder(w).Encode(m.token)
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"device_code": "device", "user_code": "ABCD-EFGH", "expires_in": 600})
+	})
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+	return m
+}
+
+// Returns ID token with given header and claims, signed with key of the issuer.
+func (m *mockIssuer) sign(t *testing.T, header map[string]interface{}, claims map[string]interface{}) string {
+	rawHeader, err := json.Marshal(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawClaims, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(rawHeader) + "." + base64.RawURLEncoding.EncodeToString(rawClaims)
+	hashed := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, m.key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (m *mockIssuer) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":                m.server.URL,
+		"sub":                "subject-1",
+		"aud":                "client",
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"email":              "user@example.com",
+		"email_verified":     true,
+		"preferred_username": "user",
+		"nonce":              "nonce",
+	}
+}
+
+func (m *mockIssuer) provider(t *testing.T) *Provider {
+	p, err := NewProvider(m.server.URL, "client", "secret", "https://cloudsyncer.example.com/oidc/callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestNewProviderIssuerMismatch(t *testing.T) {
+	m := newMockIssuer(t)
+	if _, err := NewProvider(m.server.URL+"/other", "client", "secret", ""); err == nil {
+		t.Error("NewProvider accepted discovery document of other issuer")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	header := map[string]interface{}{"alg": "RS256", "kid": m.kid}
+	with := func(name string, value interface{}) map[string]interface{} {
+		claims := m.claims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+	valid := m.sign(t, header, m.claims())
+	tests := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{"valid", valid, true},
+		{"audience list", m.sign(t, header, with("aud", []string{"other", "client"})), true},
+		{"other audience", m.sign(t, header, with("aud", "other")), false},
+		{"other audience list", m.sign(t, header, with("aud", []string{"other"})), false},
+		{"other issuer", m.sign(t, header, with("iss", "https://evil.example.com")), false},
+		{"expired", m.sign(t, header, with("exp", time.Now().Add(-time.Minute).Unix())), false},
+		{"missing subject", m.sign(t, header, with("sub", nil)), false},
+		{"other algorithm", m.sign(t, map[string]interface{}{"alg": "none", "kid": m.kid}, m.claims()), false},
+		{"tampered claims", valid[:len(valid)-4] + "AAAA", false},
+		{"not a token", "abc.def", false},
+	}
+	for _, test := range tests {
+		claims, err := p.Verify(test.token)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: Verify returned error %v", test.name, err)
+			continue
+		}
+		if test.ok && (claims.Subject != "subject-1" || claims.Issuer != m.server.URL || claims.Username != "user") {
+			t.Errorf("%s: Verify returned claims %+v", test.name, claims)
+		}
+	}
+}
+
+func TestKeysRefetchInterval(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	unknown := m.sign(t, map[string]interface{}{"alg": "RS256", "kid": "unknown"}, m.claims())
+	for i := 0; i < 5; i++ {
+		if _, err := p.Verify(unknown); err != ErrInvalidToken {
+			t.Fatalf("Verify with unknown key id returned %v, expected %v", err, ErrInvalidToken)
+		}
+	}
+	if m.keyFetches != 1 {
+		t.Errorf("keys fetched %d times, expected once", m.keyFetches)
+	}
+	// rotated key is picked up after the interval
+	m.kid = "unknown"
+	p.keysFetched = time.Now().Add(-keysRefetchInterval)
+	if _, err := p.Verify(unknown); err != nil {
+		t.Errorf("Verify after key rotation returned %v", err)
+	}
+	if m.keyFetches != 2 {
+		t.Errorf("keys fetched %d times, expected twice", m.keyFetches)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	m.token = map[string]interface{}{"id_token": m.sign(t, map[string]interface{}{"alg": "RS256", "kid": m.kid}, m.claims())}
+	if _, err := p.Exchange("code", "verifier", "other nonce"); err != ErrInvalidToken {
+		t.Errorf("Exchange with other nonce returned %v, expected %v", err, ErrInvalidToken)
+	}
+	claims, err := p.Exchange("code", "verifier", "nonce")
+	if err != nil {
+		t.Fatalf("Exchange returned %v", err)
+	}
+	if claims.Subject != "subject-1" {
+		t.Errorf("Exchange returned subject %s", claims.Subject)
+	}
+	for name, expected := range map[string]string{"grant_type": "authorization_code", "code": "code", "code_verifier": "verifier", "client_id": "client"} {
+		if m.form[name] != expected {
+			t.Errorf("token request %s = %q, expected %q", name, m.form[name], expected)
+		}
+	}
+}
+
+func TestPollDeviceToken(t *testing.T) {
+	m := newMockIssuer(t)
+	p := m.provider(t)
+	authorization, err := p.StartDeviceAuthorization()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authorization.DeviceCode != "device" || authorization.Interval != 5 {
+		t.Errorf("StartDeviceAuthorization returned %+v", authorization)
+	}
+	tests := []struct {
+		response map[string]interface{}
+		expected error
+	}{
+		{map[string]interface{}{"error": "authorization_pending"}, ErrAuthorizationPending},
+		{map[string]interface{}{"error": "slow_down"}, ErrSlowDown},
+		{map[string]interface{}{"id_token": m.sign(t, map[string]interface{}{"alg": "RS256", "kid": m.kid}, m.claims())}, nil},
+	}
+	for _, test := range tests {
+		m.token = test.response
+		if _, err := p.PollDeviceToken("device"); err != test.expected {
+			t.Errorf("PollDeviceToken with response %v returned %v, expected %v", test.response, err, test.expected)
+		}
+	}
+	m.token = map[string]interface{}{"error": "access_denied"}
+	if _, err := p.PollDeviceToken("device"); err == nil {
+		t.Error("PollDeviceToken accepted denied authorization")
+	}
+	if m.form["device_code"] != "device" {
+		t.Errorf("token request device_code = %q", m.form["device_code"])
+	}
+}
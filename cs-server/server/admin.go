@@ -0,0 +1,343 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// Time when server was started, reported by admin/stats.
+var startTime = time.Now()
+
+// Returns user given by id form parameter of administrator request. Writes error to the response and returns nil
+// if parameter is incorrect or user does not exist.
+func adminTargetUser(w http.ResponseWriter, r *http.Request) *db.User {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return nil
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return nil
+	}
+	user := db.GetUserById(id)
+	if user == nil {
+		handleErr(w, 404, nil, "User "+r.FormValue("id")+" does not exist")
+		return nil
+	}
+	return user
+}
+
+// Returns true if administrator is trying to change own account in a way which would lock them out.
+// Writes error to the response in that case.
+func isSelf(w http.ResponseWriter, r *http.Request, target *db.User) bool {
+	admin := context.Get(r, "user").(*db.User)
+	if admin.Id == target.Id {
+		handleErr(w, 409, nil, "Administrator "+admin.Username+" can not perform this action on own account")
+		return true
+	}
+	return false
+}
+
+func adminUserMap(user *db.User, usage *db.Usage) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           user.Id,
+		"username":     user.Username,
+		"admin":        user.IsAdmin(),
+		"team":         user.IsTeam(),
+		"disabled":     user.Disabled,
+		"quota":        user.GetQuota(),
+		"totp_enabled": user.TotpEnabled,
+		"sso":          user.HasOidcIdentity(),
+		"usage":        usage,
+	}
+}
+
+// Handler function for admin/users action. Lists all users with their storage usage.
+//
+// HTTP codes returned:
+//	403 - current user is not an administrator
+//	50x - server error processing request
+//	200 - Request succesful
+func adminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := db.GetUsers()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get users")
+		return
+	}
+	usersToReturn := make([]map[string]interface{}, len(users))
+	for index, user := range users {
+		usage, err := user.GetUsage()
+		if err != nil {
+			handleErr(w, 500, err, "Unable to get usage of user "+user.Username)
+			return
+		}
+		usersToReturn[index] = adminUserMap(&user, usage)
+	}
+	usersJSON, err := json.Marshal(usersToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(usersJSON))
+}
+
+// Handler function for creating user by administrator. Users might be created regardless of registration mode,
+// but username and password must satisfy registration policy.
+// Requires the following form parameters:
+//	username - username
+//	password - password
+//	admin (optional) - "true" if user should be an administrator
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, username or password rejected by policy, etc.)
+//	403 - current user is not an administrator
+//	409 - user already exists
+//	413 - password too long (possible DoS attempt)
+//	50x - server error processing request
+//	200 - User created
+func adminCreateUser(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	username := r.FormValue("username")
+	password := r.FormValue("password")
+	if len(password) > 255 {
+		handleErr(w, 413, nil, "Password too long (possible DoS)")
+		return
+	}
+	if errs := append(db.ValidateUsername(username), db.ValidatePassword(username, password)...); errs != nil {
+		handlePolicyErr(w, 400, errs)
+		return
+	}
+	if db.GetUser(username) != nil {
+		handlePolicyErr(w, 409, db.PolicyErrors{{Field: "username", Code: "username_taken", Message: "Username is already taken"}})
+		return
+	}
+	user, err := db.CreateUser(username, password)
+	if err != nil {
+		handleErr(w, 500, err, "Error during user creation")
+		return
+	}
+	if r.FormValue("admin") == "true" {
+		user.Admin = true
+		if err = user.Save(); err != nil {
+			handleErr(w, 500, err, "Unable to grant administrator role to user "+username)
+			return
+		}
+	}
+	logger.Info("User " + username + " created by administrator " + context.Get(r, "user").(*db.User).Username)
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "created user " + username + ", administrator: " + strconv.FormatBool(user.Admin)})
+	userJSON, err := json.Marshal(adminUserMap(user, &db.Usage{}))
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(userJSON))
+}
+
+// Handler function for admin/users/disable and admin/users/enable actions.
+// Disabled user can not log in and all requests made with existing sessions and API tokens are rejected.
+// Requires the following form parameters:
+//	id - id of the user
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect id)
+//	403 - current user is not an administrator
+//	404 - user does not exist
+//	409 - administrator tried to disable own account, or to enable user whose account is being deleted
+//	50x - server error processing request
+//	200 - User disabled or enabled
+func adminSetDisabled(disabled bool) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user := adminTargetUser(w, r)
+		if user == nil || (disabled && isSelf(w, r, user)) {
+			return
+		}
+		if !disabled {
+			purging, err := user.IsBeingPurged()
+			if err != nil {
+				handleErr(w, 500, err, "Unable to get purge jobs of user "+user.Username)
+				return
+			}
+			if purging {
+				handleErr(w, 409, nil, "User "+user.Username+" is being deleted")
+				return
+			}
+		}
+		user.Disabled = disabled
+		if err := user.Save(); err != nil {
+			handleErr(w, 500, err, "Unable to change state of user "+user.Username)
+			return
+		}
+		logger.Infof("User %s disabled: %t by administrator %s", user.Username, disabled, context.Get(r, "user").(*db.User).Username)
+		action := "enabled user "
+		if disabled {
+			action = "disabled user "
+		}
+		audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: action + user.Username})
+	}
+}
+
+// Handler function for admin/users/password action. Sets new password and logs out all devices of the user.
+// Requires the following form parameters:
+//	id - id of the user
+//	password - new password, must satisfy registration policy
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, password rejected by policy, etc.)
+//	403 - current user is not an administrator
+//	404 - user does not exist
+//	413 - password too long (possible DoS attempt)
+//	50x - server error processing request
+//	200 - Password changed
+func adminResetPassword(w http.ResponseWriter, r *http.Request) {
+	user := adminTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	password := r.FormValue("password")
+	if len(password) > 255 {
+		handleErr(w, 413, nil, "Password too long (possible DoS)")
+		return
+	}
+	if errs := db.ValidatePassword(user.Username, password); errs != nil {
+		handlePolicyErr(w, 400, errs)
+		return
+	}
+	if err := user.SetPassword(password); err != nil {
+		handleErr(w, 500, err, "Unable to set password of user "+user.Username)
+		return
+	}
+	if _, err := user.RevokeAllSessions(); err != nil {
+		handleErr(w, 500, err, "Unable to revoke sessions of user "+user.Username)
+		return
+	}
+	logger.Info("Password of user " + user.Username + " reset by administrator " + context.Get(r, "user").(*db.User).Username)
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "reset password of user " + user.Username})
+}
+
+// Handler function for admin/users/revoke_sessions action. Logs out all devices of the user.
+// Requires the following form parameters:
+//	id - id of the user
+//
+// If successful, returns number of revoked sessions.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect id)
+//	403 - current user is not an administrator
+//	404 - user does not exist
+//	50x - server error processing request
+//	200 - Sessions revoked
+func adminRevokeSessions(w http.ResponseWriter, r *http.Request) {
+	user := adminTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	count, err := user.RevokeAllSessions()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to revoke sessions of user "+user.Username)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "revoked " + strconv.FormatInt(count, 10) + " sessions of user " + user.Username})
+	fmt.Fprintf(w, `{"revoked": %d}`, count)
+}
+
+// Handler function for admin/users/quota action. Sets storage quota of the user.
+// Requires the following form parameters:
+//	id - id of the user
+//	quota - quota in bytes, 0 to use default quota, -1 for unlimited storage
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	403 - current user is not an administrator
+//	404 - user does not exist
+//	50x - server error processing request
+//	200 - Quota changed
+func adminSetQuota(w http.ResponseWriter, r *http.Request) {
+	user := adminTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	quota, err := strconv.ParseInt(r.FormValue("quota"), 10, 64)
+	if err != nil || quota < -1 {
+		handleErr(w, 400, nil, "quota parameter is incorrect")
+		return
+	}
+	user.Quota = quota
+	if err = user.Save(); err != nil {
+		handleErr(w, 500, err, "Unable to set quota of user "+user.Username)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "set quota of user " + user.Username + " to " + r.FormValue("quota")})
+}
+
+// Handler function for admin/users/role action. Grants or revokes administrator role.
+// Requires the following form parameters:
+//	id - id of the user
+//	admin - "true" to grant the role, "false" to revoke it
+//
+// Role of administrators listed in ADMIN_USERS setting can not be revoked.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	403 - current user is not an administrator
+//	404 - user does not exist
+//	409 - administrator tried to revoke own role or role of administrator listed in ADMIN_USERS
+//	50x - server error processing request
+//	200 - Role changed
+func adminSetRole(w http.ResponseWriter, r *http.Request) {
+	user := adminTargetUser(w, r)
+	if user == nil {
+		return
+	}
+	admin, err := strconv.ParseBool(r.FormValue("admin"))
+	if err != nil {
+		handleErr(w, 400, nil, "admin parameter is incorrect")
+		return
+	}
+	if !admin && isSelf(w, r, user) {
+		return
+	}
+	if !admin && db.IsConfiguredAdmin(user.Username) {
+		handleErr(w, 409, nil, "Role of administrator "+user.Username+" listed in ADMIN_USERS can not be revoked")
+		return
+	}
+	user.Admin = admin
+	if err = user.Save(); err != nil {
+		handleErr(w, 500, err, "Unable to change role of user "+user.Username)
+		return
+	}
+	logger.Infof("User %s admin: %t set by administrator %s", user.Username, admin, context.Get(r, "user").(*db.User).Username)
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "set administrator role of user " + user.Username + " to " + strconv.FormatBool(admin)})
+}
+
+// Handler function for admin/stats action. Returns overall server statistics and uptime in seconds.
+//
+// HTTP codes returned:
+//	403 - current user is not an administrator
+//	50x - server error processing request
+//	200 - Request succesful
+func adminStats(w http.ResponseWriter, r *http.Request) {
+	stats, err := db.GetServerStats()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get server stats")
+		return
+	}
+	statsJSON, err := json.Marshal(map[string]interface{}{
+		"stats":  stats,
+		"uptime": int64(time.Since(startTime).Seconds()),
+	})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(statsJSON))
+}
@@ -0,0 +1,218 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/config"
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/toolkit"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// Authenticates request made with API token. Checks whether token is valid, not expired, grants permission required
+// by the endpoint and whether the path of the request lies within token path prefix. Returns status codes:
+//	401 - token has expired
+//	403 - token is invalid, or does not allow the request
+// Handlers expect session in request context, so a session which is not stored in database is created for API token.
+func (l *AuthMiddleware) serveApiToken(w http.ResponseWriter, r *http.Request, next http.HandlerFunc, token string) {
+	if len(token) > 255 {
+		handleErr(w, 413, nil, "Token too long")
+		return
+	}
+	if !db.IsApiToken(token) {
+		handleErr(w, 403, nil, "Invalid API token")
+		return
+	}
+	apiToken, user := db.GetApiToken(token)
+	if apiToken == nil {
+		handleErr(w, 403, nil, "Invalid API token")
+		return
+	}
+	if apiToken.IsExpired() {
+		handleErr(w, 401, nil, "API token "+apiToken.Name+" expired")
+		return
+	}
+	if user.Disabled {
+		handleErr(w, 403, nil, "Account disabled for user "+user.Username)
+		return
+	}
+	if l.permission == "" || !apiToken.HasPermission(l.permission) {
+		handleErr(w, 403, nil, "API token "+apiToken.Name+" does not allow "+r.URL.Path)
+		return
+	}
+	if l.wholeTree {
+		if apiToken.PathPrefix != "/" {
+			handleErr(w, 403, nil, "API token "+apiToken.Name+" is limited to "+apiToken.PathPrefix)
+			return
+		}
+	} else {
+		path, ok := requestPath(r, l.pathParam)
+		if !ok {
+			handleErr(w, 400, nil, "path and filepath parameters point to different files")
+			return
+		}
+		if !apiToken.AllowsPath(path) {
+			handleErr(w, 403, nil, "API token "+apiToken.Name+" does not allow path "+path)
+			return
+		}
+	}
+	if err := apiToken.Touch(); err != nil {
+		logger.WithField("error", err.Error()).Error("Unable to update API token " + apiToken.Name)
+	}
+	context.Set(r, "session", &db.Session{UserId: user.Id, Token: "api_token_" + strconv.FormatInt(apiToken.Id, 10), ComputerName: apiToken.Name, Ip: remoteIP(r)})
+	context.Set(r, "user", user)
+	context.Set(r, "api_token", apiToken)
+	next(w, r)
+}
+
+// Returns path of the file which request operates on, taken from the same place the endpoint handler takes it from:
+// form parameter given by param, or the URL if param is empty. Empty form parameter means root folder.
+// Form is parsed only if path is not a part of URL, so the body of upload request is not consumed.
+// Returns false if request has both "path" and "filepath" form parameters pointing to different files,
+// as it is ambiguous which one is used.
+func requestPath(r *http.Request, param string) (string, bool) {
+	if param == "" {
+		return "/" + strings.TrimPrefix(mux.Vars(r)["filepath"], "/"), true
+	}
+	path, filepath := r.FormValue("path"), r.FormValue("filepath")
+	if path != "" && filepath != "" && toolkit.CleanPath("/"+path) != toolkit.CleanPath("/"+filepath) {
+		return "", false
+	}
+	return toolkit.CleanPath("/" + r.FormValue(param)), true
+}
+
+// Handler function for tokens action. Lists API tokens of current user. Tokens themselves are never returned.
+//
+// HTTP codes returned:
+//	50x - server error processing request
+//	200 - Request succesful
+func apiTokens(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	tokens, err := user.GetApiTokens()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get API tokens for user "+user.Username)
+		return
+	}
+	tokensToReturn := make([]map[string]interface{}, len(tokens))
+	for index, token := range tokens {
+		tokensToReturn[index] = apiTokenMap(&token)
+	}
+	tokensJSON, err := json.Marshal(tokensToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(tokensJSON))
+}
+
+// Handler function for creating API token. Requires session, API token cannot create other tokens.
+// Requires the following form parameters:
+//	name - name describing the token
+//	path - folder the token is limited to, "/" for whole account
+//	permissions - comma separated list of granted permissions: read, write, delete
+//	expires_in (optional) - token lifetime in seconds
+//
+// If successful, returns token metadata along with the token. Token is returned only once.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, unknown permission, lifetime too long, etc.)
+//	50x - server error processing request
+//	200 - Token created
+func createApiToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	name := r.FormValue("name")
+	if name == "" || len(name) > 255 {
+		handleErr(w, 400, nil, "name not provided or too long")
+		return
+	}
+	path := r.FormValue("path")
+	if path == "" || !strings.HasPrefix(path, "/") {
+		handleErr(w, 400, nil, "path not provided or not absolute")
+		return
+	}
+	permissions := strings.Split(r.FormValue("permissions"), ",")
+	for _, permission := range permissions {
+		if permission != db.PermRead && permission != db.PermWrite && permission != db.PermDelete {
+			handleErr(w, 400, nil, "unknown permission "+permission)
+			return
+		}
+	}
+	lifetime := int64(config.API_TOKEN_DEFAULT_LIFETIME)
+	if r.FormValue("expires_in") != "" {
+		var err error
+		lifetime, err = strconv.ParseInt(r.FormValue("expires_in"), 10, 0)
+		if err != nil || lifetime <= 0 || lifetime > config.API_TOKEN_MAX_LIFETIME {
+			handleErr(w, 400, nil, "expires_in parameter is incorrect")
+			return
+		}
+	}
+	apiToken, token, err := user.CreateApiToken(name, path, permissions, time.Now().Add(time.Duration(lifetime)*time.Second))
+	if err != nil {
+		handleErr(w, 500, err, "Unable to create API token")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAccount, Path: apiToken.PathPrefix, Details: "created API token " + strconv.FormatInt(apiToken.Id, 10) +
+		" " + apiToken.Name + " with permissions " + apiToken.Permissions})
+	resp := apiTokenMap(apiToken)
+	resp["token"] = token
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for tokens/revoke action.
+// Requires the following form parameters:
+//	id - id of API token to revoke
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, etc.)
+//	404 - token does not exist
+//	50x - server error processing request
+//	200 - Token revoked
+func revokeApiToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return
+	}
+	err = user.RevokeApiToken(id)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, nil, "API token does not exist")
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to revoke API token")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAccount, Details: "revoked API token " + r.FormValue("id")})
+}
+
+func apiTokenMap(token *db.ApiToken) map[string]interface{} {
+	return map[string]interface{}{
+		"id":          token.Id,
+		"name":        token.Name,
+		"path":        token.PathPrefix,
+		"permissions": strings.Split(token.Permissions, ","),
+		"created":     token.Created,
+		"expires":     token.Expires,
+		"last_used":   token.LastUsed,
+	}
+}
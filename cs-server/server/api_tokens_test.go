@@ -0,0 +1,64 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+)
+
+func TestRequestPathForm(t *testing.T) {
+	tests := []struct {
+		name     string
+		param    string
+		form     url.Values
+		expected string
+		ok       bool
+	}{
+		{"path", "path", url.Values{"path": {"/a/b"}}, "/a/b", true},
+		{"filepath", "filepath", url.Values{"filepath": {"/a/b"}}, "/a/b", true},
+		{"handler parameter is used", "filepath", url.Values{"filepath": {"/a/x"}, "path": {"/a/x"}}, "/a/x", true},
+		{"different path and filepath", "filepath", url.Values{"path": {"/a"}, "filepath": {"/other/x"}}, "", false},
+		{"different filepath and path", "path", url.Values{"path": {"/a"}, "filepath": {"/other/x"}}, "", false},
+		{"other parameter is not used", "filepath", url.Values{"path": {"/a/x"}}, "/", true},
+		{"empty means root", "path", url.Values{}, "/", true},
+		{"cleaned", "path", url.Values{"path": {"a/../../Other/./X"}}, "/other/x", true},
+		{"same file written differently", "path", url.Values{"path": {"/A/b/"}, "filepath": {"a//b"}}, "/a/b", true},
+	}
+	for _, test := range tests {
+		r, err := http.NewRequest("POST", "/check_upload", strings.NewReader(test.form.Encode()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		path, ok := requestPath(r, test.param)
+		if ok != test.ok || path != test.expected {
+			t.Errorf("%s: requestPath = %q, %v, expected %q, %v", test.name, path, ok, test.expected, test.ok)
+		}
+	}
+}
+
+func TestRequestPathURL(t *testing.T) {
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"/files/a/b.txt", "/a/b.txt"},
+		{"/files/", "/"},
+		{"/files/a/b.txt?path=/other", "/a/b.txt"},
+	}
+	for _, test := range tests {
+		var path string
+		router := mux.NewRouter()
+		router.HandleFunc("/files/{filepath:.*}", func(w http.ResponseWriter, r *http.Request) {
+			path, _ = requestPath(r, "")
+		})
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", test.url, nil))
+		if path != test.expected {
+			t.Errorf("requestPath for %s = %q, expected %q", test.url, path, test.expected)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/cs-server/storage"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"time"
+)
+
+// Walks folder of the user recursively, including shared folders mounted inside it. For each file and folder,
+// fn is called with owner of the file, its path relative to the walked folder, built from file names,
+// and its metadata with path as seen by the user. If asOf is not zero time, files are walked as they were
+// at that time, shared folders are walked as they are mounted now.
+func walkFolder(user *db.User, folder string, rel string, asOf time.Time, fn func(owner *db.User, rel string, metadata *db.Metadata) error) error {
+	location, err := user.Resolve(folder)
+	if err != nil {
+		return err
+	}
+	var children []db.Metadata
+	if asOf.IsZero() {
+		children, err = location.Owner.GetChildren(location.Path)
+	} else {
+		children, err = location.Owner.GetChildrenAsOf(location.Path, asOf)
+	}
+	if err != nil {
+		return err
+	}
+	for _, child := range children {
+		child.Path = location.Translate(child.Path)
+		childRel := child.Name
+		if rel != "" {
+			childRel = rel + "/" + child.Name
+		}
+		if err = fn(location.Owner, childRel, &child); err != nil {
+			return err
+		}
+		if child.IsDir {
+			if err = walkFolder(user, child.Path, childRel, asOf, fn); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Opens content of file revision given by metadata, stored in namespace of owner.
+func openContent(owner *db.User, metadata *db.Metadata) (io.ReadCloser, error) {
+	revision, err := owner.GetRevisionById(metadata.Rev)
+	if err != nil {
+		return nil, err
+	}
+	return storage.Retrieve(revision.Uuid)
+}
+
+// Writes zip archive of folder to w, with entries placed under root folder. Archive is streamed, so contents
+// are read from storage one by one and nothing is buffered. Returns error if error has occured,
+// in that case part of the archive might already be written.
+func writeZip(w io.Writer, user *db.User, folder string, root string, asOf time.Time) error {
+	zw := zip.NewWriter(w)
+	err := walkFolder(user, folder, root, asOf, func(owner *db.User, rel string, metadata *db.Metadata) error {
+		header := &zip.FileHeader{Name: rel, Method: zip.Deflate}
+		header.SetModTime(metadata.Modified)
+		if metadata.IsDir {
+			header.Name += "/"
+			header.Method = zip.Store
+			_, err := zw.CreateHeader(header)
+			return err
+		}
+		content, err := openContent(owner, metadata)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, content)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// Writes gzipped tar archive of folder to w, with entries placed under root folder. Archive is streamed like zip archive.
+func writeTarGz(w io.Writer, user *db.User, folder string, root string, asOf time.Time) error {
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	err := walkFolder(user, folder, root, asOf, func(owner *db.User, rel string, metadata *db.Metadata) error {
+		if metadata.IsDir {
+			return tw.WriteHeader(&tar.Header{Name: rel + "/", Typeflag: tar.TypeDir, Mode: 0755, ModTime: metadata.Modified})
+		}
+		content, err := openContent(owner, metadata)
+		if err != nil {
+			return err
+		}
+		defer content.Close()
+		if err = tw.WriteHeader(&tar.Header{Name: rel, Typeflag: tar.TypeReg, Mode: 0644, Size: metadata.Size, ModTime: metadata.Modified}); err != nil {
+			return err
+		}
+		_, err = io.CopyN(tw, content, metadata.Size)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Archive writer function, with file extension and content type of the archive.
+type archiveFormat struct {
+	write       func(w io.Writer, user *db.User, folder string, root string, asOf time.Time) error
+	ext         string
+	contentType string
+}
+
+// Returns archive format given by name, "zip" or "tar.gz". Empty name means zip. Returns nil if format is unknown.
+func getArchiveFormat(name string) *archiveFormat {
+	switch name {
+	case "", "zip":
+		return &archiveFormat{writeZip, ".zip", "application/zip"}
+	case "tar.gz", "tgz":
+		return &archiveFormat{writeTarGz, ".tar.gz", "application/gzip"}
+	}
+	return nil
+}
+
+// Streams archive of folder of the user to the response. Format of the archive is given by form parameter
+// format, "zip" (default) or "tar.gz". Writes 400 error if format is unknown.
+func serveArchive(w http.ResponseWriter, r *http.Request, user *db.User, folder string, root string, asOf time.Time) {
+	format := getArchiveFormat(r.FormValue("format"))
+	if format == nil {
+		handleErr(w, 400, nil, "format parameter is incorrect")
+		return
+	}
+	w.Header().Set("Content-Type", format.contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": root + format.ext}))
+	if err := format.write(w, user, folder, root, asOf); err != nil {
+		logger.WithField("error", err.Error()).Error("Unable to stream archive of " + folder)
+	}
+}
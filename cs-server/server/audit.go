@@ -0,0 +1,154 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+)
+
+// Number of events returned by admin/audit when limit is not given, and maximum allowed limit.
+const (
+	auditDefaultLimit = 100
+	auditMaxLimit     = 1000
+)
+
+// Records action in audit log. User, computer name and IP address are taken from the request, unless set in event.
+// Failure to record the event is logged, it does not fail the request.
+func audit(r *http.Request, event db.AuditEvent) {
+	if user, ok := context.Get(r, "user").(*db.User); ok && event.UserId == 0 {
+		event.UserId = user.Id
+		event.Username = user.Username
+	}
+	if session, ok := context.Get(r, "session").(*db.Session); ok && event.ComputerName == "" {
+		event.ComputerName = session.ComputerName
+	}
+	if event.Ip == "" {
+		event.Ip = remoteIP(r)
+	}
+	if err := db.LogAudit(&event); err != nil {
+		logger.WithField("error", err.Error()).Error("Unable to record " + event.Action + " of " + event.Path + " in audit log")
+	}
+}
+
+// Returns details of audit event describing where in namespace of the owner is path at given location stored,
+// if it is in shared folder or team. Returns empty string otherwise.
+func auditLocation(location *db.Location) string {
+	if location.Mount == nil {
+		return ""
+	}
+	return "owner " + location.Owner.Username + ", path " + location.Path
+}
+
+func auditEventMap(event *db.AuditEvent) map[string]interface{} {
+	return map[string]interface{}{
+		"id":           event.Id,
+		"created":      event.Created,
+		"username":     event.Username,
+		"computername": event.ComputerName,
+		"ip":           event.Ip,
+		"action":       event.Action,
+		"path":         event.Path,
+		"details":      event.Details,
+	}
+}
+
+// Handler function for admin/audit action. Returns events of audit log, oldest first.
+// Accepts the following form parameters:
+//	username (optional) - only events of given user
+//	action (optional) - only given action (login, login_failed, upload, remove, create_folder, share, restore,
+//	                    register, account, admin)
+//	path (optional) - only events of given path and paths under it
+//	since, until (optional) - only events recorded in given period, unix timestamps
+//	after_id (optional) - only events after event with given id, used for paging
+//	limit (optional) - maximum number of events, 100 by default, at most 1000
+//	format (optional) - "jsonl" exports all matching events as JSON lines, one event per line, limit is ignored
+//
+// HTTP codes returned:
+//	400 - request invalid (incorrect parameter)
+//	403 - current user is not an administrator
+//	50x - server error processing request
+//	200 - Request succesful
+func adminAudit(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	filter := db.AuditFilter{Username: r.FormValue("username"), Action: r.FormValue("action"), Path: r.FormValue("path"), Limit: auditDefaultLimit}
+	numbers := map[string]*int64{"since": &filter.Since, "until": &filter.Until, "after_id": &filter.AfterId}
+	for name, value := range numbers {
+		if r.FormValue(name) == "" {
+			continue
+		}
+		number, err := strconv.ParseInt(r.FormValue(name), 10, 64)
+		if err != nil || number < 0 {
+			handleErr(w, 400, nil, name+" parameter is incorrect")
+			return
+		}
+		*value = number
+	}
+	if r.FormValue("limit") != "" {
+		limit, err := strconv.Atoi(r.FormValue("limit"))
+		if err != nil || limit <= 0 || limit > auditMaxLimit {
+			handleErr(w, 400, nil, "limit parameter is incorrect")
+			return
+		}
+		filter.Limit = limit
+	}
+	switch r.FormValue("format") {
+	case "", "json":
+	case "jsonl":
+		exportAudit(w, filter)
+		return
+	default:
+		handleErr(w, 400, nil, "format parameter is incorrect")
+		return
+	}
+	events, err := db.GetAuditEvents(filter)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get audit log")
+		return
+	}
+	eventsToReturn := make([]map[string]interface{}, len(events))
+	for index, event := range events {
+		eventsToReturn[index] = auditEventMap(&event)
+	}
+	eventsJSON, err := json.Marshal(eventsToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(eventsJSON))
+}
+
+// Streams all audit events matching the filter as JSON lines. Events are read in batches,
+// so export of large log does not load it into memory at once.
+func exportAudit(w http.ResponseWriter, filter db.AuditFilter) {
+	filter.Limit = auditMaxLimit
+	events, err := db.GetAuditEvents(filter)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get audit log")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", "attachment; filename=audit.jsonl")
+	encoder := json.NewEncoder(w)
+	for {
+		for _, event := range events {
+			if err = encoder.Encode(auditEventMap(&event)); err != nil {
+				return
+			}
+			filter.AfterId = event.Id
+		}
+		if len(events) < filter.Limit {
+			return
+		}
+		if events, err = db.GetAuditEvents(filter); err != nil {
+			logger.WithField("error", err.Error()).Error("Unable to export audit log")
+			return
+		}
+	}
+}
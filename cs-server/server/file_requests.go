@@ -0,0 +1,301 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+func fileRequestMap(request *db.FileRequest) map[string]interface{} {
+	return map[string]interface{}{
+		"id":             request.Id,
+		"path":           request.Path,
+		"created":        request.Created,
+		"expires":        request.Expires,
+		"max_file_size":  request.MaxFileSize,
+		"max_total_size": request.MaxTotalSize,
+		"uploads":        request.Uploads,
+		"uploaded_bytes": request.UploadedBytes,
+		"expired":        request.IsExpired(),
+	}
+}
+
+// Handler function for file_requests action. Lists file requests of current user. Tokens are not returned.
+//
+// HTTP codes returned:
+//	50x - server error processing request
+//	200 - Request succesful
+func fileRequests(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	requests, err := user.GetFileRequests()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get file requests for user "+user.Username)
+		return
+	}
+	requestsToReturn := make([]map[string]interface{}, len(requests))
+	for index, request := range requests {
+		requestsToReturn[index] = fileRequestMap(&request)
+	}
+	requestsJSON, err := json.Marshal(requestsToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(requestsJSON))
+}
+
+// Handler function for creating file request, upload-only link to folder.
+// Requires the following form parameters:
+//	path - folder where files are uploaded
+//	expires_in (optional) - request lifetime in seconds, request does not expire if not given
+//	max_file_size (optional) - maximum size of single file in bytes, unlimited if not given
+//	max_total_size (optional) - maximum size of all uploaded files in bytes, unlimited if not given
+//
+// If successful, returns request metadata along with the token and URL path for uploads. Token is returned only once.
+// Files are uploaded with PUT request to <url>/<file name>.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter, path is not a folder)
+//	403 - shared folder is read-only
+//	404 - folder does not exist
+//	50x - server error processing request
+//	200 - File request created
+func createFileRequest(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	folder := r.FormValue("path")
+	if folder == "" || !strings.HasPrefix(folder, "/") {
+		handleErr(w, 400, nil, "path not provided or not absolute")
+		return
+	}
+	var expires time.Time
+	if r.FormValue("expires_in") != "" {
+		lifetime, err := strconv.ParseInt(r.FormValue("expires_in"), 10, 0)
+		if err != nil || lifetime <= 0 {
+			handleErr(w, 400, nil, "expires_in parameter is incorrect")
+			return
+		}
+		expires = time.Now().Add(time.Duration(lifetime) * time.Second)
+	}
+	limits := map[string]int64{"max_file_size": 0, "max_total_size": 0}
+	for name := range limits {
+		if r.FormValue(name) == "" {
+			continue
+		}
+		limit, err := strconv.ParseInt(r.FormValue(name), 10, 64)
+		if err != nil || limit < 0 {
+			handleErr(w, 400, nil, name+" parameter is incorrect")
+			return
+		}
+		limits[name] = limit
+	}
+	request, token, err := user.CreateFileRequest(folder, expires, limits["max_file_size"], limits["max_total_size"])
+	switch err {
+	case nil:
+	case db.ErrNotFolder:
+		handleErr(w, 400, err, "Unable to create file request for "+folder)
+		return
+	case db.ErrReadOnlyShare:
+		handleErr(w, 403, err, "Unable to create file request for "+folder)
+		return
+	case db.ErrEntityNotExists:
+		handleErr(w, 404, err, "Unable to create file request for "+folder)
+		return
+	default:
+		handleErr(w, 500, err, "Unable to create file request for "+folder)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Path: request.Path, Details: "created file request " + strconv.FormatInt(request.Id, 10)})
+	resp := fileRequestMap(request)
+	resp["token"] = token
+	resp["url"] = "/file_requests/upload/" + token
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for file_requests/revoke action.
+// Requires the following form parameters:
+//	id - id of file request to revoke
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, etc.)
+//	404 - file request does not exist
+//	50x - server error processing request
+//	200 - File request revoked
+func revokeFileRequest(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	err = user.RevokeFileRequest(id)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, err, "File request "+r.FormValue("id")+" does not exist for user "+user.Username)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to revoke file request")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Details: "revoked file request " + r.FormValue("id")})
+}
+
+// Returns file request given by token in request URL and user who created it. Writes error to the response
+// and returns nil if request does not exist or has expired.
+func publicFileRequest(w http.ResponseWriter, r *http.Request) (*db.FileRequest, *db.User) {
+	token := mux.Vars(r)["token"]
+	if token == "" || len(token) > 255 {
+		handleErr(w, 404, nil, "File request does not exist")
+		return nil, nil
+	}
+	request, user := db.GetFileRequest(token)
+	if request == nil || user.Disabled {
+		handleErr(w, 404, nil, "File request does not exist")
+		return nil, nil
+	}
+	if request.IsExpired() {
+		handleErr(w, 410, nil, "File request "+strconv.FormatInt(request.Id, 10)+" expired")
+		return nil, nil
+	}
+	return request, user
+}
+
+// Handler function for file request information. Does not require authentication.
+// Returns name of the folder and upload limits, contents of the folder are not revealed.
+//
+// HTTP codes returned:
+//	404 - file request does not exist
+//	410 - file request expired
+//	200 - Request succesful
+func fileRequestInfo(w http.ResponseWriter, r *http.Request) {
+	request, _ := publicFileRequest(w, r)
+	if request == nil {
+		return
+	}
+	var remaining int64
+	if request.MaxTotalSize != 0 {
+		remaining = request.MaxTotalSize - request.UploadedBytes
+	}
+	respJSON, err := json.Marshal(map[string]interface{}{
+		"folder":        path.Base(request.Path),
+		"expires":       request.Expires,
+		"max_file_size": request.MaxFileSize,
+		"remaining":     remaining,
+	})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Names which might not be uploaded with file request, as they change behaviour of clients syncing the folder.
+var reservedUploadNames = map[string]bool{
+	".cloudsyncignore": true,
+}
+
+// Returns true if file with given name might be uploaded with file request.
+func validUploadName(name string) bool {
+	if name == "" || name == "." || name == ".." || len(name) > 255 || strings.ContainsAny(name, "/\\\x00") {
+		return false
+	}
+	return !reservedUploadNames[strings.ToLower(name)]
+}
+
+// Handler function for uploading file with file request. Does not require authentication.
+// Token of the file request and file name should be provided as part of the request URL, content as request body.
+// Content-Length header is required. File is stored in the requested folder through the same path as upload,
+// existing files are never overwritten. Devices of the owner are notified.
+// If successful, returns name and size of stored file.
+//
+// HTTP codes returned:
+//	400 - file name invalid or reserved
+//	403 - folder is in shared folder which is read-only
+//	404 - file request does not exist, or its folder was removed
+//	410 - file request expired
+//	411 - Content-Length header missing
+//	413 - file exceeds size limits of the request
+//	507 - storage quota of the owner exceeded
+//	50x - server error processing request
+//	200 - Upload successful
+func fileRequestUpload(w http.ResponseWriter, r *http.Request) {
+	request, user := publicFileRequest(w, r)
+	if request == nil {
+		return
+	}
+	name := mux.Vars(r)["filename"]
+	if !validUploadName(name) {
+		handleErr(w, 400, nil, "file name is incorrect")
+		return
+	}
+	if r.ContentLength < 0 {
+		handleErr(w, 411, nil, "Content-Length required for file request upload")
+		return
+	}
+	if request.MaxFileSize != 0 && r.ContentLength > request.MaxFileSize {
+		handleErr(w, 413, nil, "File exceeds size limit of file request "+strconv.FormatInt(request.Id, 10))
+		return
+	}
+	location, err := user.Resolve(request.Path)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to resolve path "+request.Path)
+		return
+	}
+	if !location.CanWrite() {
+		handleErr(w, 403, db.ErrReadOnlyShare, "File request "+strconv.FormatInt(request.Id, 10)+" points to read-only shared folder")
+		return
+	}
+	if location.Path != "/" {
+		folder, err := location.Owner.GetFileByPath(location.Path)
+		if err != nil || folder == nil || folder.IsRemoved || !folder.IsDir {
+			handleErr(w, 404, err, "Folder "+request.Path+" of file request does not exist")
+			return
+		}
+	}
+	filepath, reservation, err := location.Owner.ReserveUploadPath(location.Path, name)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to find name for uploaded file "+name)
+		return
+	}
+	defer reservation.Release()
+	size := r.ContentLength
+	if ok, err := request.Reserve(size); err != nil || !ok {
+		handleErr(w, 413, err, "Uploads exceed size limit of file request "+strconv.FormatInt(request.Id, 10))
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, size)
+	metadata := storeUpload(w, r, &db.Location{Owner: location.Owner, Path: filepath, Mount: location.Mount}, "")
+	if metadata == nil {
+		request.Release(size)
+		return
+	}
+	logger.Infof("File %s uploaded with file request %d of user %s", metadata.Path, request.Id, user.Username)
+	audit(r, db.AuditEvent{ComputerName: "file request " + strconv.FormatInt(request.Id, 10), Action: db.AuditUpload,
+		Path: strings.TrimSuffix(request.Path, "/") + "/" + metadata.Name, Details: "uploaded anonymously to folder of user " + user.Username})
+	respJSON, err := json.Marshal(map[string]interface{}{"name": metadata.Name, "size": metadata.Size})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidUploadName(t *testing.T) {
+	tests := []struct {
+		name  string
+		valid bool
+	}{
+		{"report.pdf", true},
+		{"photo (2).jpg", true},
+		{".hidden", true},
+		{"..report", true},
+		{strings.Repeat("a", 255), true},
+		{"", false},
+		{".", false},
+		{"..", false},
+		{strings.Repeat("a", 256), false},
+		{"a/b.txt", false},
+		{"../b.txt", false},
+		{`a\b.txt`, false},
+		{"a\x00.txt", false},
+		{".cloudsyncignore", false},
+		{".CloudSyncIgnore", false},
+	}
+	for _, test := range tests {
+		if valid := validUploadName(test.name); valid != test.valid {
+			t.Errorf("validUploadName(%q) = %v, expected %v", test.name, valid, test.valid)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"cloudsyncer/cs-server/config"
 	"cloudsyncer/cs-server/db"
 	"cloudsyncer/cs-server/storage"
 	"cloudsyncer/toolkit"
@@ -22,14 +23,24 @@ import (
 //	username - username
 //	password - password
 //	computername (optional) - computer name means client wants to create a session. In that case authenicty_token is returned and login is not required.
+//	invite - invite code, required if server is in invite-only mode
+//
+// Username and password must satisfy registration policy. Policy violations are returned as JSON list of errors,
+// each with field, code and message, see handlePolicyErr.
 //
 // HTTP codes returned:
-//	400 - request invalid (missing parameter, too long, etc.)
+//	400 - request invalid (missing parameter, too long, username or password rejected by policy, etc.)
+//	403 - registration closed, invite missing or invalid
 //	413 - password too long (possible DoS attempt)
 //	409 - user already exists
+//	429 - too many requests, Retry-After header is set
 //	50x - server error processing request
 //	200 - Registration successful
 func register(w http.ResponseWriter, r *http.Request) {
+	if config.REGISTRATION_MODE == "closed" {
+		handlePolicyErr(w, 403, db.PolicyErrors{{Code: "registration_closed", Message: "Registration is closed"}})
+		return
+	}
 	if err := r.ParseForm(); err != nil {
 		handleErr(w, 400, err, "unable to parse form in register action")
 		return
@@ -64,22 +75,48 @@ func register(w http.ResponseWriter, r *http.Request) {
 		handleErr(w, 413, nil, "Password too long (possible DoS)")
 		return
 	}
+	if errs := append(db.ValidateUsername(username), db.ValidatePassword(username, password)...); errs != nil {
+		handlePolicyErr(w, 400, errs)
+		return
+	}
 	var computername string
 	if params["computername"] != nil {
 		computername = params["computername"][0]
 	}
-	if user := db.GetUser(username); user != nil {
-		handleErr(w, 409, nil, "User already exist")
+	invite := r.FormValue("invite")
+	if config.REGISTRATION_MODE == "invite" && invite == "" {
+		handlePolicyErr(w, 403, db.PolicyErrors{{Field: "invite", Code: "invite_required", Message: "Invite code is required"}})
+		return
+	}
+	// names of configured administrators are reserved
+	if user := db.GetUser(username); user != nil || db.IsConfiguredAdmin(username) {
+		handlePolicyErr(w, 409, db.PolicyErrors{{Field: "username", Code: "username_taken", Message: "Username is already taken"}})
+		return
+	}
+	var user *db.User
+	var err error
+	if config.REGISTRATION_MODE == "invite" {
+		user, err = db.CreateUserWithInvite(username, password, invite)
+	} else {
+		user, err = db.CreateUser(username, password)
+	}
+	if err == db.ErrInvalidCode {
+		handlePolicyErr(w, 403, db.PolicyErrors{{Field: "invite", Code: "invite_invalid", Message: "Invite code is invalid, expired or already used"}})
 		return
 	}
-	user, err := db.CreateUser(username, password)
 	if err != nil {
 		handleErr(w, 500, err, "Error during user creation")
 		return
 	}
+	audit(r, db.AuditEvent{UserId: user.Id, Username: user.Username, ComputerName: computername, Action: db.AuditRegister,
+		Details: "registration mode " + config.REGISTRATION_MODE})
 	if computername != "" {
-		session, err := db.CreateSession(user, computername)
-		jsonToken, err := json.Marshal(Token{AuthencityToken: session.Token})
+		_, token, err := db.CreateSession(user, computername, remoteIP(r))
+		if err != nil {
+			handleErr(w, 500, err, "Error creating session during register for user "+username)
+			return
+		}
+		jsonToken, err := json.Marshal(Token{AuthencityToken: token})
 		if err != nil {
 			handleErr(w, 500, err, "Error on marshalling token for user during register for user "+username+" and computername "+computername)
 			return
@@ -94,12 +131,16 @@ func register(w http.ResponseWriter, r *http.Request) {
 //	username - username
 //	password - password
 //	computername -computer name
+//	code - one-time password or recovery code, required if user enabled two-factor authentication
 //
 // If successful, returns authencity token to be used with further requests
 //
 // HTTP codes returned:
 //	400 - request invalid (missing parameter, too long, etc.)
+//	401 - one-time password required, X-Cloudsyncer-Otp header is set
+//	403 - wrong username, password or one-time password, or account is disabled
 //	413 - password too long (possible DoS attempt)
+//	429 - too many failed logins, Retry-After header is set
 //	409 - user already exists
 //	50x - server error processing request
 //	200 - Registration successful
@@ -138,16 +179,35 @@ func login(w http.ResponseWriter, r *http.Request) {
 		handleErr(w, 413, nil, "Password too long (possible DoS)")
 		return
 	}
+	if wait := loginLockedFor(username); wait > 0 {
+		tooManyRequests(w, wait, "Login locked out for user "+username)
+		return
+	}
 	user := db.GetUser(username)
 	if user == nil {
+		loginFailed(username)
+		audit(r, db.AuditEvent{Username: username, Action: db.AuditLoginFailed, Details: "user does not exist"})
 		handleErr(w, 403, nil, "User does not exist")
 		return
 	}
 
 	if !user.CheckPassword(password) {
+		loginFailed(username)
+		audit(r, db.AuditEvent{UserId: user.Id, Username: username, Action: db.AuditLoginFailed, Details: "wrong password"})
 		handleErr(w, 403, nil, "Wrong password for user "+username)
 		return
 	}
+	if user.Disabled || user.IsTeam() {
+		handleErr(w, 403, nil, "Account disabled for user "+username)
+		return
+	}
+	if user.NeedsRehash() {
+		if err := user.SetPassword(password); err != nil {
+			logger.WithField("error", err.Error()).Error("Unable to upgrade password hash for user " + username)
+		} else {
+			logger.Info("Upgraded password hash for user " + username)
+		}
+	}
 	if params["computername"] == nil {
 		handleErr(w, 400, nil, "computername not provided or empty")
 		return
@@ -157,9 +217,30 @@ func login(w http.ResponseWriter, r *http.Request) {
 		handleErr(w, 400, nil, "Missing computername for user "+username)
 		return
 	}
+	if user.TotpEnabled {
+		code := r.FormValue("code")
+		if code == "" {
+			w.Header().Set("X-Cloudsyncer-Otp", "required")
+			handleErr(w, 401, nil, "One-time password required for user "+username)
+			return
+		}
+		if len(code) > 255 || !user.CheckSecondFactor(code) {
+			loginFailed(username)
+			audit(r, db.AuditEvent{UserId: user.Id, Username: username, ComputerName: computername, Action: db.AuditLoginFailed, Details: "wrong one-time password"})
+			w.Header().Set("X-Cloudsyncer-Otp", "required")
+			handleErr(w, 403, nil, "Wrong one-time password for user "+username)
+			return
+		}
+	}
 
-	session, err := db.CreateSession(user, computername)
-	jsonToken, err := json.Marshal(Token{AuthencityToken: session.Token})
+	loginSucceeded(username)
+	_, token, err := db.CreateSession(user, computername, remoteIP(r))
+	if err != nil {
+		handleErr(w, 500, err, "Error creating session for user "+username)
+		return
+	}
+	audit(r, db.AuditEvent{UserId: user.Id, Username: username, ComputerName: computername, Action: db.AuditLogin})
+	jsonToken, err := json.Marshal(Token{AuthencityToken: token})
 	if err != nil {
 		handleErr(w, 500, err, "Error on marshalling token for user during register for user "+username+" and computername "+computername)
 		return
@@ -170,10 +251,12 @@ func login(w http.ResponseWriter, r *http.Request) {
 
 // Handler function for metadata action. Returns metadata for given path, optionally for given revision
 // If path or revision does not exist, this method returns error.
+// Optional form parameter "as_of" (unix timestamp) might be provided to get metadata of revision current at that time,
+// also for files removed since then.
 //
 // HTTP codes returned:
 //	400 - request invalid (missing parameter, too long, etc.)
-//	404 - path or revision does not exist
+//	404 - path or revision does not exist, or path did not exist at given time
 //	50x - server error processing request
 //	200 - Metadata returned
 func metadata(w http.ResponseWriter, r *http.Request) {
@@ -188,9 +271,31 @@ func metadata(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	path := "/" + vars["filepath"]
-	user := context.Get(r, "user").(*db.User)
 	path = toolkit.CleanPath(path)
-	file, err := user.GetFileByPath(path)
+	location := resolvePath(w, r, path, false)
+	if location == nil {
+		return
+	}
+	asOf, ok := parseAsOf(w, r)
+	if !ok {
+		return
+	}
+	if !asOf.IsZero() && r.FormValue("rev") == "" {
+		metadata, err := location.Owner.GetMetadataAsOf(location.Path, asOf)
+		if err != nil {
+			handleErr(w, 500, err, "Error getting metadata of "+path+" as of "+asOf.String())
+			return
+		}
+		if metadata == nil {
+			handleErr(w, 404, nil, "file "+path+" not found as of "+asOf.String())
+			return
+		}
+		metadata.Path = location.Translate(metadata.Path)
+		metadataJSON, err := json.Marshal(metadata)
+		fmt.Fprintf(w, string(metadataJSON))
+		return
+	}
+	file, err := location.Owner.GetFileByPath(location.Path)
 	if file == nil {
 		handleErr(w, 404, nil, "file "+path+" not found")
 		return
@@ -223,6 +328,7 @@ func metadata(w http.ResponseWriter, r *http.Request) {
 	}
 
 	metadata, err := revision.GetMetadata()
+	metadata.Path = location.Translate(metadata.Path)
 	metadataJSON, err := json.Marshal(metadata)
 	fmt.Fprintf(w, string(metadataJSON))
 	return
@@ -234,9 +340,12 @@ func metadata(w http.ResponseWriter, r *http.Request) {
 // Upload assumes that client knows what he is doing, in particular if the file in given path already exists,
 // this method overwrites it.
 // If parent directory does not exist, this method returns error.
+// Files uploaded to shared folder are stored in namespace and quota of the share owner.
 //
 // HTTP codes returned:
 //	400 - request invalid (missing parameter, too long, etc.)
+//	403 - shared folder is read-only
+//	507 - storage quota exceeded
 //	50x - server error processing request
 //	200 - Upload successful
 func upload(w http.ResponseWriter, r *http.Request) {
@@ -250,37 +359,126 @@ func upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	filepath := "/" + vars["filepath"]
-	user := context.Get(r, "user").(*db.User)
 	session := context.Get(r, "session").(*db.Session)
 	filepath = toolkit.OnlyCleanPath(filepath)
+	location := resolvePath(w, r, filepath, true)
+	if location == nil {
+		return
+	}
+	metadata := storeUpload(w, r, location, session.Token)
+	if metadata == nil {
+		return
+	}
+	metadata.Path = location.Translate(metadata.Path)
+	audit(r, db.AuditEvent{Action: db.AuditUpload, Path: metadata.Path, Details: auditLocation(location)})
+	metadataJSON, err := json.Marshal(metadata)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(metadataJSON))
+	return
+}
+
+// Stores body of the request as new revision of file at given location, charging quota of the owner,
+// and notifies devices of the owner and of shared folder members, except session with curToken.
+// Returns metadata of stored file with path in namespace of the owner. Writes error to the response and returns nil
+// if content could not be stored:
+//	507 - storage quota exceeded
+//	50x - server error processing request
+func storeUpload(w http.ResponseWriter, r *http.Request, location *db.Location, curToken string) *db.Metadata {
+	user := location.Owner
+	replaced, err := currentSize(user, location.Path)
+	if err != nil {
+		handleErr(w, 500, err, "Error checking quota for file "+location.Path)
+		return nil
+	}
+	if !checkQuota(w, user, r.ContentLength, replaced) {
+		return nil
+	}
 	uuidVal := uuid.New()
 	size, err := storage.Store(uuidVal, r.Body)
 	if err != nil {
 		handleErr(w, 500, err, "Error saving file: "+err.Error())
-		return
+		return nil
+	}
+	if r.ContentLength < 0 {
+		// size was not known before the upload
+		if !checkQuota(w, user, size, replaced) {
+			storage.Remove(uuidVal)
+			return nil
+		}
 	}
 	hash := storage.GetHash(uuidVal)
-	revision, err := user.CreateRevision(filepath, uuidVal, size, hash)
+	revision, err := user.CreateRevision(location.Path, uuidVal, size, hash)
 	if err != nil {
 		handleErr(w, 500, err, "Error saving revision")
-		return
+		return nil
 	}
-
 	metadata, err := revision.GetMetadata()
-	metadataJSON, err := json.Marshal(metadata)
-	fmt.Fprintf(w, string(metadataJSON))
-	sendUpdate(user.Id, session.Token)
-	sendUpdateWS(user.Id, session.Token, metadata)
-	return
+	if err != nil {
+		handleErr(w, 500, err, "Error getting metadata of revision for file "+location.Path)
+		return nil
+	}
+	notifyChange(user, curToken, metadata)
+	return metadata
+}
+
+// Checks whether storing additional bytes in place of replaced bytes fits in storage quota of the user.
+// Writes error to the response and returns false if it does not:
+//	507 - storage quota exceeded
+//	500 - usage of the user could not be read
+func checkQuota(w http.ResponseWriter, user *db.User, additional int64, replaced int64) bool {
+	exceeds, err := user.ExceedsQuota(additional, replaced)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to check quota of user "+user.Username)
+		return false
+	}
+	if exceeds {
+		handleErr(w, 507, nil, "Quota exceeded for user "+user.Username)
+		return false
+	}
+	return true
+}
+
+// Returns size of current revision of file at given path, or 0 if file does not exist or is removed.
+// Used to check quota, as overwritten file does not count into usage anymore.
+func currentSize(user *db.User, path string) (int64, error) {
+	file, err := user.GetFileByPath(path)
+	if err != nil || file == nil || file.IsRemoved || file.IsDir {
+		return 0, err
+	}
+	revision, err := file.GetCurrentRevision()
+	if err != nil {
+		return 0, err
+	}
+	return revision.Size, nil
+}
+
+// Returns time given by form parameter as_of, unix timestamp, or zero time if it is not given.
+// Writes error to the response and returns false if parameter is incorrect.
+func parseAsOf(w http.ResponseWriter, r *http.Request) (time.Time, bool) {
+	if r.FormValue("as_of") == "" {
+		return time.Time{}, true
+	}
+	seconds, err := strconv.ParseInt(r.FormValue("as_of"), 10, 64)
+	if err != nil || seconds <= 0 {
+		handleErr(w, 400, nil, "as_of parameter is incorrect")
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
 }
 
 // Handler function for download action.
 // Filepath to download should be provided as part of the request URL
 // If successful, returns body of the file (metadata might be requested in separate call to metadata endpoint).
 // Optional form parameter "rev" might be provided to download particular revision of the file.
+// Optional form parameter "as_of" (unix timestamp) might be provided to download file or folder as it was at that time.
+// Folders are streamed as archive, built from revisions of files under the folder, in format given
+// by optional form parameter "format", "zip" (default) or "tar.gz".
 //
 // HTTP codes returned:
-//	400 - request invalid (missing parameter, too long, etc.)
+//	400 - request invalid (missing parameter, too long, unknown format, etc.)
 //	404 - file does not exist or is deleted and revision number is not valid
 //	50x - server error processing request
 //	200 - File exist
@@ -296,9 +494,21 @@ func file(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	path := "/" + vars["filepath"]
-	user := context.Get(r, "user").(*db.User)
 	path = toolkit.CleanPath(path)
-	file, err := user.GetFileByPath(path)
+	location := resolvePath(w, r, path, false)
+	if location == nil {
+		return
+	}
+	asOf, ok := parseAsOf(w, r)
+	if !ok {
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	if location.Path == "/" {
+		serveArchive(w, r, user, path, filepath.Base(toolkit.OnlyCleanPath("/"+vars["filepath"])), asOf)
+		return
+	}
+	file, err := location.Owner.GetFileByPath(location.Path)
 	if file == nil {
 		handleErr(w, 404, nil, "file "+path+" not found")
 		return
@@ -311,6 +521,24 @@ func file(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		revision, err = file.GetRevision(rev)
+	} else if !asOf.IsZero() {
+		metadata, err := location.Owner.GetMetadataAsOf(location.Path, asOf)
+		if err != nil {
+			handleErr(w, 500, err, "Error getting revision of file "+file.Path+" as of "+asOf.String())
+			return
+		}
+		if metadata == nil {
+			handleErr(w, 404, nil, "file "+path+" not found as of "+asOf.String())
+			return
+		}
+		if metadata.IsDir {
+			serveArchive(w, r, user, path, metadata.Name, asOf)
+			return
+		}
+		if revision, err = location.Owner.GetRevisionById(metadata.Rev); err != nil {
+			handleErr(w, 500, err, "Error getting revision of file "+file.Path+" as of "+asOf.String())
+			return
+		}
 	} else {
 		if file.IsRemoved {
 			handleErr(w, 404, nil, "file "+path+" not found")
@@ -322,6 +550,10 @@ func file(w http.ResponseWriter, r *http.Request) {
 			handleErr(w, 500, err, "Error getting current revision for file: "+file.Path)
 			return
 		}
+		if revision.IsDir {
+			serveArchive(w, r, user, path, revision.Name, asOf)
+			return
+		}
 
 	}
 	fileContent, err := storage.Retrieve(revision.Uuid)
@@ -340,6 +572,8 @@ func file(w http.ResponseWriter, r *http.Request) {
 //
 // HTTP codes returned:
 //	400 - request invalid (missing parameter, too long, etc.)
+//	403 - shared folder is read-only
+//	507 - storage quota exceeded
 //	50x - server error processing request
 //	201 - metadata changed, returns updated revision
 //	200 - nothing changed, returns the latest revision
@@ -370,9 +604,14 @@ func check_upload(w http.ResponseWriter, r *http.Request) {
 	}
 
 	path := r.FormValue("filepath")
-	user := context.Get(r, "user").(*db.User)
+	session := context.Get(r, "session").(*db.Session)
 	path = toolkit.CleanPath(path)
-	file, err := user.GetFileByPath(path)
+	location := resolvePath(w, r, path, true)
+	if location == nil {
+		return
+	}
+	user := location.Owner
+	file, err := user.GetFileByPath(location.Path)
 
 	if err != nil {
 		handleErr(w, 500, err, "Error Creating revision for file "+path)
@@ -390,18 +629,30 @@ func check_upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if revision.Name != r.FormValue("name") || revision.Id != file.CurrentRevisionId {
-		newRevision, err := user.CreateRevision(path, revision.Uuid, revision.Size, revision.Hash)
+		replaced, err := currentSize(user, location.Path)
+		if err != nil {
+			handleErr(w, 500, err, "Error checking quota for file "+path)
+			return
+		}
+		if !checkQuota(w, user, revision.Size, replaced) {
+			return
+		}
+		newRevision, err := user.CreateRevision(location.Path, revision.Uuid, revision.Size, revision.Hash)
 		if err != nil {
 			handleErr(w, 500, err, "Error Creating revision for file "+path)
 			return
 		}
 		metadata, err := newRevision.GetMetadata()
+		notifyChange(user, session.Token, metadata)
+		metadata.Path = location.Translate(metadata.Path)
+		audit(r, db.AuditEvent{Action: db.AuditUpload, Path: metadata.Path, Details: auditLocation(location)})
 		metadataJSON, err := json.Marshal(metadata)
 		w.WriteHeader(201)
 		fmt.Fprintf(w, string(metadataJSON))
 	}
 
 	metadata, err := revision.GetMetadata()
+	metadata.Path = location.Translate(metadata.Path)
 	metadataJSON, err := json.Marshal(metadata)
 	fmt.Fprintf(w, string(metadataJSON))
 
@@ -415,6 +666,7 @@ func check_upload(w http.ResponseWriter, r *http.Request) {
 // Optional form parameter "cursor" might be provided to get changes only from the given cursor.
 // It also returns the new cursor, which should be used for further requests to delta.
 // It might not return any changes, if no changes happened from given cursor.
+// Changes in shared folders mounted by the user are included, with paths under their mount points.
 //
 // HTTP codes returned:
 //	400 - request invalid (missing parameter, too long, etc.)
@@ -570,8 +822,11 @@ func revisions(w http.ResponseWriter, r *http.Request) {
 		handleErr(w, 400, nil, "filepath not provided")
 		return
 	}
-	user := context.Get(r, "user").(*db.User)
-	file, err := user.GetFileByPath(filepath)
+	location := resolvePath(w, r, filepath, false)
+	if location == nil {
+		return
+	}
+	file, err := location.Owner.GetFileByPath(location.Path)
 	if file == nil && err == nil {
 		handleErr(w, 404, nil, "file does not exist")
 		return
@@ -611,6 +866,7 @@ func revisions(w http.ResponseWriter, r *http.Request) {
 //
 // HTTP codes returned:
 //	400 - request invalid (missing parameter, too long, etc.)
+//	403 - shared folder is read-only
 //	50x - server error processing request
 //	200 - Registration successful
 func createFolder(w http.ResponseWriter, r *http.Request) {
@@ -622,28 +878,36 @@ func createFolder(w http.ResponseWriter, r *http.Request) {
 		handleErr(w, 400, nil, "path not provided")
 		return
 	}
-	user := context.Get(r, "user").(*db.User)
 	session := context.Get(r, "session").(*db.Session)
-	file, err := user.CreateFolder(toolkit.OnlyCleanPath(r.FormValue("path")))
+	location := resolvePath(w, r, toolkit.OnlyCleanPath(r.FormValue("path")), true)
+	if location == nil {
+		return
+	}
+	file, err := location.Owner.CreateFolder(location.Path)
 	if err != nil {
 		handleErr(w, 500, err, "Unable to create folder")
 		return
 	}
 	metadata, err := file.GetMetadata(nil)
+	notifyChange(location.Owner, session.Token, metadata)
+	metadata.Path = location.Translate(metadata.Path)
+	audit(r, db.AuditEvent{Action: db.AuditCreateFolder, Path: metadata.Path, Details: auditLocation(location)})
 	metadataJSON, err := json.Marshal(metadata)
 	fmt.Fprintf(w, string(metadataJSON))
-	sendUpdate(user.Id, session.Token)
-	sendUpdateWS(user.Id, session.Token, metadata)
 	return
 }
 
 // Handler function for remove action. Used to remove file and folders. If folder is given, all children are removed as well.
 // File path to remove should be provided as form parameter "path"
 // If successful, returns metadata of removed file/folder.
+// Removing mount point of shared folder leaves the shared folder. Removing shared folder by its owner stops sharing it.
 //
 //
 // HTTP codes returned:
 //	400 - request invalid (missing parameter, too long, etc.)
+//	403 - shared folder is read-only
+//	404 - shared folder was unshared in the meantime
+//	409 - folder of a team can not be removed from namespace of its member
 //	50x - server error processing request
 //	200 - Registration successful
 
@@ -659,15 +923,57 @@ func remove(w http.ResponseWriter, r *http.Request) {
 	user := context.Get(r, "user").(*db.User)
 	session := context.Get(r, "session").(*db.Session)
 	logger.Debugf("received request to remove path: %s", r.FormValue("path"))
-	file, err := user.Remove(toolkit.CleanPath(r.FormValue("path")))
+	location := resolvePath(w, r, toolkit.CleanPath(r.FormValue("path")), false)
+	if location == nil {
+		return
+	}
+	if location.IsMountPoint() {
+		if db.IsTeamShare(location.Mount.ShareId) {
+			handleErr(w, 409, nil, "Folder "+r.FormValue("path")+" belongs to a team, team should be left instead")
+			return
+		}
+		share := db.GetShare(location.Mount.ShareId)
+		if share == nil {
+			handleErr(w, 404, nil, "Shared folder "+r.FormValue("path")+" does not exist anymore")
+			return
+		}
+		if err := share.RemoveMember(user.Id); err != nil {
+			handleErr(w, 500, err, "Unable to leave shared folder")
+			return
+		}
+		audit(r, db.AuditEvent{Action: db.AuditShare, Path: location.Mount.MountPath, Details: "left share " + strconv.FormatInt(location.Mount.ShareId, 10)})
+		file, err := user.GetFileByPath(location.Mount.MountPath)
+		if err != nil || file == nil {
+			handleErr(w, 500, err, "Unable to get mount point of shared folder")
+			return
+		}
+		metadata, err := file.GetMetadata(nil)
+		metadataJSON, err := json.Marshal(metadata)
+		fmt.Fprintf(w, string(metadataJSON))
+		notifyChange(user, session.Token, metadata)
+		return
+	}
+	if !location.CanWrite() {
+		handleErr(w, 403, db.ErrReadOnlyShare, "User "+user.Username+" can not remove "+r.FormValue("path"))
+		return
+	}
+	file, err := location.Owner.Remove(location.Path)
 	if err != nil {
 		handleErr(w, 500, err, "Unable to remove path")
 		return
 	}
 	metadata, err := file.GetMetadata(nil)
+	notifyChange(location.Owner, session.Token, metadata)
+	if location.Mount == nil {
+		unmounted, err := user.DeleteSharesUnder(location.Path)
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("Unable to unshare removed folder " + location.Path)
+		}
+		notifyUnmounted(unmounted)
+	}
+	metadata.Path = location.Translate(metadata.Path)
+	audit(r, db.AuditEvent{Action: db.AuditRemove, Path: metadata.Path, Details: auditLocation(location)})
 	metadataJSON, err := json.Marshal(metadata)
 	fmt.Fprintf(w, string(metadataJSON))
-	sendUpdate(user.Id, session.Token)
-	sendUpdateWS(user.Id, session.Token, metadata)
 	return
 }
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/config"
+	"cloudsyncer/cs-server/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+)
+
+// Handler function for admin/invites action. Lists all invites. Invite codes themselves are never returned.
+//
+// HTTP codes returned:
+//	403 - current user is not an administrator
+//	50x - server error processing request
+//	200 - Request succesful
+func invites(w http.ResponseWriter, r *http.Request) {
+	allInvites, err := db.GetInvites()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get invites")
+		return
+	}
+	invitesToReturn := make([]map[string]interface{}, len(allInvites))
+	for index, invite := range allInvites {
+		invitesToReturn[index] = inviteMap(&invite)
+	}
+	invitesJSON, err := json.Marshal(invitesToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(invitesJSON))
+}
+
+// Handler function for creating invite, which allows single registration when server is in invite-only mode.
+// Accepts the following form parameters:
+//	lifetime (optional) - number of seconds after which invite expires, defaults to INVITE_LIFETIME setting
+//
+// If successful, returns invite with its code. The code is returned only once.
+//
+// HTTP codes returned:
+//	400 - request invalid (lifetime is incorrect)
+//	403 - current user is not an administrator
+//	50x - server error processing request
+//	200 - Invite created
+func createInvite(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	var lifetime int64 = config.INVITE_LIFETIME
+	if r.FormValue("lifetime") != "" {
+		var err error
+		lifetime, err = strconv.ParseInt(r.FormValue("lifetime"), 10, 0)
+		if err != nil || lifetime <= 0 {
+			handleErr(w, 400, nil, "lifetime parameter is incorrect")
+			return
+		}
+	}
+	invite, code, err := user.CreateInvite(lifetime)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to create invite")
+		return
+	}
+	logger.Info("Invite created by user " + user.Username)
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "created invite " + strconv.FormatInt(invite.Id, 10)})
+	inviteToReturn := inviteMap(invite)
+	inviteToReturn["code"] = code
+	inviteJSON, err := json.Marshal(inviteToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(inviteJSON))
+}
+
+// Handler function for admin/invites/revoke action. Removes unused invite.
+// Requires the following form parameters:
+//	id - id of the invite
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect id)
+//	403 - current user is not an administrator
+//	404 - unused invite with given id does not exist
+//	50x - server error processing request
+//	200 - Invite revoked
+func revokeInvite(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return
+	}
+	err = db.RevokeInvite(id)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, nil, "Invite does not exist or was already used")
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to revoke invite")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "revoked invite " + r.FormValue("id")})
+}
+
+func inviteMap(invite *db.Invite) map[string]interface{} {
+	return map[string]interface{}{
+		"id":         invite.Id,
+		"created_by": invite.CreatedBy,
+		"created":    invite.Created,
+		"expires":    invite.Expires,
+		"used_by":    invite.UsedBy,
+		"used":       invite.Used,
+	}
+}
@@ -0,0 +1,308 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/cs-server/storage"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+func linkMap(link *db.Link) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            link.Id,
+		"path":          link.Path,
+		"password":      link.HasPassword(),
+		"created":       link.Created,
+		"expires":       link.Expires,
+		"max_downloads": link.MaxDownloads,
+		"downloads":     link.Downloads,
+		"expired":       link.IsExpired(),
+	}
+}
+
+// Handler function for links action. Lists public links of current user. Tokens are not returned.
+//
+// HTTP codes returned:
+//	50x - server error processing request
+//	200 - Request succesful
+func links(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	links, err := user.GetLinks()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get links for user "+user.Username)
+		return
+	}
+	linksToReturn := make([]map[string]interface{}, len(links))
+	for index, link := range links {
+		linksToReturn[index] = linkMap(&link)
+	}
+	linksJSON, err := json.Marshal(linksToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(linksJSON))
+}
+
+// Handler function for creating public link to file or folder.
+// Requires the following form parameters:
+//	path - file or folder to share
+//	password (optional) - password required to download
+//	expires_in (optional) - link lifetime in seconds, link does not expire if not given
+//	max_downloads (optional) - number of allowed downloads, unlimited if not given
+//
+// If successful, returns link metadata along with the token and URL path of the link. Token is returned only once.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	404 - path does not exist
+//	413 - password too long (possible DoS attempt)
+//	50x - server error processing request
+//	200 - Link created
+func createLink(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	path := r.FormValue("path")
+	if path == "" || !strings.HasPrefix(path, "/") {
+		handleErr(w, 400, nil, "path not provided or not absolute")
+		return
+	}
+	password := r.FormValue("password")
+	if len(password) > 255 {
+		handleErr(w, 413, nil, "Password too long (possible DoS)")
+		return
+	}
+	var expires time.Time
+	if r.FormValue("expires_in") != "" {
+		lifetime, err := strconv.ParseInt(r.FormValue("expires_in"), 10, 0)
+		if err != nil || lifetime <= 0 {
+			handleErr(w, 400, nil, "expires_in parameter is incorrect")
+			return
+		}
+		expires = time.Now().Add(time.Duration(lifetime) * time.Second)
+	}
+	var maxDownloads int64
+	if r.FormValue("max_downloads") != "" {
+		var err error
+		maxDownloads, err = strconv.ParseInt(r.FormValue("max_downloads"), 10, 0)
+		if err != nil || maxDownloads < 0 {
+			handleErr(w, 400, nil, "max_downloads parameter is incorrect")
+			return
+		}
+	}
+	link, token, err := user.CreateLink(path, password, expires, maxDownloads)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, err, "Unable to create link to "+path)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to create link to "+path)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Path: link.Path, Details: "created public link " + strconv.FormatInt(link.Id, 10)})
+	resp := linkMap(link)
+	resp["token"] = token
+	resp["url"] = "/public/" + token
+	respJSON, err := json.Marshal(resp)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for links/revoke action.
+// Requires the following form parameters:
+//	id - id of link to revoke
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, etc.)
+//	404 - link does not exist
+//	50x - server error processing request
+//	200 - Link revoked
+func revokeLink(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	err = user.RevokeLink(id)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, err, "Link "+r.FormValue("id")+" does not exist for user "+user.Username)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to revoke link")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Details: "revoked public link " + r.FormValue("id")})
+}
+
+// Handler function for downloading public link. Does not require authentication.
+// Token of the link should be provided as part of the request URL. Files are served as they are,
+// folders as zip archive streamed while it is created.
+// Accepts the following form parameters:
+//	password - password of the link, required if link is protected, accepted only in POST body
+//	format (optional) - archive format of folders, "zip" (default) or "tar.gz"
+//
+// Password attempts are limited per client IP address like logins, failed attempts are counted per link
+// and lock the link out like failed logins. Every request which sends any content of the file counts
+// as download, including requests for part of the file, so limit of downloads might not be bypassed with ranges.
+//
+// HTTP codes returned:
+//	400 - request invalid (incorrect format)
+//	401 - password required
+//	403 - wrong password
+//	404 - link does not exist, or linked file was removed
+//	410 - link expired or its downloads are exhausted
+//	429 - too many password attempts, Retry-After header is set
+//	50x - server error processing request
+//	200 - File or archive returned
+func publicLink(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	token := mux.Vars(r)["token"]
+	if token == "" || len(token) > 255 {
+		handleErr(w, 404, nil, "Link does not exist")
+		return
+	}
+	link, user := db.GetLink(token)
+	if link == nil || user.Disabled {
+		handleErr(w, 404, nil, "Link does not exist")
+		return
+	}
+	if link.IsExpired() {
+		handleErr(w, 410, nil, "Link "+strconv.FormatInt(link.Id, 10)+" expired")
+		return
+	}
+	if link.HasPassword() {
+		lockoutKey := "link " + strconv.FormatInt(link.Id, 10)
+		if wait := loginLockedFor(lockoutKey); wait > 0 {
+			tooManyRequests(w, wait, "Link "+strconv.FormatInt(link.Id, 10)+" locked out")
+			return
+		}
+		password := r.PostFormValue("password")
+		if password == "" {
+			handleErr(w, 401, nil, "Password required for link "+strconv.FormatInt(link.Id, 10))
+			return
+		}
+		if ok, wait := authRateLimit.limiter.allow(authRateLimit.keyFunc(r)); !ok {
+			tooManyRequests(w, wait, "Too many password attempts from "+remoteIP(r))
+			return
+		}
+		if len(password) > 255 || !link.CheckPassword(password) {
+			loginFailed(lockoutKey)
+			handleErr(w, 403, nil, "Wrong password for link "+strconv.FormatInt(link.Id, 10))
+			return
+		}
+		loginSucceeded(lockoutKey)
+	}
+	location, err := user.Resolve(link.Path)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to resolve path "+link.Path)
+		return
+	}
+	file, err := location.Owner.GetFileByPath(location.Path)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get file "+link.Path)
+		return
+	}
+	if file == nil || file.IsRemoved {
+		handleErr(w, 404, nil, "file "+link.Path+" not found")
+		return
+	}
+	revision, err := file.GetCurrentRevision()
+	if err != nil {
+		handleErr(w, 500, err, "Error getting current revision for file: "+file.Path)
+		return
+	}
+	if file.IsDir && getArchiveFormat(r.FormValue("format")) == nil {
+		handleErr(w, 400, nil, "format parameter is incorrect")
+		return
+	}
+	// archives are streamed whole, Range header is ignored for them
+	if file.IsDir || servesContent(r, revision.Size) {
+		if ok, err := link.CountDownload(); err != nil || !ok {
+			handleErr(w, 410, err, "Downloads of link "+strconv.FormatInt(link.Id, 10)+" exhausted")
+			return
+		}
+	}
+	if file.IsDir {
+		serveArchive(w, r, user, link.Path, revision.Name, time.Time{})
+		return
+	}
+	content, err := storage.Retrieve(revision.Uuid)
+	if err != nil {
+		handleErr(w, 500, err, "Error retrieving content for uuid "+revision.Uuid)
+		return
+	}
+	defer content.Close()
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": revision.Name}))
+	http.ServeContent(w, r, revision.Name, revision.Modified, content)
+}
+
+// Returns true if serving the request with http.ServeContent sends any bytes of file of given size,
+// that is if it is not HEAD request and its Range header, if any, contains satisfiable range.
+// Suffix range covers whole file if it is larger than the file.
+func servesContent(r *http.Request, size int64) bool {
+	if r.Method == "HEAD" {
+		return false
+	}
+	header := strings.TrimSpace(r.Header.Get("Range"))
+	if header == "" {
+		return true
+	}
+	if !strings.HasPrefix(header, "bytes=") {
+		return false
+	}
+	for _, ra := range strings.Split(header[len("bytes="):], ",") {
+		ra = strings.TrimSpace(ra)
+		i := strings.Index(ra, "-")
+		if i < 0 {
+			return false
+		}
+		start, end := strings.TrimSpace(ra[:i]), strings.TrimSpace(ra[i+1:])
+		if start == "" {
+			// suffix range, last bytes of the file
+			n, err := strconv.ParseInt(end, 10, 64)
+			if err != nil || n < 0 {
+				return false
+			}
+			if n > 0 && size > 0 {
+				return true
+			}
+			continue
+		}
+		first, err := strconv.ParseInt(start, 10, 64)
+		if err != nil || first < 0 {
+			return false
+		}
+		if end != "" {
+			if last, err := strconv.ParseInt(end, 10, 64); err != nil || last < first {
+				return false
+			}
+		}
+		if first < size {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServesContent(t *testing.T) {
+	tests := []struct {
+		method      string
+		rangeHeader string
+		size        int64
+		expected    bool
+	}{
+		{"GET", "", 1000, true},
+		{"GET", "", 0, true},
+		{"HEAD", "", 1000, false},
+		{"GET", "bytes=0-", 1000, true},
+		{"GET", "bytes=0-1023", 1000, true},
+		{"GET", "bytes=1-", 1000, true},
+		{"GET", "bytes=999-", 1000, true},
+		{"GET", "bytes=1000-", 1000, false},
+		{"GET", "bytes=-500", 1000, true},
+		{"GET", "bytes=-999999999", 1000, true},
+		{"GET", "bytes=-0", 1000, false},
+		{"GET", "bytes=-10", 0, false},
+		{"GET", "bytes=100-199, 0-99", 1000, true},
+		{"GET", "bytes=2000-, -1", 1000, true},
+		{"GET", "bytes=2000-3000", 1000, false},
+		{"GET", "bytes=200-100", 1000, false},
+		{"GET", "bytes=abc", 1000, false},
+		{"GET", "items=0-", 1000, false},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/public/token", nil)
+		if test.rangeHeader != "" {
+			r.Header.Set("Range", test.rangeHeader)
+		}
+		if serves := servesContent(r, test.size); serves != test.expected {
+			t.Errorf("servesContent of %s with Range %q and size %d = %v, expected %v", test.method, test.rangeHeader, test.size, serves, test.expected)
+		}
+	}
+}
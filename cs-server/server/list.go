@@ -0,0 +1,199 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/toolkit"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+	"github.com/gorilla/mux"
+)
+
+// Limits of list action.
+const (
+	listDefaultLimit = 100
+	listMaxLimit     = 1000
+	listMaxDepth     = 16
+)
+
+// Position in sorted listing after which next page starts. Sort and order are kept, so cursor
+// is not used with different sorting. Encoded as base64 of JSON, opaque to clients.
+type listCursor struct {
+	Sort     string `json:"s"`
+	Desc     bool   `json:"d"`
+	Name     string `json:"n,omitempty"`
+	Size     int64  `json:"z,omitempty"`
+	Modified int64  `json:"m,omitempty"`
+	Path     string `json:"p"`
+}
+
+func newListCursor(sortBy string, desc bool, metadata *db.Metadata) *listCursor {
+	return &listCursor{Sort: sortBy, Desc: desc, Name: metadata.Name, Size: metadata.Size,
+		Modified: metadata.Modified.UnixNano(), Path: metadata.Path}
+}
+
+func (c *listCursor) encode() string {
+	cursorJSON, _ := json.Marshal(c)
+	return base64.URLEncoding.EncodeToString(cursorJSON)
+}
+
+// Decodes cursor returned by previous list request. Returns nil if cursor is malformed.
+func decodeListCursor(cursor string) *listCursor {
+	cursorJSON, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil
+	}
+	c := new(listCursor)
+	if err = json.Unmarshal(cursorJSON, c); err != nil {
+		return nil
+	}
+	return c
+}
+
+// Returns last entry of previous page which is described by this cursor.
+func (c *listCursor) after() *db.Metadata {
+	return &db.Metadata{Name: c.Name, Size: c.Size, Modified: time.Unix(0, c.Modified), Path: c.Path}
+}
+
+// Checks that folder at given location exists now or, if asOf is not zero time, existed at that time.
+// Returns db.ErrEntityNotExists if folder does not exist and db.ErrNotFolder if path is not a folder.
+func checkListedFolder(location *db.Location, asOf time.Time) error {
+	if location.Path == "/" {
+		return nil
+	}
+	var folder *db.Metadata
+	var err error
+	if asOf.IsZero() {
+		var file *db.File
+		if file, err = location.Owner.GetFileByPath(location.Path); err == nil && file != nil && !file.IsRemoved {
+			folder, err = file.GetMetadata(nil)
+		}
+	} else {
+		folder, err = location.Owner.GetMetadataAsOf(location.Path, asOf)
+	}
+	if err != nil {
+		return err
+	}
+	if folder == nil {
+		return db.ErrEntityNotExists
+	}
+	if !folder.IsDir {
+		return db.ErrNotFolder
+	}
+	return nil
+}
+
+// Handler function for list action. Returns metadata of files and folders in given folder, page by page.
+// Folder path should be provided as part of the request URL, root folder is listed if it is empty.
+// Accepts the following form parameters:
+//	sort (optional) - "name" (default, case insensitive), "size" or "modified"
+//	order (optional) - "asc" (default) or "desc"
+//	limit (optional) - maximum number of entries, 100 by default, at most 1000
+//	cursor (optional) - cursor returned by previous request, to get next page with the same sorting
+//	depth (optional) - number of folder levels to list, 1 (default) lists only children of the folder, at most 16
+//	as_of (optional) - unix timestamp, lists folder as it was at that time, each file is resolved to revision current
+//	                   at that time, including files removed since then
+//
+// Contents of shared folders are listed as they are mounted now.
+// If successful, returns path of the folder, list of its entries and cursor of the next page, which is empty
+// if there are no more entries.
+//
+// HTTP codes returned:
+//	400 - request invalid (path is not a folder, incorrect parameter)
+//	404 - folder does not exist, or did not exist at given time
+//	50x - server error processing request
+//	200 - Request succesful
+func list(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	path := toolkit.CleanPath("/" + mux.Vars(r)["filepath"])
+	location := resolvePath(w, r, path, false)
+	if location == nil {
+		return
+	}
+	asOf, ok := parseAsOf(w, r)
+	if !ok {
+		return
+	}
+	sortBy := r.FormValue("sort")
+	switch sortBy {
+	case "":
+		sortBy = "name"
+	case "name", "size", "modified":
+	default:
+		handleErr(w, 400, nil, "sort parameter is incorrect")
+		return
+	}
+	var desc bool
+	switch r.FormValue("order") {
+	case "", "asc":
+	case "desc":
+		desc = true
+	default:
+		handleErr(w, 400, nil, "order parameter is incorrect")
+		return
+	}
+	limits := map[string]int{"limit": listDefaultLimit, "depth": 1}
+	maxLimits := map[string]int{"limit": listMaxLimit, "depth": listMaxDepth}
+	for name := range limits {
+		if r.FormValue(name) == "" {
+			continue
+		}
+		value, err := strconv.Atoi(r.FormValue(name))
+		if err != nil || value <= 0 || value > maxLimits[name] {
+			handleErr(w, 400, nil, name+" parameter is incorrect")
+			return
+		}
+		limits[name] = value
+	}
+	var cursor *listCursor
+	if r.FormValue("cursor") != "" {
+		cursor = decodeListCursor(r.FormValue("cursor"))
+		if cursor == nil || cursor.Sort != sortBy || cursor.Desc != desc {
+			handleErr(w, 400, nil, "cursor parameter is incorrect")
+			return
+		}
+	}
+	order := db.ListOrder{Sort: sortBy, Desc: desc}
+	if cursor != nil {
+		order.After = cursor.after()
+	}
+	user := context.Get(r, "user").(*db.User)
+	err := checkListedFolder(location, asOf)
+	var entries []db.Metadata
+	if err == nil {
+		// one more entry tells whether there is next page
+		entries, err = user.ListTree(location, asOf, limits["depth"], order, limits["limit"]+1)
+	}
+	switch err {
+	case nil:
+	case db.ErrNotFolder:
+		handleErr(w, 400, err, "Unable to list "+path)
+		return
+	case db.ErrEntityNotExists:
+		handleErr(w, 404, err, "Unable to list "+path)
+		return
+	default:
+		handleErr(w, 500, err, "Unable to list "+path)
+		return
+	}
+	page := entries
+	nextCursor := ""
+	if len(entries) > limits["limit"] {
+		page = entries[:limits["limit"]]
+		nextCursor = newListCursor(sortBy, desc, &page[len(page)-1]).encode()
+	}
+	respJSON, err := json.Marshal(map[string]interface{}{"path": path, "entries": page, "cursor": nextCursor})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
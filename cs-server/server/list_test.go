@@ -0,0 +1,56 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"encoding/base64"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestListCursorEncoding(t *testing.T) {
+	modified := time.Date(2026, 10, 18, 12, 30, 0, 0, time.UTC)
+	tests := []struct {
+		sortBy   string
+		desc     bool
+		metadata db.Metadata
+	}{
+		{"name", false, db.Metadata{Name: "Report.pdf", Size: 10, Modified: modified, Path: "/docs/report.pdf"}},
+		{"size", true, db.Metadata{Name: "a", Size: 0, Modified: modified, Path: "/a"}},
+		{"modified", false, db.Metadata{Name: "ž_%/+", Size: 1 << 40, Modified: modified, Path: "/shared/ž_%/+"}},
+	}
+	for _, test := range tests {
+		encoded := newListCursor(test.sortBy, test.desc, &test.metadata).encode()
+		cursor := decodeListCursor(encoded)
+		if cursor == nil {
+			t.Errorf("cursor %q of %+v was not decoded", encoded, test.metadata)
+			continue
+		}
+		if cursor.Sort != test.sortBy || cursor.Desc != test.desc {
+			t.Errorf("cursor of %+v decoded with sort %s desc %v", test.metadata, cursor.Sort, cursor.Desc)
+		}
+		after := cursor.after()
+		if after.Name != test.metadata.Name || after.Size != test.metadata.Size || after.Path != test.metadata.Path ||
+			!after.Modified.Equal(test.metadata.Modified) {
+			t.Errorf("cursor of %+v decoded as %+v", test.metadata, *after)
+		}
+	}
+}
+
+func TestDecodeListCursorMalformed(t *testing.T) {
+	tests := []string{
+		"not base64!",
+		base64.URLEncoding.EncodeToString([]byte("not json")),
+		base64.URLEncoding.EncodeToString([]byte(`{"s": 1}`)),
+		base64.URLEncoding.EncodeToString([]byte(`["name"]`)),
+	}
+	for _, cursor := range tests {
+		if decoded := decodeListCursor(cursor); decoded != nil {
+			t.Errorf("malformed cursor %q decoded as %+v", cursor, decoded)
+		}
+	}
+	expected := &listCursor{Sort: "size", Desc: true, Size: 5, Path: "/a"}
+	if decoded := decodeListCursor(expected.encode()); !reflect.DeepEqual(decoded, expected) {
+		t.Errorf("cursor decoded as %+v, expected %+v", decoded, expected)
+	}
+}
@@ -0,0 +1,306 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/config"
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/cs-server/oidc"
+	"cloudsyncer/toolkit"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// How long browser login started with oidc/login might be completed.
+const oidcLoginLifetime = 10 * time.Minute
+
+// oidcLogin keeps state of login started by oidc/login or oidc/device/start, until it is completed by
+// oidc/callback or oidc/device/poll.
+type oidcLogin struct {
+	computername string
+	nonce        string
+	verifier     string
+	deviceCode   string
+	expires      time.Time
+}
+
+var (
+	oidcProvider      *oidc.Provider
+	oidcLogins        = make(map[string]*oidcLogin)
+	oidcMutex         sync.Mutex
+	oidcProviderMutex sync.Mutex
+)
+
+// Returns configured OpenID Connect provider. Discovery document is read on first use, so the server
+// starts even if the provider is not available. Returns nil if single sign-on is disabled or provider is unreachable.
+func getOidcProvider() *oidc.Provider {
+	if config.OIDC_ISSUER == "" {
+		return nil
+	}
+	oidcProviderMutex.Lock()
+	defer oidcProviderMutex.Unlock()
+	if oidcProvider == nil {
+		provider, err := oidc.NewProvider(config.OIDC_ISSUER, config.OIDC_CLIENT_ID, config.OIDC_CLIENT_SECRET, config.OIDC_REDIRECT_URL)
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("Unable to configure OpenID Connect provider")
+			return nil
+		}
+		oidcProvider = provider
+	}
+	return oidcProvider
+}
+
+// Stores pending login under new random key and returns the key. Expired logins are removed.
+func putOidcLogin(login *oidcLogin) string {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	now := time.Now()
+	for key, pending := range oidcLogins {
+		if now.After(pending.expires) {
+			delete(oidcLogins, key)
+		}
+	}
+	key := toolkit.GetRandHex(32)
+	oidcLogins[key] = login
+	return key
+}
+
+// Returns pending login stored under given key, or nil if it does not exist or has expired.
+// If remove is set, login is removed, so it might be completed only once.
+func getOidcLogin(key string, remove bool) *oidcLogin {
+	oidcMutex.Lock()
+	defer oidcMutex.Unlock()
+	login, ok := oidcLogins[key]
+	if !ok {
+		return nil
+	}
+	if remove || time.Now().After(login.expires) {
+		delete(oidcLogins, key)
+	}
+	if time.Now().After(login.expires) {
+		return nil
+	}
+	return login
+}
+
+// Maps identity confirmed by provider to user, creates session and writes username and token to the response.
+// Two-factor authentication of this server is not required, as the provider is responsible for authentication.
+func completeOidcLogin(w http.ResponseWriter, r *http.Request, claims *oidc.Claims, computername string) {
+	email := ""
+	if claims.EmailVerified {
+		email = claims.Email
+	}
+	user, err := db.GetOidcUser(claims.Issuer, claims.Subject, email, claims.Username, config.OIDC_AUTO_PROVISION)
+	if err == db.ErrEntityNotExists || err == db.ErrEntityAlreadyExists {
+		handleErr(w, 403, nil, "No user might be matched to single sign-on subject "+claims.Subject)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get user for single sign-on subject "+claims.Subject)
+		return
+	}
+	if user.Disabled || user.IsTeam() {
+		handleErr(w, 403, nil, "Account disabled for user "+user.Username)
+		return
+	}
+	_, token, err := db.CreateSession(user, computername, remoteIP(r))
+	if err != nil {
+		handleErr(w, 500, err, "Error creating session for user "+user.Username)
+		return
+	}
+	audit(r, db.AuditEvent{UserId: user.Id, Username: user.Username, ComputerName: computername, Action: db.AuditLogin, Details: "single sign-on"})
+	respJSON, err := json.Marshal(map[string]string{"username": user.Username, "authencity_token": token})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	logger.Info("User " + user.Username + " logged in with single sign-on")
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for oidc/login action. Redirects browser to OpenID Connect provider.
+// Requires the following form parameters:
+//	computername - name of the device
+//
+// After successful authentication provider redirects browser to oidc/callback.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, too long, etc.)
+//	404 - single sign-on is disabled
+//	503 - provider is unavailable
+//	302 - Redirect to provider
+func oidcLoginStart(w http.ResponseWriter, r *http.Request) {
+	if config.OIDC_ISSUER == "" {
+		handleErr(w, 404, nil, "Single sign-on is disabled")
+		return
+	}
+	computername := r.FormValue("computername")
+	if computername == "" || len(computername) > 255 {
+		handleErr(w, 400, nil, "computername not provided or too long")
+		return
+	}
+	provider := getOidcProvider()
+	if provider == nil {
+		handleErr(w, 503, nil, "OpenID Connect provider unavailable")
+		return
+	}
+	login := &oidcLogin{
+		computername: computername,
+		nonce:        toolkit.GetRandHex(32),
+		verifier:     toolkit.GetRandHex(64),
+		expires:      time.Now().Add(oidcLoginLifetime),
+	}
+	state := putOidcLogin(login)
+	http.Redirect(w, r, provider.AuthCodeURL(state, login.nonce, oidc.CodeChallenge(login.verifier)), http.StatusFound)
+}
+
+// Handler function for oidc/callback action, called by browser redirected from OpenID Connect provider.
+// Requires the following form parameters:
+//	state - value passed to provider by oidc/login
+//	code - authorization code
+//
+// If successful, returns username and authencity token.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, unknown or expired state)
+//	403 - authentication failed, no user matches the identity or account is disabled
+//	404 - single sign-on is disabled
+//	503 - provider is unavailable
+//	50x - server error processing request
+//	200 - Login successful
+func oidcCallback(w http.ResponseWriter, r *http.Request) {
+	if config.OIDC_ISSUER == "" {
+		handleErr(w, 404, nil, "Single sign-on is disabled")
+		return
+	}
+	if r.FormValue("error") != "" {
+		handleErr(w, 403, nil, "Single sign-on failed: "+r.FormValue("error"))
+		return
+	}
+	login := getOidcLogin(r.FormValue("state"), true)
+	if login == nil || login.deviceCode != "" {
+		handleErr(w, 400, nil, "Unknown or expired single sign-on state")
+		return
+	}
+	if r.FormValue("code") == "" {
+		handleErr(w, 400, nil, "code not provided")
+		return
+	}
+	provider := getOidcProvider()
+	if provider == nil {
+		handleErr(w, 503, nil, "OpenID Connect provider unavailable")
+		return
+	}
+	claims, err := provider.Exchange(r.FormValue("code"), login.verifier, login.nonce)
+	if err != nil {
+		handleErr(w, 403, err, "Unable to verify single sign-on")
+		return
+	}
+	completeOidcLogin(w, r, claims, login.computername)
+}
+
+// Handler function for oidc/device/start action. Starts device authorization flow, used by clients without browser.
+// Requires the following form parameters:
+//	computername - name of the device
+//
+// Returns device id, which should be passed to oidc/device/poll, user code which user should enter at verification uri,
+// and polling interval in seconds.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, too long, etc.)
+//	404 - single sign-on is disabled
+//	501 - provider does not support device authorization
+//	503 - provider is unavailable
+//	200 - Device authorization started
+func oidcDeviceStart(w http.ResponseWriter, r *http.Request) {
+	if config.OIDC_ISSUER == "" {
+		handleErr(w, 404, nil, "Single sign-on is disabled")
+		return
+	}
+	computername := r.FormValue("computername")
+	if computername == "" || len(computername) > 255 {
+		handleErr(w, 400, nil, "computername not provided or too long")
+		return
+	}
+	provider := getOidcProvider()
+	if provider == nil {
+		handleErr(w, 503, nil, "OpenID Connect provider unavailable")
+		return
+	}
+	authorization, err := provider.StartDeviceAuthorization()
+	if err == oidc.ErrNotSupported {
+		handleErr(w, 501, nil, "Provider does not support device authorization")
+		return
+	}
+	if err != nil {
+		handleErr(w, 503, err, "Unable to start device authorization")
+		return
+	}
+	id := putOidcLogin(&oidcLogin{
+		computername: computername,
+		deviceCode:   authorization.DeviceCode,
+		expires:      time.Now().Add(time.Duration(authorization.ExpiresIn) * time.Second),
+	})
+	respJSON, err := json.Marshal(map[string]interface{}{
+		"device_id":                 id,
+		"user_code":                 authorization.UserCode,
+		"verification_uri":          authorization.VerificationURI,
+		"verification_uri_complete": authorization.VerificationURIComplete,
+		"expires_in":                authorization.ExpiresIn,
+		"interval":                  authorization.Interval,
+	})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for oidc/device/poll action. Checks whether user completed device authorization.
+// Requires the following form parameters:
+//	device_id - id returned by oidc/device/start
+//
+// If successful, returns username and authencity token.
+//
+// HTTP codes returned:
+//	202 - user has not completed authorization yet, client should poll again after the interval
+//	400 - request invalid (missing parameter, unknown or expired device id)
+//	403 - authorization denied, no user matches the identity or account is disabled
+//	404 - single sign-on is disabled
+//	429 - client polls too often and should increase the interval
+//	503 - provider is unavailable
+//	50x - server error processing request
+//	200 - Login successful
+func oidcDevicePoll(w http.ResponseWriter, r *http.Request) {
+	if config.OIDC_ISSUER == "" {
+		handleErr(w, 404, nil, "Single sign-on is disabled")
+		return
+	}
+	id := r.FormValue("device_id")
+	login := getOidcLogin(id, false)
+	if login == nil || login.deviceCode == "" {
+		handleErr(w, 400, nil, "Unknown or expired device id")
+		return
+	}
+	provider := getOidcProvider()
+	if provider == nil {
+		handleErr(w, 503, nil, "OpenID Connect provider unavailable")
+		return
+	}
+	claims, err := provider.PollDeviceToken(login.deviceCode)
+	if err == oidc.ErrAuthorizationPending {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	if err == oidc.ErrSlowDown {
+		handleErr(w, 429, nil, "Device authorization polled too often")
+		return
+	}
+	getOidcLogin(id, true)
+	if err != nil {
+		handleErr(w, 403, err, "Device authorization failed")
+		return
+	}
+	completeOidcLogin(w, r, claims, login.computername)
+}
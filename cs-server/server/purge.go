@@ -0,0 +1,261 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/cs-server/storage"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// Number of storage blobs removed before purge job progress is stored.
+const purgeBatchSize = 100
+
+// How often purge worker checks for jobs when it was not woken up.
+const purgeCheckInterval = time.Minute
+
+// purgeWakeup wakes purge worker up when new job is created.
+var purgeWakeup = make(chan bool, 1)
+
+// Processes purge jobs in background. Jobs interrupted by server restart are resumed.
+func purgeWorker() {
+	for {
+		for {
+			job, err := db.NextPurgeJob()
+			if err != nil || job == nil {
+				break
+			}
+			runPurgeJob(job)
+		}
+		select {
+		case <-purgeWakeup:
+		case <-time.After(purgeCheckInterval):
+		}
+	}
+}
+
+// Wakes purge worker up, if it is not already processing jobs.
+func wakePurgeWorker() {
+	select {
+	case purgeWakeup <- true:
+	default:
+	}
+}
+
+// Removes contents of purged user from storage in batches, then removes all their records.
+// If error occurs, job is marked as failed and might be retried by administrator.
+func runPurgeJob(job *db.PurgeJob) {
+	logger.Infof("Purging user %s, %d of %d blobs processed", job.Username, job.ProcessedBlobs, job.TotalBlobs)
+	job.Status = db.PurgeRunning
+	fail := func(err error) {
+		logger.WithField("error", err.Error()).Error("Purge of user " + job.Username + " failed")
+		job.Status = db.PurgeFailed
+		job.Error = err.Error()
+		job.Save()
+	}
+	if err := job.Save(); err != nil {
+		fail(err)
+		return
+	}
+	// account in use must not lose its data
+	if err := job.CheckUserDisabled(); err != nil {
+		fail(err)
+		return
+	}
+	for {
+		uuids, err := job.NextBlobs(purgeBatchSize)
+		if err != nil {
+			fail(err)
+			return
+		}
+		if len(uuids) == 0 {
+			break
+		}
+		for _, uuid := range uuids {
+			shared, err := job.IsBlobShared(uuid)
+			if err != nil {
+				fail(err)
+				return
+			}
+			if !shared {
+				if err = storage.Remove(uuid); err != nil {
+					fail(err)
+					return
+				}
+				job.RemovedBlobs++
+			}
+			if err = job.RemoveBlobRevisions(uuid); err != nil {
+				fail(err)
+				return
+			}
+			job.ProcessedBlobs++
+		}
+		if err = job.Save(); err != nil {
+			fail(err)
+			return
+		}
+	}
+	if err := job.CheckUserDisabled(); err != nil {
+		fail(err)
+		return
+	}
+	if err := job.Finish(); err != nil {
+		fail(err)
+		return
+	}
+	logger.Infof("User %s purged, %d blobs removed from storage", job.Username, job.RemovedBlobs)
+}
+
+func purgeJobMap(job *db.PurgeJob) map[string]interface{} {
+	var progress float64 = 100
+	if job.TotalBlobs > 0 && job.Status != db.PurgeDone {
+		progress = float64(job.ProcessedBlobs) * 100 / float64(job.TotalBlobs)
+	}
+	return map[string]interface{}{
+		"id":              job.Id,
+		"user_id":         job.UserId,
+		"username":        job.Username,
+		"requested_by":    job.RequestedBy,
+		"status":          job.Status,
+		"total_blobs":     job.TotalBlobs,
+		"processed_blobs": job.ProcessedBlobs,
+		"removed_blobs":   job.RemovedBlobs,
+		"progress":        progress,
+		"error":           job.Error,
+		"created":         job.Created,
+		"updated":         job.Updated,
+		"finished":        job.Finished,
+	}
+}
+
+// Handler function for admin/users/delete action. Disables user and logs out all their devices immediately,
+// then removes all their files, revisions and contents not referenced by other users in background.
+// Requires the following form parameters:
+//	id - id of the user
+//
+// If successful, returns purge job, which progress might be checked with admin/purge_jobs.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect id)
+//	403 - current user is not an administrator
+//	404 - user does not exist
+//	409 - administrator tried to delete own account, or user is already being deleted (existing job is returned)
+//	50x - server error processing request
+//	202 - Deletion started
+func adminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	user := adminTargetUser(w, r)
+	if user == nil || isSelf(w, r, user) {
+		return
+	}
+	admin := context.Get(r, "user").(*db.User)
+	job, err := db.CreatePurgeJob(user, admin.Username)
+	status := 202
+	if err == db.ErrEntityAlreadyExists {
+		status = 409
+	} else if err != nil {
+		handleErr(w, 500, err, "Unable to delete user "+user.Username)
+		return
+	} else {
+		logger.Info("Deletion of user " + user.Username + " requested by administrator " + admin.Username)
+		audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "deleted user " + user.Username + ", purge job " + strconv.FormatInt(job.Id, 10)})
+		wakePurgeWorker()
+	}
+	jobJSON, err := json.Marshal(purgeJobMap(job))
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	w.WriteHeader(status)
+	fmt.Fprintf(w, string(jobJSON))
+}
+
+// Handler function for admin/purge_jobs action. Lists account deletions with their progress.
+// Accepts the following form parameters:
+//	id (optional) - return only job with given id
+//
+// HTTP codes returned:
+//	400 - request invalid (incorrect id)
+//	403 - current user is not an administrator
+//	404 - job with given id does not exist
+//	50x - server error processing request
+//	200 - Request succesful
+func adminPurgeJobs(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	var jobs []db.PurgeJob
+	if r.FormValue("id") != "" {
+		id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+		if err != nil || id == 0 {
+			handleErr(w, 400, nil, "id parameter is incorrect")
+			return
+		}
+		job := db.GetPurgeJob(id)
+		if job == nil {
+			handleErr(w, 404, nil, "Purge job "+r.FormValue("id")+" does not exist")
+			return
+		}
+		jobs = append(jobs, *job)
+	} else {
+		var err error
+		if jobs, err = db.GetPurgeJobs(); err != nil {
+			handleErr(w, 500, err, "Unable to get purge jobs")
+			return
+		}
+	}
+	jobsToReturn := make([]map[string]interface{}, len(jobs))
+	for index, job := range jobs {
+		jobsToReturn[index] = purgeJobMap(&job)
+	}
+	jobsJSON, err := json.Marshal(jobsToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(jobsJSON))
+}
+
+// Handler function for admin/purge_jobs/retry action. Resumes failed account deletion from where it stopped.
+// Requires the following form parameters:
+//	id - id of the job
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect id)
+//	403 - current user is not an administrator
+//	404 - job with given id does not exist
+//	409 - job has not failed
+//	50x - server error processing request
+//	200 - Job resumed
+func adminRetryPurgeJob(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return
+	}
+	job := db.GetPurgeJob(id)
+	if job == nil {
+		handleErr(w, 404, nil, "Purge job "+r.FormValue("id")+" does not exist")
+		return
+	}
+	if job.Status != db.PurgeFailed {
+		handleErr(w, 409, nil, "Purge job "+r.FormValue("id")+" has not failed")
+		return
+	}
+	job.Status = db.PurgePending
+	job.Error = ""
+	if err = job.Save(); err != nil {
+		handleErr(w, 500, err, "Unable to resume purge job")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAdmin, Details: "retried purge job " + r.FormValue("id") + " of user " + job.Username})
+	wakePurgeWorker()
+}
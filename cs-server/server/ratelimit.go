@@ -0,0 +1,200 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/config"
+	"cloudsyncer/cs-server/db"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// How often idle buckets and expired lockouts are removed from memory.
+const rateLimitCleanupInterval = 10 * time.Minute
+
+// tokenBucket keeps number of requests which might be made immediately. Tokens are refilled continuously
+// up to the burst size.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps token buckets for keys such as IP address or username.
+type rateLimiter struct {
+	rate        float64 // tokens per second
+	burst       float64
+	buckets     map[string]*tokenBucket
+	lastCleanup time.Time
+	mutex       sync.Mutex
+}
+
+// Creates rate limiter allowing perMinute requests per minute for each key, with bursts up to burst requests.
+// If perMinute is 0, limiter allows all requests.
+func newRateLimiter(perMinute int, burst int) *rateLimiter {
+	return &rateLimiter{
+		rate:        float64(perMinute) / 60,
+		burst:       float64(burst),
+		buckets:     make(map[string]*tokenBucket),
+		lastCleanup: time.Now(),
+	}
+}
+
+// Takes single token for given key. Returns true if request is allowed,
+// otherwise returns false and time after which the request might be retried.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.rate <= 0 {
+		return true, 0
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := time.Now()
+	if now.Sub(l.lastCleanup) > rateLimitCleanupInterval {
+		l.cleanup(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[key] = bucket
+	}
+	bucket.tokens = math.Min(l.burst, bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return false, time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return true, 0
+}
+
+// Removes buckets which are full again, they behave the same as missing ones.
+func (l *rateLimiter) cleanup(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastCleanup = now
+}
+
+// RateLimitMiddleware rejects requests exceeding the limit with 429 status code and Retry-After header.
+// Requests are grouped by key returned by keyFunc, requests with empty key are not limited.
+type RateLimitMiddleware struct {
+	limiter *rateLimiter
+	keyFunc func(r *http.Request) string
+}
+
+// Middleware serving method. Passes the execution to the next handler if request is within the limit.
+func (m *RateLimitMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	key := m.keyFunc(r)
+	if key != "" {
+		if ok, retryAfter := m.limiter.allow(key); !ok {
+			tooManyRequests(w, retryAfter, "Rate limit exceeded for "+key)
+			return
+		}
+	}
+	next(w, r)
+}
+
+// Limits requests per client IP address. Used for all endpoints.
+var ipRateLimit = &RateLimitMiddleware{
+	limiter: newRateLimiter(config.RATE_LIMIT_IP_PER_MINUTE, config.RATE_LIMIT_IP_BURST),
+	keyFunc: func(r *http.Request) string { return "ip " + remoteIP(r) },
+}
+
+// Limits requests to login and register endpoints per client IP address.
+var authRateLimit = &RateLimitMiddleware{
+	limiter: newRateLimiter(config.RATE_LIMIT_AUTH_PER_MINUTE, config.RATE_LIMIT_AUTH_BURST),
+	keyFunc: func(r *http.Request) string { return "auth " + remoteIP(r) },
+}
+
+// Limits authenticated requests per user. Must follow AuthMiddleware, so only the user whose credentials
+// were verified is limited; requests failing authentication are limited by ipRateLimit only.
+// API tokens are limited separately from sessions of their owner.
+var userRateLimit = &RateLimitMiddleware{
+	limiter: newRateLimiter(config.RATE_LIMIT_USER_PER_MINUTE, config.RATE_LIMIT_USER_BURST),
+	keyFunc: userRateLimitKey,
+}
+
+func userRateLimitKey(r *http.Request) string {
+	if apiToken, ok := context.Get(r, "api_token").(*db.ApiToken); ok {
+		return "api token " + strconv.FormatInt(apiToken.Id, 10)
+	}
+	if user, ok := context.Get(r, "user").(*db.User); ok {
+		return "user " + strconv.FormatInt(user.Id, 10)
+	}
+	return ""
+}
+
+// Limits polling of device authorization per device id, so clients ignoring the interval do not make
+// the provider throttle the server.
+var devicePollRateLimit = &RateLimitMiddleware{
+	limiter: newRateLimiter(config.RATE_LIMIT_POLL_PER_MINUTE, config.RATE_LIMIT_POLL_BURST),
+	keyFunc: func(r *http.Request) string { return "device " + r.FormValue("device_id") },
+}
+
+// Writes 429 status code with Retry-After header in seconds.
+func tooManyRequests(w http.ResponseWriter, retryAfter time.Duration, msg string) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	handleErr(w, 429, nil, msg)
+}
+
+// loginLockout keeps number of failed logins for username and time until which further attempts are rejected.
+type loginLockout struct {
+	failures    int
+	lockedUntil time.Time
+}
+
+var (
+	loginLockouts            = make(map[string]*loginLockout)
+	loginLockoutsMutex       sync.Mutex
+	loginLockoutsLastCleanup = time.Now()
+)
+
+// Returns time for which login attempts for given username are rejected, or 0 if login is allowed.
+func loginLockedFor(username string) time.Duration {
+	loginLockoutsMutex.Lock()
+	defer loginLockoutsMutex.Unlock()
+	if lockout, ok := loginLockouts[username]; ok {
+		if wait := lockout.lockedUntil.Sub(time.Now()); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// Records failed login for given username. After LOGIN_LOCKOUT_THRESHOLD failures username is locked out,
+// and the lockout doubles with each further failure, up to LOGIN_LOCKOUT_MAX seconds.
+func loginFailed(username string) {
+	loginLockoutsMutex.Lock()
+	defer loginLockoutsMutex.Unlock()
+	now := time.Now()
+	if now.Sub(loginLockoutsLastCleanup) > rateLimitCleanupInterval {
+		for key, lockout := range loginLockouts {
+			if now.Sub(lockout.lockedUntil) > config.LOGIN_LOCKOUT_MAX*time.Second {
+				delete(loginLockouts, key)
+			}
+		}
+		loginLockoutsLastCleanup = now
+	}
+	lockout, ok := loginLockouts[username]
+	if !ok {
+		lockout = new(loginLockout)
+		loginLockouts[username] = lockout
+	}
+	lockout.failures++
+	if lockout.failures < config.LOGIN_LOCKOUT_THRESHOLD {
+		return
+	}
+	seconds := float64(config.LOGIN_LOCKOUT_BASE) * math.Pow(2, float64(lockout.failures-config.LOGIN_LOCKOUT_THRESHOLD))
+	lockout.lockedUntil = now.Add(time.Duration(math.Min(seconds, config.LOGIN_LOCKOUT_MAX)) * time.Second)
+	logger.Warningf("User %s locked out after %d failed logins until %s", username, lockout.failures, lockout.lockedUntil)
+}
+
+// Clears failed logins of given username after successful login.
+func loginSucceeded(username string) {
+	loginLockoutsMutex.Lock()
+	defer loginLockoutsMutex.Unlock()
+	delete(loginLockouts, username)
+}
@@ -0,0 +1,129 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Sirupsen/logrus"
+	"github.com/gorilla/context"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	l := newRateLimiter(60, 3)
+	tests := []struct {
+		key     string
+		elapsed time.Duration
+		allowed bool
+	}{
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false},
+		{"b", 0, true},
+		{"a", 500 * time.Millisecond, false},
+		{"a", 500 * time.Millisecond, true},
+		{"a", 0, false},
+		{"a", time.Hour, true},
+		{"a", 0, true},
+		{"a", 0, true},
+		{"a", 0, false},
+	}
+	for i, test := range tests {
+		// move the clock instead of sleeping
+		for _, bucket := range l.buckets {
+			bucket.last = bucket.last.Add(-test.elapsed)
+		}
+		allowed, retryAfter := l.allow(test.key)
+		if allowed != test.allowed {
+			t.Errorf("request %d for %s: allowed = %v, expected %v", i, test.key, allowed, test.allowed)
+		}
+		if !allowed && (retryAfter <= 0 || retryAfter > time.Second) {
+			t.Errorf("request %d for %s: retry after %s, expected at most 1s", i, test.key, retryAfter)
+		}
+	}
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	l := newRateLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		if allowed, _ := l.allow("a"); !allowed {
+			t.Fatalf("disabled limiter rejected request %d", i)
+		}
+	}
+}
+
+func TestRateLimiterCleanup(t *testing.T) {
+	l := newRateLimiter(60, 3)
+	l.allow("idle")
+	l.allow("busy")
+	l.allow("busy")
+	l.allow("busy")
+	now := time.Now()
+	l.buckets["idle"].last = now.Add(-10 * time.Second)
+	l.cleanup(now)
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("full bucket was not removed")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("empty bucket was removed")
+	}
+}
+
+func TestUserRateLimitKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     *db.User
+		apiToken *db.ApiToken
+		expected string
+	}{
+		{"unauthenticated", nil, nil, ""},
+		{"session", &db.User{Id: 5, Username: "john"}, nil, "user 5"},
+		{"api token", &db.User{Id: 5, Username: "john"}, &db.ApiToken{Id: 7}, "api token 7"},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest("GET", "/metadata/a.txt", nil)
+		// claimed username must not select the bucket
+		r.Header.Set("X-Cloudsyncer-Username", "victim")
+		if test.user != nil {
+			context.Set(r, "user", test.user)
+		}
+		if test.apiToken != nil {
+			context.Set(r, "api_token", test.apiToken)
+		}
+		if key := userRateLimitKey(r); key != test.expected {
+			t.Errorf("%s: userRateLimitKey = %q, expected %q", test.name, key, test.expected)
+		}
+		context.Clear(r)
+	}
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	SetLogger(logrus.New())
+	m := &RateLimitMiddleware{
+		limiter: newRateLimiter(60, 1),
+		keyFunc: func(r *http.Request) string { return r.FormValue("device_id") },
+	}
+	tests := []struct {
+		deviceId string
+		status   int
+	}{
+		{"a", 200},
+		{"a", 429},
+		{"b", 200},
+		{"", 200},
+		{"", 200},
+	}
+	for i, test := range tests {
+		w := httptest.NewRecorder()
+		m.ServeHTTP(w, httptest.NewRequest("POST", "/oidc/device/poll?device_id="+test.deviceId, nil), func(w http.ResponseWriter, r *http.Request) {})
+		if w.Code != test.status {
+			t.Errorf("request %d: status %d, expected %d", i, w.Code, test.status)
+		}
+		if test.status == 429 && w.Header().Get("Retry-After") != "1" {
+			t.Errorf("request %d: Retry-After = %q, expected 1", i, w.Header().Get("Retry-After"))
+		}
+	}
+}
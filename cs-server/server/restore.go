@@ -0,0 +1,117 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/toolkit"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+)
+
+// Handler function for restore action. Rolls folder back to its state at given time in one operation:
+// files removed since then are undeleted, changed files get new revision with their contents at that time
+// and files created later are removed. Nothing is changed with dry_run, only the changes are returned.
+// Mount points of shared folders inside the folder are left as they are.
+// Requires the following form parameters:
+//	path - folder to restore, "/" restores whole namespace
+//	as_of - unix timestamp to restore the folder to
+//	dry_run (optional) - "true" to only return changes which would be made
+//
+// If successful, returns list of changes, each with action ("undelete", "revert" or "remove"), path, name,
+// is_dir, rev (revision restored, 0 for removals) and size. API tokens need delete permission to remove files.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter, path was not a folder)
+//	403 - shared folder is read-only, or API token does not allow removing files
+//	404 - folder did not exist at given time
+//	507 - storage quota exceeded
+//	50x - server error processing request
+//	200 - Folder restored, or changes returned for dry run
+func restore(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	if r.FormValue("path") == "" {
+		handleErr(w, 400, nil, "path not provided")
+		return
+	}
+	if r.FormValue("as_of") == "" {
+		handleErr(w, 400, nil, "as_of not provided")
+		return
+	}
+	asOf, ok := parseAsOf(w, r)
+	if !ok {
+		return
+	}
+	dryRun := r.FormValue("dry_run") == "true" || r.FormValue("dry_run") == "1"
+	path := toolkit.CleanPath(r.FormValue("path"))
+	location := resolvePath(w, r, path, false)
+	if location == nil {
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	if !dryRun && !location.CanWrite() {
+		handleErr(w, 403, db.ErrReadOnlyShare, "User "+user.Username+" can not restore "+path)
+		return
+	}
+	changes, err := location.Owner.PlanRestore(location.Path, asOf)
+	switch err {
+	case nil:
+	case db.ErrNotFolder:
+		handleErr(w, 400, err, "Unable to restore "+path)
+		return
+	case db.ErrEntityNotExists:
+		handleErr(w, 404, err, "Unable to restore "+path+" as of "+asOf.String())
+		return
+	default:
+		handleErr(w, 500, err, "Unable to restore "+path)
+		return
+	}
+	var added, replaced int64
+	removes := false
+	changesToReturn := make([]map[string]interface{}, len(changes))
+	for index, change := range changes {
+		added += change.Size
+		replaced += change.Replaced
+		removes = removes || change.Action == db.RestoreRemove
+		changesToReturn[index] = map[string]interface{}{
+			"action": change.Action,
+			"path":   location.Translate(change.Path),
+			"name":   change.Name,
+			"is_dir": change.IsDir,
+			"rev":    change.Rev,
+			"size":   change.Size,
+		}
+	}
+	if !dryRun {
+		if apiToken, ok := context.Get(r, "api_token").(*db.ApiToken); ok && removes && !apiToken.HasPermission(db.PermDelete) {
+			handleErr(w, 403, nil, "API token "+apiToken.Name+" does not allow removing files")
+			return
+		}
+		if !checkQuota(w, location.Owner, added, replaced) {
+			return
+		}
+		session := context.Get(r, "session").(*db.Session)
+		applied, unmounted, err := location.Owner.ApplyRestore(changes)
+		for _, metadata := range applied {
+			notifyChange(location.Owner, session.Token, &metadata)
+		}
+		notifyUnmounted(unmounted)
+		if err != nil {
+			handleErr(w, 500, err, "Unable to restore "+path)
+			return
+		}
+		audit(r, db.AuditEvent{Action: db.AuditRestore, Path: path,
+			Details: "restored as of " + asOf.UTC().Format("2006-01-02 15:04:05") + ", " + strconv.Itoa(len(applied)) + " changes"})
+	}
+	respJSON, err := json.Marshal(map[string]interface{}{"dry_run": dryRun, "as_of": asOf.Unix(), "changes": changesToReturn})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
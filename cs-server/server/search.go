@@ -0,0 +1,159 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/toolkit"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/context"
+)
+
+// Returns locations to search for files in folder of the user: the folder itself and shared folders mounted inside it.
+// If folder is inside shared folder, only that shared folder is searched.
+func searchLocations(user *db.User, folder string) ([]*db.Location, error) {
+	location, err := user.Resolve(folder)
+	if err != nil {
+		return nil, err
+	}
+	locations := []*db.Location{location}
+	if location.Mount != nil {
+		return locations, nil
+	}
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, mount := range mounts {
+		if !isUnderFolder(toolkit.NormalizePath(mount.MountPath), folder) {
+			continue
+		}
+		mountLocation, err := user.Resolve(mount.MountPath)
+		if err != nil {
+			return nil, err
+		}
+		locations = append(locations, mountLocation)
+	}
+	return locations, nil
+}
+
+// Returns true if path is given folder or is inside it.
+func isUnderFolder(path string, folder string) bool {
+	return path == folder || strings.HasPrefix(path, strings.TrimSuffix(folder, "/")+"/")
+}
+
+// Parses non-negative integer form parameter. Returns 0 if parameter is empty.
+// Writes 400 error and returns false if parameter is incorrect.
+func parseIntParam(w http.ResponseWriter, r *http.Request, name string) (int64, bool) {
+	if r.FormValue(name) == "" {
+		return 0, true
+	}
+	value, err := strconv.ParseInt(r.FormValue(name), 10, 64)
+	if err != nil || value < 0 {
+		handleErr(w, 400, nil, name+" parameter is incorrect")
+		return 0, false
+	}
+	return value, true
+}
+
+// Handler function for search action. Finds files by name, extension, size and modification time
+// in given folder and its subfolders, including shared folders mounted inside it. Folders are not returned.
+// Accepts the following form parameters:
+//	path (optional) - folder to search in, "/" (default) searches whole namespace
+//	name (optional) - case insensitive substring of file name, or glob pattern matching whole name if it contains * or ?
+//	ext (optional) - file extension, with or without leading dot
+//	min_size, max_size (optional) - size range in bytes
+//	modified_after, modified_before (optional) - unix timestamps, range of modification time
+//	limit (optional) - maximum number of files, 100 by default, at most 1000
+//	cursor (optional) - cursor returned by previous request, to get next page
+//
+// If successful, returns list of metadata of found files and cursor of the next page, which is empty
+// if there are no more files.
+//
+// HTTP codes returned:
+//	400 - request invalid (incorrect parameter)
+//	50x - server error processing request
+//	200 - Request succesful
+func search(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	path := "/"
+	if r.FormValue("path") != "" {
+		path = toolkit.CleanPath("/" + r.FormValue("path"))
+	}
+	query := db.SearchQuery{Name: r.FormValue("name"), Extension: r.FormValue("ext"), Limit: listDefaultLimit}
+	params := map[string]int64{"min_size": 0, "max_size": 0, "modified_after": 0, "modified_before": 0, "limit": 0, "cursor": 0}
+	for name := range params {
+		value, ok := parseIntParam(w, r, name)
+		if !ok {
+			return
+		}
+		params[name] = value
+	}
+	query.MinSize, query.MaxSize, query.AfterId = params["min_size"], params["max_size"], params["cursor"]
+	if params["modified_after"] > 0 {
+		query.ModifiedAfter = time.Unix(params["modified_after"], 0)
+	}
+	if params["modified_before"] > 0 {
+		query.ModifiedBefore = time.Unix(params["modified_before"], 0)
+	}
+	if r.FormValue("limit") != "" {
+		if params["limit"] == 0 || params["limit"] > listMaxLimit {
+			handleErr(w, 400, nil, "limit parameter is incorrect")
+			return
+		}
+		query.Limit = int(params["limit"])
+	}
+	limit := query.Limit
+	// one more file is requested from each location to find out if there is next page
+	query.Limit++
+	user := context.Get(r, "user").(*db.User)
+	locations, err := searchLocations(user, path)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to search "+path)
+		return
+	}
+	var found []db.SearchResult
+	for _, location := range locations {
+		results, err := location.Owner.Search(location.Path, query)
+		if err != nil {
+			handleErr(w, 500, err, "Unable to search "+path)
+			return
+		}
+		for _, result := range results {
+			result.Path = location.Translate(result.Path)
+			found = append(found, result)
+		}
+	}
+	sort.Sort(searchById(found))
+	cursor := ""
+	if len(found) > limit {
+		found = found[:limit]
+		cursor = strconv.FormatInt(found[limit-1].Id, 10)
+	}
+	entries := make([]db.Metadata, len(found))
+	for i, result := range found {
+		entries[i] = db.Metadata{Size: result.Size, Rev: result.Rev, Name: result.Name, Modified: result.Modified,
+			Path: result.Path, Hash: result.Hash}
+	}
+	respJSON, err := json.Marshal(map[string]interface{}{"entries": entries, "cursor": cursor})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Sorts search results by file id, which is order of paging.
+type searchById []db.SearchResult
+
+func (s searchById) Len() int           { return len(s) }
+func (s searchById) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s searchById) Less(i, j int) bool { return s[i].Id < s[j].Id }
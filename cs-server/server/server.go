@@ -7,9 +7,12 @@ package server
 
 import (
 	"cloudsyncer/cs-server/db"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/Sirupsen/logrus"
 	"github.com/codegangsta/negroni"
@@ -31,13 +34,29 @@ var newChangeArrived map[int]map[string]chan int = make(map[int]map[string]chan
 // Authentication is made by setting HTTP headers. Two headers are required:
 //	X-Cloudsyncer-Authtoken - token grabbed from login endpoint
 //	X-Cloudsyncer-Username - User name
-type AuthMiddleware struct{}
+// Tokens are stored in database only as hashes, so the token from header is hashed and compared with stored sessions.
+//
+// Alternatively API token might be given in header:
+//	Authorization: Bearer <api token>
+// API tokens are accepted only by endpoints which set required permission. wholeTree is set for endpoints
+// which operate on entire file tree of the user, those accept only tokens not limited to a folder.
+type AuthMiddleware struct {
+	permission string
+	wholeTree  bool
+	pathParam  string
+}
 
 // Middleware serving method. Checks for headers and returns status codes depending on situation:
-//	403 - credentials are missing or invalid
+//	401 - session has expired, was revoked or does not exist, client should log in again
+//	403 - credentials are missing or user does not exist, or account is disabled
 //	413 - credentials length is too big
+//	429 - too many requests, set by rate limit middleware preceding this one
 // If no error is given, it passes the execution to the actual endpoint handler
 func (l *AuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		l.serveApiToken(w, r, next, strings.TrimPrefix(auth, "Bearer "))
+		return
+	}
 	token := r.Header.Get("X-Cloudsyncer-Authtoken")
 	username := r.Header.Get("X-Cloudsyncer-Username")
 
@@ -65,13 +84,24 @@ func (l *AuthMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next
 		handleErr(w, 403, nil, "Invalid credentials")
 		return
 	}
-	if session := db.GetSession(user, token); session == nil {
-		handleErr(w, 403, nil, "Invalid credentials")
+	if user.Disabled {
+		handleErr(w, 403, nil, "Account disabled for user "+username)
+		return
+	}
+	session := db.GetSession(user, token)
+	if session == nil {
+		handleErr(w, 401, nil, "Session revoked or invalid for user "+username)
+		return
+	}
+	if session.IsExpired() {
+		handleErr(w, 401, nil, "Session expired for user "+username)
 		return
-	} else {
-		context.Set(r, "session", session)
-		context.Set(r, "user", user)
 	}
+	if err := session.Touch(remoteIP(r)); err != nil {
+		logger.WithField("error", err.Error()).Error("Unable to renew session for user " + username)
+	}
+	context.Set(r, "session", session)
+	context.Set(r, "user", user)
 	next(w, r)
 
 }
@@ -91,19 +121,87 @@ func handleErr(w http.ResponseWriter, errorcode int, err error, msg string) {
 	http.Error(w, "", errorcode)
 }
 
+// Helper function for errors which should be displayed to the user. Logs error and writes error status code
+// and JSON list of errors to the response, for example:
+//	{"errors": [{"field": "password", "code": "password_too_short", "message": "Password must be at least 10 characters long"}]}
+func handlePolicyErr(w http.ResponseWriter, errorcode int, errs db.PolicyErrors) {
+	logger.Error(errs.Error())
+	respJSON, err := json.Marshal(map[string]db.PolicyErrors{"errors": errs})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(errorcode)
+	w.Write(respJSON)
+}
+
+// Returns IP address of the client which sent the request.
+func remoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
 // Sets the logger object
 func SetLogger(_logger *logrus.Logger) {
 	logger = _logger
 }
 
 // Sets AuthMiddleware on endpoint function. Used to add authentication to endpoints that need that.
+// Endpoints wrapped this way accept only sessions.
 func authWrapFunc(f func(http.ResponseWriter, *http.Request)) http.Handler {
-	return negroni.New(&AuthMiddleware{}, negroni.Wrap(http.HandlerFunc(f)))
+	return negroni.New(&AuthMiddleware{}, userRateLimit, negroni.Wrap(http.HandlerFunc(f)))
 }
 
 // Sets AuthMiddleware on endpoint handler. Used to add authentication to endpoints that need that.
+// Endpoints wrapped this way accept only sessions.
 func authWrap(h http.Handler) http.Handler {
-	return negroni.New(&AuthMiddleware{}, negroni.Wrap(h))
+	return negroni.New(&AuthMiddleware{}, userRateLimit, negroni.Wrap(h))
+}
+
+// Sets AuthMiddleware on endpoint function, accepting also API tokens with given permission.
+// pathParam is the form parameter holding path the endpoint operates on, empty if the path is part of the URL.
+// Path of API token is checked against the same parameter.
+func scopedWrapFunc(f func(http.ResponseWriter, *http.Request), permission string, pathParam string) http.Handler {
+	return negroni.New(&AuthMiddleware{permission: permission, pathParam: pathParam}, userRateLimit, negroni.Wrap(http.HandlerFunc(f)))
+}
+
+// Sets AuthMiddleware on endpoint handler operating on entire file tree, accepting also API tokens with given permission
+// which are not limited to a folder.
+func treeWrap(h http.Handler, permission string) http.Handler {
+	return negroni.New(&AuthMiddleware{permission: permission, wholeTree: true}, userRateLimit, negroni.Wrap(h))
+}
+
+// AdminMiddleware allows the request only if user authenticated by preceding AuthMiddleware is an administrator.
+type AdminMiddleware struct{}
+
+// Middleware serving method. Returns 403 if current user is not an administrator.
+func (l *AdminMiddleware) ServeHTTP(w http.ResponseWriter, r *http.Request, next http.HandlerFunc) {
+	user := context.Get(r, "user").(*db.User)
+	if !user.IsAdmin() {
+		handleErr(w, 403, nil, "User "+user.Username+" is not an administrator")
+		return
+	}
+	next(w, r)
+}
+
+// Sets AuthMiddleware and AdminMiddleware on endpoint function. Used for endpoints available only to administrators.
+// Endpoints wrapped this way accept only sessions.
+func adminWrapFunc(f func(http.ResponseWriter, *http.Request)) http.Handler {
+	return negroni.New(&AuthMiddleware{}, userRateLimit, &AdminMiddleware{}, negroni.Wrap(http.HandlerFunc(f)))
+}
+
+// Sets rate limit for login attempts on endpoint function. Used for endpoints which check credentials or create users.
+func authLimitWrapFunc(f func(http.ResponseWriter, *http.Request)) http.Handler {
+	return negroni.New(authRateLimit, negroni.Wrap(http.HandlerFunc(f)))
+}
+
+// Sets rate limit for device authorization polling on endpoint function.
+func pollLimitWrapFunc(f func(http.ResponseWriter, *http.Request)) http.Handler {
+	return negroni.New(devicePollRateLimit, negroni.Wrap(http.HandlerFunc(f)))
 }
 
 // main entry of the package. Initializes handlers, adds middlewares and starts http server
@@ -112,24 +210,80 @@ func Serve(address string, port int) error {
 	fmt.Printf("start\n")
 	router := mux.NewRouter()
 
-	router.HandleFunc("/register", register)
-	router.HandleFunc("/login", login)
-	router.Handle("/delta", authWrapFunc(delta)).Methods("POST")
-	router.Handle("/longpoll_delta", authWrapFunc(longpoll_delta)).Methods("GET")
-	router.Handle("/changes", authWrap(wsHandler()))
-	router.Handle("/revisions/{filepath:.*}", authWrapFunc(revisions))
-	router.Handle("/metadata/{filepath:[^\\/].*}", authWrapFunc(metadata))
-	router.Handle("/files/{filepath:.*}", authWrapFunc(file)).Methods("GET")
-	router.Handle("/files_put/{filepath:.*}", authWrapFunc(upload)).Methods("PUT")
-	router.Handle("/create_folder", authWrapFunc(createFolder)).Methods("POST")
-	router.Handle("/remove", authWrapFunc(remove)).Methods("POST")
-	router.Handle("/check_upload", authWrapFunc(check_upload)).Methods("POST")
+	router.Handle("/register", authLimitWrapFunc(register))
+	router.Handle("/login", authLimitWrapFunc(login))
+	router.Handle("/oidc/login", authLimitWrapFunc(oidcLoginStart)).Methods("GET")
+	router.Handle("/oidc/callback", authLimitWrapFunc(oidcCallback)).Methods("GET")
+	router.Handle("/oidc/device/start", authLimitWrapFunc(oidcDeviceStart)).Methods("POST")
+	router.Handle("/oidc/device/poll", pollLimitWrapFunc(oidcDevicePoll)).Methods("POST")
+	router.HandleFunc("/public/{token}", publicLink).Methods("GET", "POST")
+	router.HandleFunc("/file_requests/upload/{token}", fileRequestInfo).Methods("GET")
+	router.HandleFunc("/file_requests/upload/{token}/{filename}", fileRequestUpload).Methods("PUT")
+	router.Handle("/delta", treeWrap(http.HandlerFunc(delta), db.PermRead)).Methods("POST")
+	router.Handle("/longpoll_delta", treeWrap(http.HandlerFunc(longpoll_delta), db.PermRead)).Methods("GET")
+	router.Handle("/changes", treeWrap(wsHandler(), db.PermRead))
+	router.Handle("/revisions/{filepath:.*}", scopedWrapFunc(revisions, db.PermRead, ""))
+	router.Handle("/metadata/{filepath:[^\\/].*}", scopedWrapFunc(metadata, db.PermRead, ""))
+	router.Handle("/list/{filepath:.*}", scopedWrapFunc(list, db.PermRead, "")).Methods("GET")
+	router.Handle("/files/{filepath:.*}", scopedWrapFunc(file, db.PermRead, "")).Methods("GET")
+	router.Handle("/files_put/{filepath:.*}", scopedWrapFunc(upload, db.PermWrite, "")).Methods("PUT")
+	router.Handle("/create_folder", scopedWrapFunc(createFolder, db.PermWrite, "path")).Methods("POST")
+	router.Handle("/remove", scopedWrapFunc(remove, db.PermDelete, "path")).Methods("POST")
+	router.Handle("/check_upload", scopedWrapFunc(check_upload, db.PermWrite, "filepath")).Methods("POST")
+	router.Handle("/restore", scopedWrapFunc(restore, db.PermWrite, "path")).Methods("POST")
+	router.Handle("/search", scopedWrapFunc(search, db.PermRead, "path")).Methods("GET")
+	router.Handle("/sessions", authWrapFunc(sessions)).Methods("GET")
+	router.Handle("/sessions/revoke", authWrapFunc(revokeSession)).Methods("POST")
+	router.Handle("/sessions/revoke_others", authWrapFunc(revokeOtherSessions)).Methods("POST")
+	router.Handle("/tokens", authWrapFunc(apiTokens)).Methods("GET")
+	router.Handle("/tokens", authWrapFunc(createApiToken)).Methods("POST")
+	router.Handle("/tokens/revoke", authWrapFunc(revokeApiToken)).Methods("POST")
+	router.Handle("/shares", authWrapFunc(shares)).Methods("GET")
+	router.Handle("/shares", authWrapFunc(createShare)).Methods("POST")
+	router.Handle("/shares/members", authWrapFunc(addShareMember)).Methods("POST")
+	router.Handle("/shares/members/access", authWrapFunc(setShareAccess)).Methods("POST")
+	router.Handle("/shares/members/remove", authWrapFunc(removeShareMember)).Methods("POST")
+	router.Handle("/shares/leave", authWrapFunc(leaveShare)).Methods("POST")
+	router.Handle("/shares/unshare", authWrapFunc(unshare)).Methods("POST")
+	router.Handle("/teams", authWrapFunc(teams)).Methods("GET")
+	router.Handle("/teams", authWrapFunc(createTeam)).Methods("POST")
+	router.Handle("/teams/members", authWrapFunc(addTeamMember)).Methods("POST")
+	router.Handle("/teams/members/role", authWrapFunc(setTeamRole)).Methods("POST")
+	router.Handle("/teams/members/remove", authWrapFunc(removeTeamMember)).Methods("POST")
+	router.Handle("/teams/delete", authWrapFunc(deleteTeam)).Methods("POST")
+	router.Handle("/links", authWrapFunc(links)).Methods("GET")
+	router.Handle("/links", authWrapFunc(createLink)).Methods("POST")
+	router.Handle("/links/revoke", authWrapFunc(revokeLink)).Methods("POST")
+	router.Handle("/file_requests", authWrapFunc(fileRequests)).Methods("GET")
+	router.Handle("/file_requests", authWrapFunc(createFileRequest)).Methods("POST")
+	router.Handle("/file_requests/revoke", authWrapFunc(revokeFileRequest)).Methods("POST")
+	router.Handle("/2fa/enroll", authWrapFunc(enrollTotp)).Methods("POST")
+	router.Handle("/2fa/verify", authWrapFunc(verifyTotp)).Methods("POST")
+	router.Handle("/2fa/disable", authWrapFunc(disableTotp)).Methods("POST")
+	router.Handle("/admin/users", adminWrapFunc(adminUsers)).Methods("GET")
+	router.Handle("/admin/users", adminWrapFunc(adminCreateUser)).Methods("POST")
+	router.Handle("/admin/users/disable", adminWrapFunc(adminSetDisabled(true))).Methods("POST")
+	router.Handle("/admin/users/enable", adminWrapFunc(adminSetDisabled(false))).Methods("POST")
+	router.Handle("/admin/users/delete", adminWrapFunc(adminDeleteUser)).Methods("POST")
+	router.Handle("/admin/users/password", adminWrapFunc(adminResetPassword)).Methods("POST")
+	router.Handle("/admin/users/revoke_sessions", adminWrapFunc(adminRevokeSessions)).Methods("POST")
+	router.Handle("/admin/users/quota", adminWrapFunc(adminSetQuota)).Methods("POST")
+	router.Handle("/admin/users/role", adminWrapFunc(adminSetRole)).Methods("POST")
+	router.Handle("/admin/stats", adminWrapFunc(adminStats)).Methods("GET")
+	router.Handle("/admin/audit", adminWrapFunc(adminAudit)).Methods("GET")
+	router.Handle("/admin/purge_jobs", adminWrapFunc(adminPurgeJobs)).Methods("GET")
+	router.Handle("/admin/purge_jobs/retry", adminWrapFunc(adminRetryPurgeJob)).Methods("POST")
+	router.Handle("/admin/invites", adminWrapFunc(invites)).Methods("GET")
+	router.Handle("/admin/invites", adminWrapFunc(createInvite)).Methods("POST")
+	router.Handle("/admin/invites/revoke", adminWrapFunc(revokeInvite)).Methods("POST")
 	logMiddleware := negronilogrus.NewMiddleware()
 	logMiddleware.Logger = logger
 	negroni := negroni.New()
 	negroni.Use(logMiddleware)
+	negroni.Use(ipRateLimit)
 	negroni.UseHandler(router)
 	go wsListen()
+	go purgeWorker()
 	http.ListenAndServe(address+":"+strconv.Itoa(port), context.ClearHandler(negroni))
 	return nil
 }
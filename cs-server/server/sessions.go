@@ -0,0 +1,101 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+)
+
+// Handler function for sessions action. Lists devices logged in to the account of current user.
+// Returns list of sessions, each containing id, computer name, creation time, last seen time and IP address.
+// Session used to make the request is marked as current. Tokens are never returned.
+//
+// HTTP codes returned:
+//	50x - server error processing request
+//	200 - Request succesful
+func sessions(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	current := context.Get(r, "session").(*db.Session)
+	userSessions, err := user.GetSessions()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get sessions for user "+user.Username)
+		return
+	}
+	sessionsToReturn := make([]map[string]interface{}, len(userSessions))
+	for index, session := range userSessions {
+		sessionsToReturn[index] = map[string]interface{}{
+			"id":           session.Id,
+			"computername": session.ComputerName,
+			"created":      session.Created,
+			"last_seen":    session.LastSeen,
+			"expires":      session.Expires,
+			"ip":           session.Ip,
+			"current":      session.Id == current.Id,
+		}
+	}
+	sessionsJSON, err := json.Marshal(sessionsToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(sessionsJSON))
+}
+
+// Handler function for sessions/revoke action. Logs out single device.
+// Requires the following form parameters:
+//	id - id of session to revoke, as returned by sessions action
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, etc.)
+//	404 - session does not exist
+//	50x - server error processing request
+//	200 - Session revoked
+func revokeSession(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return
+	}
+	err = user.RevokeSession(id)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, nil, "session does not exist")
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to revoke session")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAccount, Details: "revoked session " + r.FormValue("id")})
+}
+
+// Handler function for sessions/revoke_others action. Logs out all devices except the one making the request.
+// Returns number of revoked sessions.
+//
+// HTTP codes returned:
+//	50x - server error processing request
+//	200 - Sessions revoked
+func revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	session := context.Get(r, "session").(*db.Session)
+	revoked, err := user.RevokeOtherSessions(session)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to revoke sessions")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAccount, Details: "revoked " + strconv.FormatInt(revoked, 10) + " other sessions"})
+	respJSON, err := json.Marshal(map[string]int64{"revoked": revoked})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
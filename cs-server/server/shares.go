@@ -0,0 +1,412 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+)
+
+// Resolves path requested by current user to location in namespace of its owner, so paths inside mounted shared folders
+// are served from namespace of the share owner. Writes error to the response and returns nil if path can not be resolved,
+// or if write is true and user is not allowed to modify files at that location.
+func resolvePath(w http.ResponseWriter, r *http.Request, path string, write bool) *db.Location {
+	user := context.Get(r, "user").(*db.User)
+	location, err := user.Resolve(path)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to resolve path "+path)
+		return nil
+	}
+	if write && !location.CanWrite() {
+		handleErr(w, 403, db.ErrReadOnlyShare, "User "+user.Username+" can not modify "+path)
+		return nil
+	}
+	if write && location.IsMountPoint() {
+		handleErr(w, 403, nil, "Mount point "+path+" of shared folder can not be modified")
+		return nil
+	}
+	return location
+}
+
+// Notifies sessions of the owner and of all members of shared folders containing changed file.
+// Metadata path is translated to mount point of each member.
+func notifyChange(owner *db.User, curToken string, metadata *db.Metadata) {
+	sendUpdate(owner.Id, curToken)
+	sendUpdateWS(owner.Id, curToken, *metadata)
+	mounts, err := owner.GetMountsOf(metadata.Path)
+	if err != nil {
+		logger.WithField("error", err.Error()).Error("Unable to notify members of shared folders of user " + owner.Username)
+		return
+	}
+	for _, mount := range mounts {
+		memberMetadata := *metadata
+		memberMetadata.Path = (&db.Location{Mount: &mount}).Translate(metadata.Path)
+		sendUpdate(mount.UserId, curToken)
+		sendUpdateWS(mount.UserId, curToken, memberMetadata)
+	}
+}
+
+// Notifies sessions of members that their mount points were removed.
+func notifyUnmounted(members []db.ShareMember) {
+	for _, member := range members {
+		user := db.GetUserById(member.UserId)
+		if user == nil {
+			continue
+		}
+		file, err := user.GetFileByPath(member.MountPath)
+		if err != nil || file == nil {
+			continue
+		}
+		if metadata, err := file.GetMetadata(nil); err == nil {
+			notifyChange(user, "", metadata)
+		}
+	}
+}
+
+// Returns share given by id form parameter, if it is owned by current user. Writes error to the response and returns nil
+// if parameter is incorrect or share does not exist.
+func ownedShare(w http.ResponseWriter, r *http.Request) *db.Share {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return nil
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return nil
+	}
+	user := context.Get(r, "user").(*db.User)
+	share := db.GetShare(id)
+	if share == nil || share.OwnerId != user.Id {
+		handleErr(w, 404, nil, "Share "+r.FormValue("id")+" does not exist for user "+user.Username)
+		return nil
+	}
+	return share
+}
+
+// Returns member given by username form parameter. Writes error to the response and returns nil if user does not exist.
+// Team accounts are not returned, teams can not be members of shared folders or other teams.
+func shareMemberUser(w http.ResponseWriter, r *http.Request) *db.User {
+	if r.FormValue("username") == "" {
+		handleErr(w, 400, nil, "username not provided")
+		return nil
+	}
+	member := db.GetUser(r.FormValue("username"))
+	if member == nil || member.IsTeam() {
+		handleErr(w, 404, nil, "User "+r.FormValue("username")+" does not exist")
+		return nil
+	}
+	return member
+}
+
+func shareMap(share *db.Share) (map[string]interface{}, error) {
+	members, err := share.GetMembers()
+	if err != nil {
+		return nil, err
+	}
+	membersToReturn := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
+		username := ""
+		if user := db.GetUserById(member.UserId); user != nil {
+			username = user.Username
+		}
+		membersToReturn = append(membersToReturn, map[string]interface{}{
+			"username": username,
+			"access":   member.Access,
+			"joined":   member.Created,
+		})
+	}
+	return map[string]interface{}{
+		"id":      share.Id,
+		"path":    share.Path,
+		"created": share.Created,
+		"members": membersToReturn,
+	}, nil
+}
+
+// Handler function for shares action. Lists folders shared by current user with their members,
+// and shared folders of other users mounted in namespace of current user.
+//
+// HTTP codes returned:
+//	50x - server error processing request
+//	200 - Request succesful
+func shares(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	shares, err := user.GetShares()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get shares of user "+user.Username)
+		return
+	}
+	sharesToReturn := make([]map[string]interface{}, len(shares))
+	for index, share := range shares {
+		if sharesToReturn[index], err = shareMap(&share); err != nil {
+			handleErr(w, 500, err, "Unable to get members of share "+share.Path)
+			return
+		}
+	}
+	mounts, err := user.GetMounts()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get mounts of user "+user.Username)
+		return
+	}
+	mountsToReturn := make([]map[string]interface{}, len(mounts))
+	for index, mount := range mounts {
+		owner := ""
+		if user := db.GetUserById(mount.OwnerId); user != nil {
+			owner = user.Username
+		}
+		mountsToReturn[index] = map[string]interface{}{
+			"id":     mount.ShareId,
+			"owner":  owner,
+			"path":   mount.MountPath,
+			"access": mount.Access,
+		}
+	}
+	respJSON, err := json.Marshal(map[string]interface{}{"shared": sharesToReturn, "mounted": mountsToReturn})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for sharing a folder. Shared folder has no members until they are added with shares/members.
+// Requires the following form parameters:
+//	path - path of the folder
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, path is not a folder)
+//	404 - folder does not exist
+//	409 - folder is inside or contains other shared folder or mounted shared folder
+//	50x - server error processing request
+//	200 - Folder shared, returns the share
+func createShare(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	if r.FormValue("path") == "" {
+		handleErr(w, 400, nil, "path not provided")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	share, err := user.ShareFolder(r.FormValue("path"))
+	switch err {
+	case nil:
+	case db.ErrNotFolder:
+		handleErr(w, 400, err, "Unable to share "+r.FormValue("path"))
+		return
+	case db.ErrEntityNotExists:
+		handleErr(w, 404, err, "Unable to share "+r.FormValue("path"))
+		return
+	case db.ErrNestedShare:
+		handleErr(w, 409, err, "Unable to share "+r.FormValue("path"))
+		return
+	default:
+		handleErr(w, 500, err, "Unable to share "+r.FormValue("path"))
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Path: share.Path, Details: "shared folder, share " + strconv.FormatInt(share.Id, 10)})
+	shareJSON, err := shareMap(share)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get members of share "+share.Path)
+		return
+	}
+	respJSON, err := json.Marshal(shareJSON)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for shares/members action. Adds user to shared folder, which is mounted in root of their namespace.
+// Requires the following form parameters:
+//	id - id of the share
+//	username - user to add
+//	access - "read" or "write"
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	404 - share or user does not exist
+//	409 - user is already a member or the owner
+//	50x - server error processing request
+//	200 - Member added
+func addShareMember(w http.ResponseWriter, r *http.Request) {
+	share := ownedShare(w, r)
+	if share == nil {
+		return
+	}
+	member := shareMemberUser(w, r)
+	if member == nil {
+		return
+	}
+	access := r.FormValue("access")
+	if access != db.ShareRead && access != db.ShareWrite {
+		handleErr(w, 400, nil, "access parameter is incorrect")
+		return
+	}
+	shareMember, err := share.AddMember(member, access)
+	if err == db.ErrEntityAlreadyExists {
+		handleErr(w, 409, err, "User "+member.Username+" is already a member of share "+share.Path)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to add user "+member.Username+" to share "+share.Path)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Path: share.Path, Details: "added member " + member.Username + " with " + access + " access"})
+	if file, err := member.GetFileByPath(shareMember.MountPath); err == nil && file != nil {
+		if metadata, err := file.GetMetadata(nil); err == nil {
+			notifyChange(member, "", metadata)
+		}
+	}
+}
+
+// Handler function for shares/members/access action. Changes access level of shared folder member.
+// Requires the following form parameters:
+//	id - id of the share
+//	username - member
+//	access - "read" or "write"
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	404 - share or member does not exist
+//	50x - server error processing request
+//	200 - Access changed
+func setShareAccess(w http.ResponseWriter, r *http.Request) {
+	share := ownedShare(w, r)
+	if share == nil {
+		return
+	}
+	member := shareMemberUser(w, r)
+	if member == nil {
+		return
+	}
+	access := r.FormValue("access")
+	if access != db.ShareRead && access != db.ShareWrite {
+		handleErr(w, 400, nil, "access parameter is incorrect")
+		return
+	}
+	err := share.SetAccess(member.Id, access)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, err, "User "+member.Username+" is not a member of share "+share.Path)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to change access of user "+member.Username+" to share "+share.Path)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Path: share.Path, Details: "changed access of member " + member.Username + " to " + access})
+}
+
+// Handler function for shares/members/remove action. Removes user from shared folder,
+// its mount point is removed from their namespace.
+// Requires the following form parameters:
+//	id - id of the share
+//	username - member to remove
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	404 - share or member does not exist
+//	50x - server error processing request
+//	200 - Member removed
+func removeShareMember(w http.ResponseWriter, r *http.Request) {
+	share := ownedShare(w, r)
+	if share == nil {
+		return
+	}
+	member := shareMemberUser(w, r)
+	if member == nil {
+		return
+	}
+	removeMember(w, r, share, member)
+}
+
+// Handler function for shares/leave action. Removes current user from shared folder mounted in their namespace.
+// Removing the mount point with remove action has the same effect.
+// Requires the following form parameters:
+//	id - id of the share
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	404 - share does not exist or current user is not a member
+//	409 - shared folder belongs to a team, team should be left instead
+//	50x - server error processing request
+//	200 - Shared folder left
+func leaveShare(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return
+	}
+	share := db.GetShare(id)
+	if share == nil {
+		handleErr(w, 404, nil, "Share "+r.FormValue("id")+" does not exist")
+		return
+	}
+	if db.IsTeamShare(share.Id) {
+		handleErr(w, 409, nil, "Share "+r.FormValue("id")+" belongs to a team")
+		return
+	}
+	removeMember(w, r, share, context.Get(r, "user").(*db.User))
+}
+
+// Removes member from the share and notifies their sessions. Writes error to the response if removal failed.
+func removeMember(w http.ResponseWriter, r *http.Request, share *db.Share, member *db.User) {
+	members, err := share.GetMembers()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get members of share "+share.Path)
+		return
+	}
+	err = share.RemoveMember(member.Id)
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 404, err, "User "+member.Username+" is not a member of share "+share.Path)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to remove user "+member.Username+" from share "+share.Path)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Path: share.Path, Details: "removed member " + member.Username})
+	for _, m := range members {
+		if m.UserId == member.Id {
+			notifyUnmounted([]db.ShareMember{m})
+		}
+	}
+}
+
+// Handler function for shares/unshare action. Stops sharing the folder, it is removed from namespaces of all members.
+// Files stay in namespace of the owner.
+// Requires the following form parameters:
+//	id - id of the share
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	404 - share does not exist
+//	50x - server error processing request
+//	200 - Folder unshared
+func unshare(w http.ResponseWriter, r *http.Request) {
+	share := ownedShare(w, r)
+	if share == nil {
+		return
+	}
+	members, err := share.GetMembers()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get members of share "+share.Path)
+		return
+	}
+	if err = share.Delete(); err != nil {
+		handleErr(w, 500, err, "Unable to unshare "+share.Path)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Path: share.Path, Details: "unshared folder, share " + strconv.FormatInt(share.Id, 10)})
+	notifyUnmounted(members)
+}
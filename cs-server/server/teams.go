@@ -0,0 +1,333 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/context"
+)
+
+// Returns team given by id form parameter, if current user is its member. If owner is true, current user must be owner
+// of the team. Writes error to the response and returns nil if parameter is incorrect, team does not exist
+// or current user is not allowed to manage it.
+func memberTeam(w http.ResponseWriter, r *http.Request, owner bool) *db.Team {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return nil
+	}
+	id, err := strconv.ParseInt(r.FormValue("id"), 10, 0)
+	if err != nil || id == 0 {
+		handleErr(w, 400, nil, "id parameter is incorrect")
+		return nil
+	}
+	user := context.Get(r, "user").(*db.User)
+	team := db.GetTeam(id)
+	if team == nil || team.GetRole(user.Id) == "" {
+		handleErr(w, 404, nil, "Team "+r.FormValue("id")+" does not exist for user "+user.Username)
+		return nil
+	}
+	if owner && team.GetRole(user.Id) != db.TeamOwner {
+		handleErr(w, 403, nil, "User "+user.Username+" is not owner of team "+team.Name)
+		return nil
+	}
+	return team
+}
+
+// Writes error of team operation to the response.
+func handleTeamErr(w http.ResponseWriter, err error, msg string) {
+	switch err {
+	case db.ErrInvalidRole:
+		handleErr(w, 400, err, msg)
+	case db.ErrEntityNotExists:
+		handleErr(w, 404, err, msg)
+	case db.ErrEntityAlreadyExists, db.ErrLastOwner:
+		handleErr(w, 409, err, msg)
+	default:
+		handleErr(w, 500, err, msg)
+	}
+}
+
+func teamMap(team *db.Team, user *db.User) (map[string]interface{}, error) {
+	members, err := team.GetMembers()
+	if err != nil {
+		return nil, err
+	}
+	membersToReturn := make([]map[string]interface{}, 0, len(members))
+	for _, member := range members {
+		username := ""
+		if memberUser := db.GetUserById(member.UserId); memberUser != nil {
+			username = memberUser.Username
+		}
+		membersToReturn = append(membersToReturn, map[string]interface{}{
+			"username": username,
+			"role":     member.Role,
+			"joined":   member.Created,
+		})
+	}
+	resp := map[string]interface{}{
+		"id":      team.Id,
+		"name":    team.Name,
+		"role":    team.GetRole(user.Id),
+		"created": team.Created,
+		"members": membersToReturn,
+	}
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return nil, err
+	}
+	for _, mount := range mounts {
+		if mount.ShareId == team.ShareId {
+			resp["path"] = mount.MountPath
+		}
+	}
+	if account := team.Account(); account != nil {
+		usage, err := account.GetUsage()
+		if err != nil {
+			return nil, err
+		}
+		resp["quota"] = account.GetQuota()
+		resp["usage"] = usage
+	}
+	return resp, nil
+}
+
+// Writes team as JSON to the response.
+func writeTeam(w http.ResponseWriter, team *db.Team, user *db.User) {
+	teamJSON, err := teamMap(team, user)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get team "+team.Name)
+		return
+	}
+	respJSON, err := json.Marshal(teamJSON)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for teams action. Lists teams of current user with their members, storage usage and
+// path where team files are mounted in namespace of the user.
+//
+// HTTP codes returned:
+//	50x - server error processing request
+//	200 - Request succesful
+func teams(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	teams, err := user.GetTeams()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get teams of user "+user.Username)
+		return
+	}
+	teamsToReturn := make([]map[string]interface{}, len(teams))
+	for index, team := range teams {
+		if teamsToReturn[index], err = teamMap(&team, user); err != nil {
+			handleErr(w, 500, err, "Unable to get team "+team.Name)
+			return
+		}
+	}
+	respJSON, err := json.Marshal(teamsToReturn)
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for creating team. Current user becomes its owner. Team files are mounted in root
+// of namespace of each member under team name, so clients sync them as a subfolder of work dir.
+// Requires the following form parameters:
+//	name - name of the team
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect name)
+//	50x - server error processing request
+//	200 - Team created, returns the team
+func createTeam(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	if errs := db.ValidateTeamName(r.FormValue("name")); errs != nil {
+		handlePolicyErr(w, 400, errs)
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	team, err := user.CreateTeam(r.FormValue("name"))
+	if err != nil {
+		handleErr(w, 500, err, "Unable to create team "+r.FormValue("name"))
+		return
+	}
+	logger.Info("Team " + team.Name + " created by user " + user.Username)
+	audit(r, db.AuditEvent{Action: db.AuditShare, Details: "created team " + team.Name})
+	notifyMounted(user, team.ShareId)
+	writeTeam(w, team, user)
+}
+
+// Notifies sessions of user that shared folder with given id was mounted in their namespace.
+func notifyMounted(user *db.User, shareId int64) {
+	mounts, err := user.GetMounts()
+	if err != nil {
+		return
+	}
+	for _, mount := range mounts {
+		if mount.ShareId != shareId {
+			continue
+		}
+		if file, err := user.GetFileByPath(mount.MountPath); err == nil && file != nil {
+			if metadata, err := file.GetMetadata(nil); err == nil {
+				notifyChange(user, "", metadata)
+			}
+		}
+	}
+}
+
+// Handler function for teams/members action. Adds user to the team. Only team owners might add members.
+// Requires the following form parameters:
+//	id - id of the team
+//	username - user to add
+//	role - "owner", "editor" or "viewer"
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	403 - current user is not owner of the team
+//	404 - team or user does not exist
+//	409 - user is already a member
+//	50x - server error processing request
+//	200 - Member added
+func addTeamMember(w http.ResponseWriter, r *http.Request) {
+	team := memberTeam(w, r, true)
+	if team == nil {
+		return
+	}
+	member := shareMemberUser(w, r)
+	if member == nil {
+		return
+	}
+	if _, err := team.AddMember(member, r.FormValue("role")); err != nil {
+		handleTeamErr(w, err, "Unable to add user "+member.Username+" to team "+team.Name)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Details: "added member " + member.Username + " to team " + team.Name + " as " + r.FormValue("role")})
+	notifyMounted(member, team.ShareId)
+}
+
+// Handler function for teams/members/role action. Changes role of team member. Only team owners might change roles.
+// Requires the following form parameters:
+//	id - id of the team
+//	username - member
+//	role - "owner", "editor" or "viewer"
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	403 - current user is not owner of the team
+//	404 - team does not exist or user is not a member
+//	409 - the only owner would lose the role
+//	50x - server error processing request
+//	200 - Role changed
+func setTeamRole(w http.ResponseWriter, r *http.Request) {
+	team := memberTeam(w, r, true)
+	if team == nil {
+		return
+	}
+	member := shareMemberUser(w, r)
+	if member == nil {
+		return
+	}
+	if err := team.SetRole(member.Id, r.FormValue("role")); err != nil {
+		handleTeamErr(w, err, "Unable to change role of user "+member.Username+" in team "+team.Name)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Details: "changed role of member " + member.Username + " in team " + team.Name + " to " + r.FormValue("role")})
+}
+
+// Handler function for teams/members/remove action. Removes user from the team, team files are removed
+// from their namespace. Owners might remove any member, other members might only remove themselves to leave the team.
+// Requires the following form parameters:
+//	id - id of the team
+//	username - member to remove
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect parameter)
+//	403 - current user is not owner of the team and tried to remove other member
+//	404 - team does not exist or user is not a member
+//	409 - the only owner would be removed
+//	50x - server error processing request
+//	200 - Member removed
+func removeTeamMember(w http.ResponseWriter, r *http.Request) {
+	team := memberTeam(w, r, false)
+	if team == nil {
+		return
+	}
+	member := shareMemberUser(w, r)
+	if member == nil {
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	if member.Id != user.Id && team.GetRole(user.Id) != db.TeamOwner {
+		handleErr(w, 403, nil, "User "+user.Username+" is not owner of team "+team.Name)
+		return
+	}
+	members, err := db.GetShare(team.ShareId).GetMembers()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get members of team "+team.Name)
+		return
+	}
+	if err = team.RemoveMember(member.Id); err != nil {
+		handleTeamErr(w, err, "Unable to remove user "+member.Username+" from team "+team.Name)
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditShare, Details: "removed member " + member.Username + " from team " + team.Name})
+	for _, m := range members {
+		if m.UserId == member.Id {
+			notifyUnmounted([]db.ShareMember{m})
+		}
+	}
+}
+
+// Handler function for teams/delete action. Deletes the team, all members lose access immediately
+// and team files are removed in background. Only team owners might delete the team.
+// Requires the following form parameters:
+//	id - id of the team
+//
+// If successful, returns purge job removing team files.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing or incorrect id)
+//	403 - current user is not owner of the team
+//	404 - team does not exist
+//	50x - server error processing request
+//	202 - Deletion started
+func deleteTeam(w http.ResponseWriter, r *http.Request) {
+	team := memberTeam(w, r, true)
+	if team == nil {
+		return
+	}
+	members, err := db.GetShare(team.ShareId).GetMembers()
+	if err != nil {
+		handleErr(w, 500, err, "Unable to get members of team "+team.Name)
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	job, err := team.Delete(user.Username)
+	if err != nil {
+		handleErr(w, 500, err, "Unable to delete team "+team.Name)
+		return
+	}
+	logger.Info("Team " + team.Name + " deleted by user " + user.Username)
+	audit(r, db.AuditEvent{Action: db.AuditShare, Details: "deleted team " + team.Name})
+	notifyUnmounted(members)
+	wakePurgeWorker()
+	w.WriteHeader(202)
+	if job != nil {
+		jobJSON, err := json.Marshal(purgeJobMap(job))
+		if err != nil {
+			logger.WithField("error", err.Error()).Error("error marshaling json")
+			return
+		}
+		fmt.Fprintf(w, string(jobJSON))
+	}
+}
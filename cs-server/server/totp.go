@@ -0,0 +1,122 @@
+package server
+
+import (
+	"cloudsyncer/cs-server/db"
+	"cloudsyncer/toolkit"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/context"
+)
+
+// Issuer shown in authenticator applications.
+const totpIssuer = "Cloudsyncer"
+
+// Handler function for 2fa/enroll action. Generates new TOTP secret for current user.
+// Two-factor authentication is enabled only after the secret is confirmed with 2fa/verify.
+// If successful, returns secret and provisioning URI, which might be shown as QR code.
+//
+// HTTP codes returned:
+//	409 - two-factor authentication already enabled
+//	50x - server error processing request
+//	200 - Secret generated
+func enrollTotp(w http.ResponseWriter, r *http.Request) {
+	user := context.Get(r, "user").(*db.User)
+	secret, err := user.EnrollTotp()
+	if err == db.ErrEntityAlreadyExists {
+		handleErr(w, 409, nil, "Two-factor authentication already enabled for user "+user.Username)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to enroll two-factor authentication")
+		return
+	}
+	respJSON, err := json.Marshal(map[string]string{
+		"secret": secret,
+		"uri":    toolkit.TotpProvisioningURI(secret, totpIssuer, user.Username),
+	})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for 2fa/verify action. Confirms enrollment and enables two-factor authentication.
+// Requires the following form parameters:
+//	code - one-time password from authenticator application
+//
+// If successful, returns recovery codes. Codes are returned only once.
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, enrollment not started)
+//	403 - wrong one-time password
+//	50x - server error processing request
+//	200 - Two-factor authentication enabled
+func verifyTotp(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	if r.FormValue("code") == "" {
+		handleErr(w, 400, nil, "code not provided")
+		return
+	}
+	codes, err := user.VerifyTotp(r.FormValue("code"))
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 400, nil, "Two-factor authentication enrollment not started for user "+user.Username)
+		return
+	}
+	if err == db.ErrInvalidCode {
+		handleErr(w, 403, nil, "Wrong one-time password for user "+user.Username)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to enable two-factor authentication")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAccount, Details: "enabled two-factor authentication"})
+	respJSON, err := json.Marshal(map[string][]string{"recovery_codes": codes})
+	if err != nil {
+		handleErr(w, 500, err, "error marshaling json")
+		return
+	}
+	fmt.Fprintf(w, string(respJSON))
+}
+
+// Handler function for 2fa/disable action.
+// Requires the following form parameters:
+//	code - one-time password or recovery code
+//
+// HTTP codes returned:
+//	400 - request invalid (missing parameter, two-factor authentication not enabled)
+//	403 - wrong one-time password
+//	50x - server error processing request
+//	200 - Two-factor authentication disabled
+func disableTotp(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		handleErr(w, 400, err, "Failed to parse form")
+		return
+	}
+	user := context.Get(r, "user").(*db.User)
+	if r.FormValue("code") == "" {
+		handleErr(w, 400, nil, "code not provided")
+		return
+	}
+	err := user.DisableTotp(r.FormValue("code"))
+	if err == db.ErrEntityNotExists {
+		handleErr(w, 400, nil, "Two-factor authentication not enabled for user "+user.Username)
+		return
+	}
+	if err == db.ErrInvalidCode {
+		handleErr(w, 403, nil, "Wrong one-time password for user "+user.Username)
+		return
+	}
+	if err != nil {
+		handleErr(w, 500, err, "Unable to disable two-factor authentication")
+		return
+	}
+	audit(r, db.AuditEvent{Action: db.AuditAccount, Details: "disabled two-factor authentication"})
+}
@@ -83,3 +83,16 @@ func Retrieve(uuid string) (file ReaderSeekerCloser, err error) {
 	return file, nil
 
 }
+
+// Removes file identified by uuid. Removing file which does not exist is not an error,
+// so interrupted removal might be safely repeated. Returns error if file might not be removed.
+func Remove(uuid string) error {
+	dir := strings.Split(uuid, "-")
+	if len(dir) < 2 || dir[0] == "" {
+		return errors.New("Error: invalid uuid")
+	}
+	if err := os.Remove(config.DATA_DIR + string(os.PathSeparator) + dir[0] + string(os.PathSeparator) + uuid); err != nil && !os.IsNotExist(err) {
+		return errors.New("Error: unable to remove the file: " + err.Error())
+	}
+	return nil
+}
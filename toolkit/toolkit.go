@@ -3,6 +3,7 @@ package toolkit
 import (
 	"crypto/rand"
 	"crypto/sha1"
+	"crypto/sha256"
 	"fmt"
 	"os"
 	"path"
@@ -15,11 +16,19 @@ func GetSha1(input []byte) string {
 	return fmt.Sprintf("%x", hasher.Sum(nil))
 }
 
+func GetSha256(input []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(input))
+}
+
 func GetRandHex(length int) string {
+	var str = fmt.Sprintf("%x", GetRandBytes((length+1)/2))
+	return str[:length]
+}
+
+func GetRandBytes(length int) []byte {
 	var buf = make([]byte, length)
 	rand.Read(buf)
-	var str = fmt.Sprintf("%x", buf)
-	return str[:length-1]
+	return buf
 }
 
 func Exists(path string) bool {
@@ -0,0 +1,55 @@
+package toolkit
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Parameters of time-based one-time passwords (RFC 6238), compatible with common authenticator applications.
+const (
+	TotpPeriod = 30
+	TotpDigits = 6
+)
+
+// Returns new random TOTP secret, encoded with base32 without padding.
+func NewTotpSecret() string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(GetRandBytes(20))
+}
+
+// Returns time step for given time.
+func TotpStep(t time.Time) int64 {
+	return t.Unix() / TotpPeriod
+}
+
+// Returns one-time password for given base32 encoded secret and time step. Returns empty string if secret is invalid.
+func TotpCode(secret string, step int64) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return ""
+	}
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TotpDigits, code%uint32(math.Pow10(TotpDigits)))
+}
+
+// Returns otpauth:// URI which might be shown as QR code and scanned by authenticator application.
+func TotpProvisioningURI(secret string, issuer string, account string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("period", fmt.Sprint(TotpPeriod))
+	params.Set("digits", fmt.Sprint(TotpDigits))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + params.Encode()
+}
@@ -0,0 +1,79 @@
+package toolkit
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+// Secret "12345678901234567890" used by test vectors of RFC 6238, encoded with base32.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpCode(t *testing.T) {
+	tests := []struct {
+		secret   string
+		time     int64
+		expected string
+	}{
+		{rfcSecret, 59, "287082"},
+		{rfcSecret, 1111111109, "081804"},
+		{rfcSecret, 1111111111, "050471"},
+		{rfcSecret, 1234567890, "005924"},
+		{rfcSecret, 2000000000, "279037"},
+		{rfcSecret, 20000000000, "353130"},
+		{"gezdgnbvgy3tqojqgezdgnbvgy3tqojq", 59, "287082"},
+		{"not base32!", 59, ""},
+	}
+	for _, test := range tests {
+		if code := TotpCode(test.secret, TotpStep(time.Unix(test.time, 0))); code != test.expected {
+			t.Errorf("TotpCode(%q) at %d = %q, expected %q", test.secret, test.time, code, test.expected)
+		}
+	}
+}
+
+func TestTotpStep(t *testing.T) {
+	tests := []struct {
+		time     int64
+		expected int64
+	}{
+		{0, 0},
+		{29, 0},
+		{30, 1},
+		{59, 1},
+		{1111111109, 37037036},
+	}
+	for _, test := range tests {
+		if step := TotpStep(time.Unix(test.time, 0)); step != test.expected {
+			t.Errorf("TotpStep(%d) = %d, expected %d", test.time, step, test.expected)
+		}
+	}
+}
+
+func TestNewTotpSecret(t *testing.T) {
+	secret := NewTotpSecret()
+	if len(secret) != 32 {
+		t.Errorf("NewTotpSecret returned %q, expected 32 characters", secret)
+	}
+	if TotpCode(secret, 1) == "" {
+		t.Errorf("NewTotpSecret returned secret %q which is not valid base32", secret)
+	}
+	if NewTotpSecret() == secret {
+		t.Errorf("NewTotpSecret returned the same secret twice")
+	}
+}
+
+func TestTotpProvisioningURI(t *testing.T) {
+	uri, err := url.Parse(TotpProvisioningURI(rfcSecret, "CloudSyncer", "alice"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/CloudSyncer:alice" {
+		t.Errorf("unexpected provisioning URI %s", uri)
+	}
+	query := uri.Query()
+	for key, expected := range map[string]string{"secret": rfcSecret, "issuer": "CloudSyncer", "period": "30", "digits": "6"} {
+		if query.Get(key) != expected {
+			t.Errorf("provisioning URI parameter %s = %q, expected %q", key, query.Get(key), expected)
+		}
+	}
+}